@@ -0,0 +1,325 @@
+package disassemble
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Splits "ldr x0, [x1, #16]" into "ldr" and ["x0", "[x1, #16]"]
+func splitOperands(line string) (string, []string) {
+    mnemonic, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+    operands := []string{}
+    depth := 0
+    start := 0
+
+    for i := 0; i < len(rest); i++ {
+        switch rest[i] {
+        case '[':
+            depth++
+        case ']':
+            depth--
+        case ',':
+            if depth == 0 {
+                operands = append(operands, strings.TrimSpace(rest[start:i]))
+                start = i+1
+            }
+        }
+    }
+    if len(strings.TrimSpace(rest)) > 0 {
+        operands = append(operands, strings.TrimSpace(rest[start:]))
+    }
+
+    return mnemonic, operands
+}
+
+func parseImmediate(imm string) (int, bool) {
+    imm = strings.TrimPrefix(imm, "#")
+    neg := false
+    if rest, ok := strings.CutPrefix(imm, "-"); ok {
+        neg = true
+        imm = rest
+    }
+
+    var parsed uint64
+    var err error
+    if hex, ok := strings.CutPrefix(imm, "0x"); ok {
+        parsed, err = strconv.ParseUint(hex, 16, 64)
+    } else {
+        parsed, err = strconv.ParseUint(imm, 10, 64)
+    }
+    if err != nil {
+        return 0, false
+    }
+
+    if neg {
+        return -int(parsed), true
+    }
+    return int(parsed), true
+}
+
+// x0 and w0 are the same register
+func sameRegister(a string, b string) bool {
+    if len(a) < 2 || len(b) < 2 {
+        return false
+    }
+    if (a[0] != 'x' && a[0] != 'w') || (b[0] != 'x' && b[0] != 'w') {
+        return false
+    }
+
+    return a[1:] == b[1:]
+}
+
+// Replaces "630 <puts@plt>" with "puts"
+func symbolizeTarget(operand string) string {
+    if !strings.Contains(operand, "<") || !strings.HasSuffix(operand, ">") {
+        return operand
+    }
+
+    symbol := operand[strings.Index(operand, "<")+1:len(operand)-1]
+    if ext, ok := strings.CutSuffix(symbol, "@plt"); ok {
+        symbol = ext
+    }
+
+    return symbol
+}
+
+// Where an adrp page ends up being used, and what relocation it needs to be rewritten with
+type pageUse struct {
+    line int
+    offset int
+}
+
+func findPageUses(content []string, adrp int, reg string) []pageUse {
+    uses := []pageUse{}
+
+    for i := adrp+1; i < len(content); i++ {
+        mnemonic, operands := splitOperands(content[i])
+        if len(operands) == 0 {
+            continue
+        }
+
+        if mnemonic == "add" && len(operands) == 3 && sameRegister(operands[1], reg) {
+            if offset, ok := parseImmediate(operands[2]); ok {
+                uses = append(uses, pageUse{i, offset})
+            }
+        } else {
+            for _, operand := range operands {
+                if !strings.HasPrefix(operand, "[" + reg) {
+                    continue
+                }
+                inner := strings.TrimSuffix(strings.TrimPrefix(operand, "["), "]")
+                base, imm, hasImm := strings.Cut(inner, ",")
+                if base != reg {
+                    continue
+                }
+                offset := 0
+                if hasImm {
+                    parsed, ok := parseImmediate(strings.TrimSpace(imm))
+                    if !ok {
+                        continue
+                    }
+                    offset = parsed
+                }
+                uses = append(uses, pageUse{i, offset})
+            }
+        }
+
+        // Stores and compares only read their first operand
+        if sameRegister(operands[0], reg) && !strings.HasPrefix(mnemonic, "st") && !strings.HasPrefix(mnemonic, "cmp") && !strings.HasPrefix(mnemonic, "cb") && !strings.HasPrefix(mnemonic, "tb") {
+            break
+        }
+        if mnemonic == "b" || mnemonic == "ret" || mnemonic == "br" {
+            break
+        }
+    }
+
+    return uses
+}
+
+// Rewrites the use of reg's page to go through base instead, with reloc as the offset
+func rewritePageOffset(line string, reg string, base string, reloc string) string {
+    mnemonic, operands := splitOperands(line)
+
+    if mnemonic == "add" {
+        operands[1] = base
+        operands[2] = reloc
+    } else {
+        for i, operand := range operands {
+            if strings.HasPrefix(operand, "[" + reg) {
+                operands[i] = fmt.Sprintf("[%s, %s]", base, reloc)
+            }
+        }
+    }
+
+    return mnemonic + " " + strings.Join(operands, ", ")
+}
+
+// The register a use of a page can take its own adrp in, which is the one it writes to.
+// Stores don't write to one, and neither do loads into floating point registers.
+func ownPageRegister(line string) (string, bool) {
+    mnemonic, operands := splitOperands(line)
+    if len(operands) != 2 && !(mnemonic == "add" && len(operands) == 3) {
+        return "", false
+    }
+    dest := operands[0]
+    if len(dest) < 2 || dest == "xzr" || dest == "wzr" {
+        return "", false
+    }
+    if _, err := strconv.Atoi(dest[1:]); err != nil {
+        return "", false
+    }
+
+    switch mnemonic {
+    case "add":
+        if dest[0] == 'x' {
+            return dest, true
+        }
+    case "ldr", "ldrb", "ldrh", "ldrsb", "ldrsh", "ldrsw":
+        if dest[0] == 'x' || dest[0] == 'w' {
+            return "x" + dest[1:], true
+        }
+    }
+
+    return "", false
+}
+
+// A64 addresses data with an adrp (page of the symbol) followed by an add or a load/store with the offset into the page.
+// Both halves need to be rewritten, with :got: relocations when the page offset lands on a GOT slot.
+// Everything else with a resolved target (bl, b, adr, literal ldr) just gets the symbol, or the label of a branch target.
+// The adrp takes the symbol of its first use. Uses of other symbols on the same page (GCC's section anchors do that) get an adrp
+// of their own, into the register they write to, since the symbols needn't share a page once they are linked again.
+func PatchAssemblyA64(sections []Section, globals []Data, literals []Data, info GotInfo) []Section {
+    output := make([]Section, 0, len(sections))
+    starts := newFunctionStarts(sections)
+
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            code := make([]string, len(fun.Content))
+            for i, line := range fun.Content {
+                if comment := strings.Index(line, " //"); comment != -1 {
+                    line = strings.TrimSpace(line[:comment])
+                }
+                code[i] = line
+            }
+
+            patched := make([]bool, len(code))
+            // Lines that need an adrp of their own before them
+            adrps := map[int]string{}
+
+            for i, line := range code {
+                if patched[i] {
+                    continue
+                }
+                if label, ok := gasLabelDefinition(line, fun); ok {
+                    code[i] = label
+                    continue
+                }
+
+                mnemonic, operands := splitOperands(line)
+
+                if mnemonic == "adrp" && len(operands) == 2 {
+                    reg := operands[0]
+                    pageHex, _, _ := strings.Cut(operands[1], " ")
+                    page, err := strconv.ParseUint(strings.TrimPrefix(pageHex, "0x"), 16, 64)
+                    if err != nil {
+                        continue
+                    }
+
+                    uses := findPageUses(code, i, reg)
+                    pageSymbol := ""
+                    rewritten := map[int]string{}
+                    own := map[int]string{}
+                    for _, use := range uses {
+                        target := int(page) + use.offset
+                        symbol, reloc := "", ""
+                        if got, ok := info.GotSymbols[target]; ok {
+                            symbol, reloc = ":got:" + got, ":got_lo12:" + got
+                        } else if data, ok := FindDataAt(target, globals, literals); ok {
+                            symbol, reloc = data, ":lo12:" + data
+                        } else {
+                            continue
+                        }
+
+                        if pageSymbol == "" {
+                            pageSymbol = symbol
+                        }
+                        if symbol == pageSymbol {
+                            rewritten[use.line] = rewritePageOffset(code[use.line], reg, reg, reloc)
+                        } else if base, ok := ownPageRegister(code[use.line]); ok {
+                            own[use.line] = fmt.Sprintf("adrp %s, %s", base, symbol)
+                            rewritten[use.line] = rewritePageOffset(code[use.line], reg, base, reloc)
+                        } else {
+                            // Left as it is, for checkPageAddresses to refuse
+                            pageSymbol = ""
+                            break
+                        }
+                    }
+
+                    if pageSymbol != "" {
+                        code[i] = fmt.Sprintf("adrp %s, %s", reg, pageSymbol)
+                        for line, rewrite := range rewritten {
+                            code[line] = rewrite
+                            patched[line] = true
+                        }
+                        for line, adrp := range own {
+                            adrps[line] = adrp
+                        }
+                    }
+                    continue
+                }
+
+                for j, operand := range operands {
+                    if label, ok := gasBranchTarget(operand, starts); ok && isArchBranch(ArchAArch64, mnemonic) {
+                        operands[j] = label
+                        continue
+                    }
+                    operands[j] = symbolizeTarget(operand)
+                }
+                if len(operands) > 0 {
+                    code[i] = mnemonic + " " + strings.Join(operands, ", ")
+                }
+            }
+
+            content := make([]string, 0, len(code) + len(adrps))
+            for i, line := range code {
+                if adrp, ok := adrps[i]; ok {
+                    content = append(content, adrp)
+                }
+                content = append(content, line)
+            }
+
+            funcs = append(funcs, AssemblyFunction{fun.Name, fun.Address, content, fun.Info})
+        }
+
+        output = append(output, Section{section.Name, funcs})
+    }
+
+    return output
+}
+
+// adrp and auipc only make sense next to the address they were at, so the ones the patches couldn't turn into symbols can't be extracted
+func (o Object) checkPageAddresses() error {
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            for _, line := range fun.Content {
+                mnemonic, operands := splitOperands(line)
+                if len(operands) != 2 {
+                    continue
+                }
+                if o.Arch == ArchAArch64 && mnemonic == "adrp" {
+                    if _, ok := parseImmediate(strings.Fields(operands[1])[0]); ok {
+                        return fmt.Errorf("%s has `%s`, and not everything using that page could be turned back into a symbol", fun.Name, line)
+                    }
+                }
+                if o.Arch == ArchRISCV64 && mnemonic == "auipc" && !strings.HasPrefix(operands[1], "%") {
+                    return fmt.Errorf("%s has `%s`, which nothing after it turns into an address with a symbol", fun.Name, line)
+                }
+            }
+        }
+    }
+
+    return nil
+}
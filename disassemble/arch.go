@@ -0,0 +1,149 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+)
+
+type Arch int
+
+const (
+    ArchX86_64 Arch = iota
+    ArchI386
+    ArchAArch64
+    ArchRISCV64
+)
+
+func GetArch(file string) (Arch, error) {
+    f, err := elf.Open(file)
+    if err != nil {
+        if p, err := pe.Open(file); err == nil {
+            defer p.Close()
+            // Only PE32+
+            if p.Machine == pe.IMAGE_FILE_MACHINE_AMD64 {
+                return ArchX86_64, nil
+            }
+            return ArchX86_64, errors.New("Unsupported PE machine type")
+        }
+        if m, err := macho.Open(file); err == nil {
+            defer m.Close()
+            switch m.Cpu {
+            case macho.CpuAmd64:
+                return ArchX86_64, nil
+            case macho.CpuArm64:
+                return ArchAArch64, nil
+            }
+            return ArchX86_64, errors.New("Unsupported Mach-O CPU type " + m.Cpu.String())
+        }
+        return ArchX86_64, err
+    }
+    defer f.Close()
+
+    return archFromELF(f)
+}
+
+func archFromELF(f *elf.File) (Arch, error) {
+    switch f.Machine {
+    case elf.EM_X86_64:
+        return ArchX86_64, nil
+    case elf.EM_386:
+        return ArchI386, nil
+    case elf.EM_AARCH64:
+        return ArchAArch64, nil
+    case elf.EM_RISCV:
+        if f.Class != elf.ELFCLASS64 {
+            break
+        }
+        return ArchRISCV64, nil
+    }
+
+    return ArchX86_64, errors.New("Unsupported architecture " + f.Machine.String())
+}
+
+func (a Arch) String() string {
+    switch a {
+    case ArchI386:
+        return "i386"
+    case ArchAArch64:
+        return "aarch64"
+    case ArchRISCV64:
+        return "riscv64"
+    }
+
+    return "x86_64"
+}
+
+// The -f argument nasm needs to emit an object file for this architecture
+func (a Arch) NasmFormat() string {
+    if a == ArchI386 {
+        return "elf32"
+    }
+
+    return "elf64"
+}
+
+// nasm only does x86, everything else goes through the GNU assembler
+func (a Arch) UsesNasm() bool {
+    return a == ArchX86_64 || a == ArchI386
+}
+
+// The host binutils only understand the host architecture, so foreign ones need the cross binutils
+func (a Arch) Objdump() string {
+    switch a {
+    case ArchAArch64:
+        return "aarch64-linux-gnu-objdump"
+    case ArchRISCV64:
+        return "riscv64-linux-gnu-objdump"
+    }
+
+    return "objdump"
+}
+
+func (a Arch) Assembler() string {
+    switch a {
+    case ArchAArch64:
+        return "aarch64-linux-gnu-as"
+    case ArchRISCV64:
+        return "riscv64-linux-gnu-as"
+    }
+
+    return "as"
+}
+
+// The C compiler that links for this architecture, and what it needs to be told to
+func (a Arch) Compiler() (string, []string) {
+    switch a {
+    case ArchI386:
+        return "cc", []string{"-m32"}
+    case ArchAArch64:
+        return "aarch64-linux-gnu-gcc", []string{}
+    case ArchRISCV64:
+        return "riscv64-linux-gnu-gcc", []string{}
+    }
+
+    return "cc", []string{}
+}
+
+// GNU objcopy only knows ELF and PE, llvm-objcopy does Mach-O too
+func objcopyCommand(format Format) string {
+    if format == FormatMachO {
+        return "llvm-objcopy"
+    }
+
+    return "objcopy"
+}
+
+func objdumpCommand(file string) string {
+    if format, err := GetFormat(file); err == nil && format == FormatMachO {
+        return "llvm-objdump"
+    }
+
+    arch, err := GetArch(file)
+    if err != nil {
+        return "objdump"
+    }
+
+    return arch.Objdump()
+}
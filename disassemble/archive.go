@@ -0,0 +1,84 @@
+package disassemble
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An object file in a static library
+type ArchiveMember struct {
+    Name string
+    Data []byte
+}
+
+const archiveMagic = "!<arch>\n"
+
+// Reads a static library. Both the GNU (// name table) and BSD (#1/len) long name styles are understood.
+// A plain object file is read as an archive with just itself in it, so crt1.o and friends work the same way.
+func ReadArchive(file string) ([]ArchiveMember, error) {
+    content, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+
+    if !bytes.HasPrefix(content, []byte(archiveMagic)) {
+        return []ArchiveMember{{file, content}}, nil
+    }
+
+    members := []ArchiveMember{}
+    names := []byte{}
+    content = content[len(archiveMagic):]
+    for len(content) >= 60 {
+        header := content[:60]
+        content = content[60:]
+
+        name := strings.TrimRight(string(header[0:16]), " ")
+        size, err := strconv.Atoi(strings.TrimSpace(string(header[48:58])))
+        if err != nil || size < 0 || size > len(content) {
+            return nil, errors.New("Corrupt archive " + file)
+        }
+        data := content[:size]
+        // Members are aligned to 2 bytes
+        content = content[size+size%2:]
+
+        switch {
+        case name == "/" || name == "/SYM64/" || strings.HasPrefix(name, "__.SYMDEF"):
+            // Symbol index, we read the symbols ourselves
+            continue
+        case name == "//":
+            names = data
+            continue
+        case strings.HasPrefix(name, "#1/"):
+            length, err := strconv.Atoi(name[3:])
+            if err != nil || length > len(data) {
+                return nil, errors.New("Corrupt archive " + file)
+            }
+            name = strings.TrimRight(string(data[:length]), "\x00")
+            data = data[length:]
+        case strings.HasPrefix(name, "/"):
+            offset, err := strconv.Atoi(name[1:])
+            if err != nil || offset > len(names) {
+                return nil, errors.New("Corrupt archive " + file)
+            }
+            name = string(names[offset:])
+            if end := strings.Index(name, "/\n"); end != -1 {
+                name = name[:end]
+            }
+        default:
+            name = strings.TrimSuffix(name, "/")
+        }
+
+        members = append(members, ArchiveMember{name, data})
+    }
+
+    return members, nil
+}
+
+// Opens the member as an ELF object, if it is one
+func (m ArchiveMember) ELF() (*elf.File, error) {
+    return elf.NewFile(bytes.NewReader(m.Data))
+}
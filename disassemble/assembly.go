@@ -9,7 +9,10 @@ import (
 
 type AssemblyFunction struct {
     Name string
+    // Where it was in the executable
+    Address int
     Content []string
+    Info SymbolInfo
 }
 
 type Section struct {
@@ -22,13 +25,21 @@ type Data struct {
     Location int
     Data []byte
     Extern bool
+    // Offset -> what the pointer there points at, for data that needs relocating
+    Pointers map[int]string
+    Info SymbolInfo
 }
 
 func GetDumpedData(file string, segment string) ([]Data, error) {
-    cmd := exec.Command("objdump", "-d", file, "-j", segment, "-z")
+    cmd := exec.Command(objdumpCommand(file), "-d", file, "-j", segment, "-z")
 
     buf, err := cmd.CombinedOutput()
 
+    if strings.Contains(string(buf), "not found in any input file") {
+        // Plenty of executables just don't have some sections
+        return []Data{}, nil
+    }
+
     if err != nil {
         return nil, err
     }
@@ -51,13 +62,18 @@ func GetDumpedData(file string, segment string) ([]Data, error) {
             continue
         }
 
-        if name, ok := strings.CutSuffix(line, ":"); ok {
+        // Bytes can disassemble into something ending with a colon too, but labels never have a tab
+        if name, ok := strings.CutSuffix(line, ":"); ok && !strings.ContainsRune(line, rune(9)) {
             loc, err := strconv.ParseUint(name[0:strings.Index(name, " ")], 16, 64)
             if err != nil {
                 return nil, err
             }
             name = name[strings.Index(name, "<")+1:len(name)-1]
-            data = append(data, Data{name, int(loc), []byte{}, false})
+            if strings.HasPrefix(name, ".") {
+                // Stripped, so the section is all there is. Named like Mach-O C strings.
+                name = fmt.Sprintf("%s_%x", strings.TrimLeft(name, "."), loc)
+            }
+            data = append(data, Data{name, int(loc), []byte{}, false, nil, SymbolInfo{}})
         } else if strings.ContainsRune(line, rune(9)) {
             rawBytes := strings.Split(strings.Split(line, string(rune(9)))[1], " ")
             bytes := []byte{}
@@ -65,11 +81,14 @@ func GetDumpedData(file string, segment string) ([]Data, error) {
                 if len(b) == 0 {
                     break
                 }
-                parsed, err := strconv.ParseUint(b, 16, 8)
-                if err != nil {
-                    return nil, err
+                // Fixed width architectures dump whole little endian words instead of single bytes
+                for i := len(b); i >= 2; i -= 2 {
+                    parsed, err := strconv.ParseUint(b[i-2:i], 16, 8)
+                    if err != nil {
+                        return nil, err
+                    }
+                    bytes = append(bytes, byte(parsed))
                 }
-                bytes = append(bytes, byte(parsed))
             }
             last := len(data)-1
             data[last].Data = append(data[last].Data, bytes...)
@@ -98,14 +117,29 @@ func GetDumpedGlobalData(file string) ([]Data, error) {
     return global, nil
 }
 
+func GetDumpedVariableData(file string) ([]Data, error) {
+    // Just like globals, except they start with a value
+    variables, err := GetDumpedData(file, ".data")
+    if err != nil {
+        return nil, err
+    }
+
+    for i, v := range variables {
+        v.Extern = true
+        variables[i] = v
+    }
+
+    return variables, nil
+}
+
 func FindExternSymbols(sections []Section) []string {
     symbols := []string{}
 
     for _, section := range sections {
         if section.Name == ".plt" {
             for _, fun := range section.Funcs {
-                if strings.Contains(fun.Name, "-") {
-                    continue // assume it is insignificant thunk stuff
+                if strings.Contains(fun.Name, "-") || strings.HasPrefix(fun.Name, "*ABS*") {
+                    continue // assume it is insignificant thunk stuff, or IFUNCs in static executables
                 }
 
                 if name, ok := strings.CutSuffix(fun.Name, "@plt"); ok {
@@ -122,7 +156,8 @@ func RemoveJunk(sections []Section) []Section {
     useful := make([]Section, 0, len(sections))
 
     for _, section := range sections {
-        if section.Name == ".init" || section.Name == ".plt" || section.Name == ".fini" {
+        // .plt.got and .plt.sec are more PLT entries, which the linker makes again
+        if section.Name == ".init" || section.Name == ".plt" || section.Name == ".plt.got" || section.Name == ".plt.sec" || section.Name == ".fini" {
             continue
         }
 
@@ -144,7 +179,14 @@ func RemoveJunk(sections []Section) []Section {
 }
 
 func GetDumpedAssembly(file string) ([]Section, error) {
-    cmd := exec.Command("objdump", "-d", "-M", "intel noprefix", "--no-show-raw-insn", file)
+    cmd := exec.Command(objdumpCommand(file), "-d", "-M", "intel noprefix", "--no-show-raw-insn", file)
+    if arch, err := GetArch(file); err == nil && !arch.UsesNasm() {
+        cmd = exec.Command(objdumpCommand(file), "-d", "--no-show-raw-insn", file)
+    } else if format, err := GetFormat(file); err == nil && format == FormatMachO {
+        cmd = exec.Command(objdumpCommand(file), "-d", "-M", "intel", "--no-show-raw-insn", file)
+    }
+
+    llvm := cmd.Args[0] == "llvm-objdump"
 
     buf, err := cmd.CombinedOutput()
 
@@ -158,7 +200,21 @@ func GetDumpedAssembly(file string) ([]Section, error) {
     for i := range lines {
         lines[i] = strings.TrimSpace(lines[i])
     }
+
+    // Stripped executables need their code split into functions first
+    if format, err := GetFormat(file); err == nil && format == FormatELF {
+        if arch, err := GetArch(file); err == nil && arch.UsesNasm() {
+            if entry, known, ok := getEntryPoints(file); ok {
+                lines = splitAnonymousCode(lines, entry, known)
+            }
+        }
+    }
     
+    targets := map[string]map[int]bool{}
+    if arch, err := GetArch(file); err == nil {
+        targets = collectBranchTargets(arch, lines)
+    }
+
     sections := []Section{}
 
     for _, line := range lines {
@@ -174,15 +230,29 @@ func GetDumpedAssembly(file string) ([]Section, error) {
             section := section[:len(section)-1]
 
             sections = append(sections, Section{section, []AssemblyFunction{}});
-        } else if funcName, ok := strings.CutSuffix(line, ":"); ok {
+        } else if funcName, ok := strings.CutSuffix(line, ":"); ok && !strings.ContainsRune(line, rune(9)) {
+            address, _ := strconv.ParseUint(strings.TrimSpace(funcName[:strings.Index(funcName, "<")]), 16, 64)
             funcName = funcName[strings.Index(funcName, "<")+1:len(funcName)-1]
             last := len(sections)-1
-            sections[last].Funcs = append(sections[last].Funcs, AssemblyFunction{funcName, []string{}})
+            sections[last].Funcs = append(sections[last].Funcs, AssemblyFunction{funcName, int(address), []string{}, SymbolInfo{}})
         } else if strings.ContainsRune(line, rune(9)) {
             code := line[strings.IndexRune(line, rune(9))+1:]
             code = strings.ReplaceAll(code, " PTR", "")
+            // Some architectures separate the mnemonic from the operands with a tab
+            code = strings.ReplaceAll(code, string(rune(9)), " ")
+            if llvm {
+                // llvm-objdump, which is the only thing that reads Mach-O, has its own style
+                code = strings.ReplaceAll(code, " ptr", "")
+                code = strings.ReplaceAll(code, "## ", "# ")
+                code = strings.ReplaceAll(code, "; ", "// ")
+            }
             last := len(sections)-1
             lastFun := len(sections[last].Funcs)-1
+            if address, _, ok := splitDumpLine(line); ok {
+                if label, ok := branchTargetLabel(targets[sections[last].Name], sections[last].Funcs[lastFun].Address, address); ok {
+                    sections[last].Funcs[lastFun].Content = append(sections[last].Funcs[lastFun].Content, label)
+                }
+            }
             sections[last].Funcs[lastFun].Content = append(sections[last].Funcs[lastFun].Content, code)
         }
     }
@@ -190,8 +260,56 @@ func GetDumpedAssembly(file string) ([]Section, error) {
     return sections, nil
 }
 
+// Rewrites the <symbol> objdump shows next to addresses, in both branch targets like "call 1040 <printf@plt>"
+// and comments like "# 4024 <x>", with whatever resolve names the address.
+// Comments with a bare address get a <symbol> added.
+func NameAddresses(sections []Section, resolve func(int) (string, bool)) []Section {
+    output := make([]Section, 0, len(sections))
+
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            code := make([]string, 0, len(fun.Content))
+
+            for _, line := range fun.Content {
+                if strings.Contains(line, " <") && strings.HasSuffix(line, ">") {
+                    start := strings.LastIndex(line, " <")
+                    before := line[:start]
+                    hex := before[strings.LastIndexAny(before, " ,")+1:]
+                    addr, err := strconv.ParseUint(strings.TrimPrefix(hex, "0x"), 16, 64)
+                    if err == nil {
+                        if symbol, ok := resolve(int(addr)); ok {
+                            line = fmt.Sprintf("%s <%s>", before, symbol)
+                        }
+                    }
+                } else if strings.Contains(line, "# ") {
+                    hex := strings.TrimSpace(line[strings.LastIndex(line, "# ")+2:])
+                    addr, err := strconv.ParseUint(strings.TrimPrefix(hex, "0x"), 16, 64)
+                    if err == nil {
+                        if symbol, ok := resolve(int(addr)); ok {
+                            line = fmt.Sprintf("%s <%s>", line, symbol)
+                        }
+                    }
+                }
+
+                code = append(code, line)
+            }
+
+            funcs = append(funcs, AssemblyFunction{fun.Name, fun.Address, code, fun.Info})
+        }
+
+        output = append(output, Section{section.Name, funcs})
+    }
+
+    return output
+}
+
+// Turns what objdump wrote into what nasm takes: <symbol> after addresses becomes the symbol, and RIP-relative
+// addresses with a # <symbol> comment become [rel symbol]. Branches into functions go to their labels (see branchLabel).
 func MonkeyPatchAssembly(sections []Section, globals []Data, literals []Data, symbols []string) []Section {
     output := make([]Section, 0, len(sections))
+    starts := newFunctionStarts(sections)
 
     for _, section := range sections {
         funcs := make([]AssemblyFunction, 0, len(section.Funcs))
@@ -201,6 +319,10 @@ func MonkeyPatchAssembly(sections []Section, globals []Data, literals []Data, sy
 
             for _, line := range fun.Content {
                 if !strings.Contains(line, "# ") {
+                    if branch, ok := rewriteBranch(line, fun, starts); ok {
+                        code = append(code, branch)
+                        continue
+                    }
                     if strings.Contains(line, "<") {
                         symbol := line[strings.Index(line, "<")+1:strings.Index(line, ">")]
                         if ext, ok := strings.CutSuffix(symbol, "@plt"); ok {
@@ -217,6 +339,12 @@ func MonkeyPatchAssembly(sections []Section, globals []Data, literals []Data, sy
                 assembly := line[0:strings.Index(line, "# ")]
                 comment := line[strings.Index(line, "# ")+2:]
 
+                if !strings.Contains(comment, "<") {
+                    // Nothing to name the address after
+                    code = append(code, assembly)
+                    continue
+                }
+
                 symbol := comment[strings.Index(comment, "<")+1:strings.Index(comment, ">")]
                 // Relative address, assembly needs patching
                 if strings.Contains(assembly, "[rip") {
@@ -229,7 +357,7 @@ func MonkeyPatchAssembly(sections []Section, globals []Data, literals []Data, sy
                 code = append(code, assembly)
             }
 
-            funcs = append(funcs, AssemblyFunction{fun.Name, code})
+            funcs = append(funcs, AssemblyFunction{fun.Name, fun.Address, code, fun.Info})
         }
 
         output = append(output, Section{section.Name, funcs})
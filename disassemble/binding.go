@@ -0,0 +1,389 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"strings"
+)
+
+// How a symbol is bound. Everything is global unless told otherwise
+type Binding int
+
+const (
+    BindingGlobal Binding = iota
+    // Only this object file sees it, like a static function
+    BindingLocal
+    // Another object file can define it instead
+    BindingWeak
+)
+
+type Visibility int
+
+const (
+    VisibilityDefault Visibility = iota
+    // Global inside the shared library or executable it is linked into, but not outside it
+    VisibilityHidden
+    // Exported, but always the definition from the same library
+    VisibilityProtected
+    VisibilityInternal
+)
+
+type SymbolType int
+
+const (
+    // Unknown, like for everything in stripped executables
+    SymbolNoType SymbolType = iota
+    SymbolFunction
+    SymbolObject
+    SymbolTLS
+)
+
+// What the symbol table says about a symbol, besides its name and address
+type SymbolInfo struct {
+    Binding Binding
+    Visibility Visibility
+    Type SymbolType
+    // In bytes, 0 if unknown
+    Size int
+}
+
+// Symbol name -> its info, from .symtab, or .dynsym for what .symtab doesn't have.
+// Local symbols can have the same name in different files, so they are under the name DisambiguateSymbols gives them too.
+func GetSymbolInfo(file string) (map[string]SymbolInfo, error) {
+    f, err := elf.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    symbols, err := f.Symbols()
+    if err != nil && err != elf.ErrNoSymbols {
+        return nil, err
+    }
+    dynamic, err := f.DynamicSymbols()
+    if err != nil && err != elf.ErrNoSymbols {
+        return nil, err
+    }
+
+    infos := map[string]SymbolInfo{}
+    for _, sym := range append(symbols, dynamic...) {
+        if sym.Section == elf.SHN_UNDEF || sym.Name == "" {
+            continue
+        }
+        if _, ok := infos[disambiguatedName(sym.Name, int(sym.Value))]; ok {
+            continue
+        }
+
+        info := SymbolInfo{}
+        switch elf.ST_BIND(sym.Info) {
+        case elf.STB_LOCAL:
+            info.Binding = BindingLocal
+        case elf.STB_WEAK:
+            info.Binding = BindingWeak
+        }
+        switch elf.ST_VISIBILITY(sym.Other) {
+        case elf.STV_HIDDEN:
+            info.Visibility = VisibilityHidden
+        case elf.STV_PROTECTED:
+            info.Visibility = VisibilityProtected
+        case elf.STV_INTERNAL:
+            info.Visibility = VisibilityInternal
+        }
+        switch elf.ST_TYPE(sym.Info) {
+        // 10 is STT_GNU_IFUNC, which debug/elf only has from go 1.23
+        case elf.STT_FUNC, elf.SymType(10):
+            info.Type = SymbolFunction
+        case elf.STT_OBJECT:
+            info.Type = SymbolObject
+        case elf.STT_TLS:
+            info.Type = SymbolTLS
+        case elf.STT_SECTION, elf.STT_FILE:
+            continue
+        }
+        info.Size = int(sym.Size)
+        infos[sym.Name] = info
+        // In case it is a duplicate DisambiguateSymbols renamed
+        infos[disambiguatedName(sym.Name, int(sym.Value))] = info
+    }
+
+    return infos, nil
+}
+
+func withSymbolInfo(datas []Data, infos map[string]SymbolInfo) []Data {
+    output := make([]Data, 0, len(datas))
+
+    for _, data := range datas {
+        if info, ok := infos[data.Name]; ok {
+            data.Info = info
+        }
+        output = append(output, data)
+    }
+
+    return output
+}
+
+// Gives the functions and data the info the symbol table had for them
+func (o Object) WithSymbolInfo(infos map[string]SymbolInfo) Object {
+    sections := make([]Section, 0, len(o.Sections))
+    for _, section := range o.Sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+        for _, fun := range section.Funcs {
+            if info, ok := infos[fun.Name]; ok {
+                fun.Info = info
+            }
+            funcs = append(funcs, fun)
+        }
+        sections = append(sections, Section{section.Name, funcs})
+    }
+
+    o.Sections = sections
+    o.Globals = withSymbolInfo(o.Globals, infos)
+    o.Variables = withSymbolInfo(o.Variables, infos)
+    o.ThreadLocals = withSymbolInfo(o.ThreadLocals, infos)
+    o.Literals = withSymbolInfo(o.Literals, infos)
+    o.Relocated = withSymbolInfo(o.Relocated, infos)
+    return o
+}
+
+func setInfo(datas []Data, names map[string]bool, change func(*SymbolInfo)) []Data {
+    output := make([]Data, 0, len(datas))
+
+    for _, data := range datas {
+        if names[data.Name] {
+            change(&data.Info)
+        }
+        output = append(output, data)
+    }
+
+    return output
+}
+
+// Changes how the functions and data in names are bound or seen
+func (o Object) SetSymbolInfo(names []string, change func(*SymbolInfo)) Object {
+    set := map[string]bool{}
+    for _, name := range names {
+        set[name] = true
+    }
+
+    sections := make([]Section, 0, len(o.Sections))
+    for _, section := range o.Sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+        for _, fun := range section.Funcs {
+            if set[fun.Name] {
+                change(&fun.Info)
+            }
+            funcs = append(funcs, fun)
+        }
+        sections = append(sections, Section{section.Name, funcs})
+    }
+
+    o.Sections = sections
+    o.Globals = setInfo(o.Globals, set, change)
+    o.Variables = setInfo(o.Variables, set, change)
+    o.ThreadLocals = setInfo(o.ThreadLocals, set, change)
+    o.Relocated = setInfo(o.Relocated, set, change)
+    return o
+}
+
+// What this object defines that the code and data it doesn't have mention.
+// main counts too, since the C runtime calls it.
+func (o Object) referencedElsewhere(base Object) (map[string]bool, map[string]bool) {
+    own := map[string]bool{}
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            own[fun.Name] = true
+        }
+    }
+    for _, datas := range [][]Data{o.Globals, o.Variables, o.ThreadLocals, o.Relocated} {
+        for _, data := range datas {
+            if !data.Extern {
+                own[data.Name] = true
+            }
+        }
+    }
+
+    referenced := map[string]bool{"main": true}
+    for _, section := range base.Sections {
+        for _, fun := range section.Funcs {
+            if own[fun.Name] {
+                continue
+            }
+            for _, line := range fun.Content {
+                words := strings.FieldsFunc(line, func(c rune) bool {
+                    return c > 0x7f || !isSymbolChar(byte(c))
+                })
+                for _, word := range words {
+                    word, _ = splitRelocation(word)
+                    if own[word] {
+                        referenced[word] = true
+                    }
+                }
+            }
+        }
+    }
+    for _, datas := range [][]Data{base.Variables, base.Relocated} {
+        for _, data := range datas {
+            if own[data.Name] {
+                continue
+            }
+            for _, pointer := range data.Pointers {
+                referenced[pointerTarget(pointer)] = true
+            }
+        }
+    }
+
+    return own, referenced
+}
+
+// Makes everything global that nothing else in the executable uses local, so only what other objects split from it
+// need is exported. main stays global, since the C runtime calls it.
+func (o Object) LocalizeUnreferenced(base Object) Object {
+    own, referenced := o.referencedElsewhere(base)
+
+    unreferenced := []string{}
+    for name := range own {
+        if !referenced[name] {
+            unreferenced = append(unreferenced, name)
+        }
+    }
+
+    return o.SetSymbolInfo(unreferenced, func(info *SymbolInfo) {
+        if info.Binding == BindingGlobal {
+            info.Binding = BindingLocal
+        }
+    })
+}
+
+// Static functions and variables have to be global once they are split from what uses them,
+// but they are hidden, so the executable they are linked into still doesn't export them.
+// Only the ones that are still local like in the executable are changed, not ones made local with SetSymbolInfo.
+func (o Object) ExportReferencedLocals(base Object) Object {
+    own, referenced := o.referencedElsewhere(base)
+
+    wasLocal := map[string]bool{}
+    for _, section := range base.Sections {
+        for _, fun := range section.Funcs {
+            wasLocal[fun.Name] = fun.Info.Binding == BindingLocal
+        }
+    }
+    for _, datas := range [][]Data{base.Globals, base.Variables, base.ThreadLocals, base.Relocated} {
+        for _, data := range datas {
+            wasLocal[data.Name] = data.Info.Binding == BindingLocal
+        }
+    }
+
+    exported := []string{}
+    for name := range own {
+        if referenced[name] && wasLocal[name] {
+            exported = append(exported, name)
+        }
+    }
+
+    return o.SetSymbolInfo(exported, func(info *SymbolInfo) {
+        if info.Binding == BindingLocal {
+            info.Binding = BindingGlobal
+            info.Visibility = VisibilityHidden
+        }
+    })
+}
+
+// The nasm directive that makes name visible outside the object, if it is.
+// size is what nasm should take as its size, which for functions is up to the .end label after them.
+func (o Object) nasmGlobal(name string, size string, info SymbolInfo) string {
+    if info.Binding == BindingLocal {
+        return ""
+    }
+    // Only ELF symbols have a type, size and visibility in nasm
+    if o.Format != FormatELF {
+        return "global " + name + "\n"
+    }
+
+    special := []string{}
+    switch info.Type {
+    case SymbolFunction:
+        special = append(special, "function")
+    case SymbolObject:
+        special = append(special, "data")
+    }
+    if info.Binding == BindingWeak {
+        special = append(special, "weak")
+    }
+    switch info.Visibility {
+    case VisibilityHidden:
+        special = append(special, "hidden")
+    case VisibilityProtected:
+        special = append(special, "protected")
+    case VisibilityInternal:
+        special = append(special, "internal")
+    }
+    if (info.Type == SymbolFunction || info.Type == SymbolObject) && size != "" {
+        special = append(special, size)
+    }
+    if len(special) == 0 {
+        return "global " + name + "\n"
+    }
+
+    return "global " + name + ":" + strings.Join(special, " ") + "\n"
+}
+
+// The size of data for nasmGlobal and gasSize, which the symbol table knows better than objdump
+func dataSize(data Data) int {
+    if data.Info.Size > 0 && data.Info.Size <= len(data.Data) {
+        return data.Info.Size
+    }
+    return len(data.Data)
+}
+
+// .type and .size for the GNU assembler, which unlike nasm can give them to local symbols too.
+// size is an expression like in nasmGlobal
+func (o Object) gasTypeAndSize(name string, size string, info SymbolInfo) string {
+    if o.Format == FormatMachO {
+        return ""
+    }
+
+    directives := ""
+    switch info.Type {
+    case SymbolFunction:
+        directives += ".type " + name + ", %function\n"
+    case SymbolObject:
+        directives += ".type " + name + ", %object\n"
+    case SymbolTLS:
+        directives += ".type " + name + ", %tls_object\n"
+    }
+    if info.Type != SymbolNoType && size != "" {
+        directives += ".size " + name + ", " + size + "\n"
+    }
+
+    return directives
+}
+
+// Same as nasmGlobal, for the GNU assembler
+func (o Object) gasGlobal(name string, info SymbolInfo) string {
+    if info.Binding == BindingLocal {
+        return ""
+    }
+
+    if o.Format == FormatMachO {
+        directives := ".globl " + name + "\n"
+        if info.Binding == BindingWeak {
+            directives += ".weak_definition " + name + "\n"
+        }
+        if info.Visibility == VisibilityHidden {
+            directives += ".private_extern " + name + "\n"
+        }
+        return directives
+    }
+
+    directives := ".global " + name + "\n"
+    if info.Binding == BindingWeak {
+        directives = ".weak " + name + "\n"
+    }
+    switch info.Visibility {
+    case VisibilityHidden:
+        directives += ".hidden " + name + "\n"
+    case VisibilityProtected:
+        directives += ".protected " + name + "\n"
+    case VisibilityInternal:
+        directives += ".internal " + name + "\n"
+    }
+    return directives
+}
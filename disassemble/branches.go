@@ -0,0 +1,317 @@
+package disassemble
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Branches into the middle of a function go to a label in it, named after how far into the function it is:
+// .at_1e inside of the function, and add.at_1e (nasm's name for the same local label) from anywhere else.
+// Assembling the code again can make instructions longer or shorter, so add+0x1e might not be there anymore.
+// The GNU assembler has no labels local to a function, so there it's .Ladd_at_1e everywhere, which doesn't end up in
+// the symbol table either.
+const branchLabel = ".at_"
+const gasBranchLabel = "_at_"
+
+func branchLabelName(offset int) string {
+    return fmt.Sprintf("%s%x", branchLabel, offset)
+}
+
+// "add.at_1e" -> "add", ".at_1e"
+func splitBranchLabel(word string) (string, string) {
+    i := strings.LastIndex(word, branchLabel)
+    if i <= 0 {
+        return word, ""
+    }
+    if _, ok := parseHexAddress(word[i+len(branchLabel):]); !ok {
+        return word, ""
+    }
+
+    return word[:i], word[i:]
+}
+
+// The mnemonic of a dumped instruction, without prefixes like bnd or notrack
+func dumpedMnemonic(code string) (string, []string) {
+    fields := strings.Fields(code)
+    for len(fields) > 0 && instructionPrefixes[fields[0]] {
+        fields = fields[1:]
+    }
+    if len(fields) == 0 {
+        return "", nil
+    }
+
+    return fields[0], fields[1:]
+}
+
+// ".Ladd_at_1e" -> "add"
+func splitGasBranchLabel(word string) (string, bool) {
+    // Mach-O's local labels start with L instead
+    name := strings.TrimPrefix(strings.TrimPrefix(word, "."), "L")
+    if len(name) == len(word) {
+        return "", false
+    }
+    i := strings.LastIndex(name, gasBranchLabel)
+    if i <= 0 {
+        return "", false
+    }
+    if _, ok := parseHexAddress(name[i+len(gasBranchLabel):]); !ok {
+        return "", false
+    }
+
+    return name[:i], true
+}
+
+func gasBranchLabelName(fun string, offset int) string {
+    return fmt.Sprintf(".L%s%s%x", fun, gasBranchLabel, offset)
+}
+
+// The branches with a target objdump names, besides x86's jumps, calls and loops
+func isArchBranch(arch Arch, mnemonic string) bool {
+    switch arch {
+    case ArchAArch64:
+        // b, bl, b.ne and friends, cbz, cbnz, tbz and tbnz
+        return mnemonic == "b" || mnemonic == "bl" || strings.HasPrefix(mnemonic, "b.") || strings.HasPrefix(mnemonic, "cb") || strings.HasPrefix(mnemonic, "tb")
+    case ArchRISCV64:
+        // beq, bnez and the other conditional ones, j and jal
+        return strings.HasPrefix(mnemonic, "b") || mnemonic == "j" || mnemonic == "jal"
+    }
+
+    return isBranch(mnemonic)
+}
+
+// "jle 1139 <add+0x1e>" (or "beq a0,a5,1139 <add+0x1e>") -> 0x1139, "add", 0x1e.
+// Only branches to somewhere after a symbol, rather than to it, count.
+func branchIntoSymbol(arch Arch, code string) (int, string, int, bool) {
+    // Addresses in comments, like jmp [rip+0x2fe2] # 4018 <...>, are what the instruction reads, not where it goes.
+    // AArch64 says which condition it is, like b.ne 4005c0 <foo+0x10> // b.any
+    code, _, _ = strings.Cut(code, "# ")
+    code, _, _ = strings.Cut(code, " //")
+    code = strings.TrimSpace(code)
+
+    mnemonic, _ := dumpedMnemonic(code)
+    start := strings.LastIndex(code, " <")
+    if !isArchBranch(arch, mnemonic) || start == -1 || !strings.HasSuffix(code, ">") {
+        return 0, "", 0, false
+    }
+    operands := strings.FieldsFunc(code[:start], func(c rune) bool {
+        return c == ' ' || c == ',' || c == rune(9)
+    })
+    target, ok := parseHexAddress(operands[len(operands)-1])
+    if !ok {
+        return 0, "", 0, false
+    }
+    symbol, offset, found := strings.Cut(code[start+2:len(code)-1], "+")
+    if !found {
+        return 0, "", 0, false
+    }
+    off, ok := parseHexAddress(offset)
+
+    return target, symbol, off, ok
+}
+
+// Every address some branch goes to, which isn't the start of a function, in each section of the dump.
+// In object files, branches with a relocation after them go wherever the linker puts them instead.
+func collectBranchTargets(arch Arch, lines []string) map[string]map[int]bool {
+    counts := map[string]map[int]int{}
+    section := ""
+    last := -1
+    for _, line := range lines {
+        if name, ok := strings.CutPrefix(line, "Disassembly of section "); ok {
+            section = strings.TrimSuffix(name, ":")
+            counts[section] = map[int]int{}
+            continue
+        }
+        if strings.Contains(line, ": R_") {
+            if last != -1 {
+                counts[section][last]--
+                last = -1
+            }
+            continue
+        }
+        _, code, ok := splitDumpLine(line)
+        if !ok {
+            continue
+        }
+        last = -1
+        if target, _, _, ok := branchIntoSymbol(arch, code); ok {
+            counts[section][target]++
+            last = target
+        }
+    }
+
+    targets := map[string]map[int]bool{}
+    for section, addresses := range counts {
+        targets[section] = map[int]bool{}
+        for address, count := range addresses {
+            if count > 0 {
+                targets[section][address] = true
+            }
+        }
+    }
+    return targets
+}
+
+// The label that goes in front of the instruction at address in the function at start, if something branches there
+func branchTargetLabel(targets map[int]bool, start int, address int) (string, bool) {
+    if !targets[address] || address == start {
+        return "", false
+    }
+
+    return branchLabelName(address - start) + ":", true
+}
+
+// Where each function is, to find the one a branch goes into
+type functionStarts map[string][]AssemblyFunction
+
+func newFunctionStarts(sections []Section) functionStarts {
+    starts := functionStarts{}
+    for _, section := range sections {
+        for _, fun := range section.Funcs {
+            // The functions of a section are in the order they were in, so the one a branch goes into is the last one before it
+            starts[fun.Name] = section.Funcs
+        }
+    }
+
+    return starts
+}
+
+// The function the address in it is in, going by the symbol objdump named it after
+func (s functionStarts) containing(symbol string, address int) (AssemblyFunction, bool) {
+    funcs, ok := s[symbol]
+    if !ok {
+        return AssemblyFunction{}, false
+    }
+    i := sort.Search(len(funcs), func(i int) bool {
+        return funcs[i].Address > address
+    }) - 1
+    if i < 0 {
+        return AssemblyFunction{}, false
+    }
+
+    return funcs[i], true
+}
+
+// Rewrites a branch objdump wrote like "jmp 1139 <foo+0x12>": to its own label when it stays inside of fun, and to
+// foo.at_12 when it goes into the middle of another function. Branches to the start of a function (like tail calls)
+// aren't changed, they already go to the symbol. Only for x86, where nasm is used.
+func rewriteBranch(line string, fun AssemblyFunction, starts functionStarts) (string, bool) {
+    target, symbol, _, ok := branchIntoSymbol(ArchX86_64, line)
+    if !ok {
+        return line, false
+    }
+    into, ok := starts.containing(symbol, target)
+    if !ok {
+        return line, false
+    }
+
+    code := line[:strings.LastIndex(line, " <")]
+    code = code[:strings.LastIndexAny(code, " ,")]
+    if into.Address == target {
+        return code + " " + into.Name, true
+    }
+    label := branchLabelName(target - into.Address)
+    if into.Name != fun.Name {
+        label = into.Name + label
+    }
+
+    return code + " " + label, true
+}
+
+// Rewrites the target of a branch objdump wrote like "1139 <foo+0x12>" to .Lfoo_at_12, or to foo when it's the start
+// of the function, for the architectures the GNU assembler is used for
+func gasBranchTarget(operand string, starts functionStarts) (string, bool) {
+    start := strings.LastIndex(operand, " <")
+    if start == -1 || !strings.HasSuffix(operand, ">") {
+        return operand, false
+    }
+    target, ok := parseHexAddress(strings.TrimSpace(operand[:start]))
+    if !ok {
+        return operand, false
+    }
+    symbol, _, _ := strings.Cut(operand[start+2:len(operand)-1], "+")
+    into, ok := starts.containing(symbol, target)
+    if !ok {
+        return operand, false
+    }
+
+    if into.Address == target {
+        return into.Name, true
+    }
+    return gasBranchLabelName(into.Name, target - into.Address), true
+}
+
+// The .at_1e: that went in front of a branch target when the code was read, as the GNU assembler's label for it
+func gasLabelDefinition(line string, fun AssemblyFunction) (string, bool) {
+    label, ok := strings.CutSuffix(line, ":")
+    if !ok || !strings.HasPrefix(label, branchLabel) {
+        return line, false
+    }
+    offset, ok := parseHexAddress(label[len(branchLabel):])
+    if !ok {
+        return line, false
+    }
+
+    return gasBranchLabelName(fun.Name, offset) + ":", true
+}
+
+// Branches into the middle of a function need that function in the same object file, since its labels are local
+func (o Object) checkBranchLabels() error {
+    if !o.Arch.UsesNasm() {
+        return o.checkGasBranchLabels()
+    }
+
+    defined := map[string]bool{}
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            defined[fun.Name] = true
+        }
+    }
+
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            for _, line := range fun.Content {
+                _, operands := splitOperands(line)
+                for _, operand := range operands {
+                    symbol, label := splitBranchLabel(operand)
+                    if label != "" && !defined[symbol] {
+                        return fmt.Errorf("%s jumps into the middle of %s, so they have to go in the same object file", fun.Name, symbol)
+                    }
+                }
+            }
+        }
+    }
+
+    return nil
+}
+
+// The GNU assembler's labels keep the name the function had when the code was read, so they are checked against the
+// labels the object defines instead
+func (o Object) checkGasBranchLabels() error {
+    defined := map[string]bool{}
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            for _, line := range fun.Content {
+                // RISC-V's auipc labels are on the same line, like .Lpcrel_main_0: auipc a0, %pcrel_hi(x)
+                if label, _, ok := strings.Cut(line, ":"); ok && !strings.ContainsAny(label, " ,[(%") {
+                    defined[label] = true
+                }
+            }
+        }
+    }
+
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            for _, line := range fun.Content {
+                _, operands := splitOperands(line)
+                for _, operand := range operands {
+                    if symbol, ok := splitGasBranchLabel(operand); ok && !defined[operand] {
+                        return fmt.Errorf("%s jumps into the middle of %s, so they have to go in the same object file", fun.Name, symbol)
+                    }
+                }
+            }
+        }
+    }
+
+    return nil
+}
@@ -0,0 +1,341 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type objectSymbol struct {
+    Name string
+    Value int
+}
+
+// Names section+offset, like .rodata-0x4 in a relocation, after the symbol it's in, since the executable
+// has that name for it. Offsets nothing is at keep the section's name.
+func objectSymbolNamer(f *elf.File) func(string, int) string {
+    bySection := map[string][]objectSymbol{}
+    symbols, _ := f.Symbols()
+    for _, sym := range symbols {
+        if sym.Name == "" || int(sym.Section) >= len(f.Sections) || elf.ST_TYPE(sym.Info) == elf.STT_SECTION || elf.ST_TYPE(sym.Info) == elf.STT_FILE {
+            continue
+        }
+        section := f.Sections[sym.Section].Name
+        bySection[section] = append(bySection[section], objectSymbol{sym.Name, int(sym.Value)})
+    }
+    for _, syms := range bySection {
+        sort.Slice(syms, func(i, j int) bool {
+            return syms[i].Value < syms[j].Value
+        })
+    }
+
+    return func(name string, offset int) string {
+        if syms, ok := bySection[name]; ok {
+            i := sort.Search(len(syms), func(i int) bool {
+                return syms[i].Value > offset
+            }) - 1
+            if i >= 0 {
+                name, offset = syms[i].Name, offset - syms[i].Value
+            }
+        }
+
+        switch {
+        case offset > 0:
+            return fmt.Sprintf("%s+0x%x", name, offset)
+        case offset < 0:
+            return fmt.Sprintf("%s-0x%x", name, -offset)
+        }
+        return name
+    }
+}
+
+// "printf-0x4" -> "printf", -4
+func parseRelocationTarget(target string) (string, int) {
+    i := strings.LastIndexAny(target, "+-")
+    if i <= 0 {
+        return target, 0
+    }
+    addend, err := strconv.ParseUint(strings.TrimPrefix(target[i+1:], "0x"), 16, 64)
+    if err != nil {
+        return target, 0
+    }
+    if target[i] == '-' {
+        return target[:i], -int(addend)
+    }
+    return target[:i], int(addend)
+}
+
+type dumpedInstruction struct {
+    Address int
+    Code string
+}
+
+type dumpedRelocation struct {
+    Offset int
+    Kind string
+    Target string
+}
+
+// Puts what the relocation points at into the instruction, the way MonkeyPatchAssembly writes it
+func applyRelocation(code string, kind string, target string) string {
+    wrt := ""
+    switch {
+    case strings.Contains(kind, "GOTPCREL"):
+        wrt = " wrt ..gotpcrel"
+    case strings.Contains(kind, "GOTTPOFF"):
+        wrt = " wrt ..gottpoff"
+    }
+
+    if start := strings.Index(code, "[rip"); start != -1 {
+        end := strings.Index(code[start:], "]") + start
+        code = fmt.Sprintf("%s[rel %s%s]%s", code[:start], target, wrt, code[end+1:])
+        if comment := strings.Index(code, "# "); comment != -1 {
+            code = code[:comment]
+        }
+        return strings.TrimSpace(code)
+    }
+
+    mnemonic, operands := splitOperands(code)
+    if strings.Contains(code, " <") && len(operands) == 1 {
+        // A call or jump, which goes to a label when it's into the middle of the function
+        if symbol, offset := parseRelocationTarget(target); offset > 0 {
+            return mnemonic + " " + symbol + branchLabelName(offset)
+        }
+        return mnemonic + " " + target
+    }
+    // An absolute address, which is whatever operand is still 0
+    for i := len(operands)-1; i >= 0; i-- {
+        if strings.HasSuffix(operands[i], "0x0") {
+            operands[i] = strings.TrimSuffix(operands[i], "0x0") + target
+            return mnemonic + " " + strings.Join(operands, ",")
+        }
+    }
+    return code
+}
+
+func relocateFunction(fun AssemblyFunction, instructions []dumpedInstruction, relocations []dumpedRelocation, name func(string, int) string) AssemblyFunction {
+    for _, rel := range relocations {
+        i := sort.Search(len(instructions), func(i int) bool {
+            return instructions[i].Address > rel.Offset
+        }) - 1
+        if i < 0 {
+            continue
+        }
+
+        next := rel.Offset + 4
+        if i+1 < len(instructions) {
+            next = instructions[i+1].Address
+        }
+        symbol, addend := parseRelocationTarget(rel.Target)
+        if strings.Contains(rel.Kind, "PC") || strings.Contains(rel.Kind, "PLT") || strings.Contains(rel.Kind, "GOTTPOFF") {
+            // Relative to where the instruction ends, rather than to the relocation
+            addend += next - rel.Offset
+        }
+        fun.Content[i] = applyRelocation(fun.Content[i], rel.Kind, name(symbol, addend))
+    }
+
+    return fun
+}
+
+// Disassembles an x86 object file like GetDumpedAssembly does an executable, with the relocations put into
+// the instructions, so its functions can be compared to the ones from an executable.
+func GetDumpedObject(file string) ([]Section, error) {
+    // The relocations of other architectures and formats don't go into the instructions yet, and comparing without them
+    // would only show differences that aren't there
+    f, err := elf.Open(file)
+    if err != nil {
+        return nil, errors.New("Only x86 ELF object files can be compared for now, so " + file + " has to be linked first")
+    }
+    defer f.Close()
+    if f.Type != elf.ET_REL || (f.Machine != elf.EM_X86_64 && f.Machine != elf.EM_386) {
+        return nil, errors.New("Only x86 ELF object files can be compared for now, so " + file + " has to be linked first")
+    }
+    name := objectSymbolNamer(f)
+
+    buf, err := exec.Command("objdump", "-dr", "-M", "intel noprefix", "--no-show-raw-insn", file).CombinedOutput()
+    if err != nil {
+        return nil, errors.New(string(buf))
+    }
+
+    sections := []Section{}
+    instructions := []dumpedInstruction{}
+    relocations := []dumpedRelocation{}
+    // The relocations go in once the whole function is known, since they need where each instruction ends
+    finish := func() {
+        if len(sections) == 0 || len(sections[len(sections)-1].Funcs) == 0 {
+            return
+        }
+        funcs := sections[len(sections)-1].Funcs
+        funcs[len(funcs)-1] = relocateFunction(funcs[len(funcs)-1], instructions, relocations, name)
+        instructions, relocations = instructions[:0], relocations[:0]
+    }
+
+    lines := strings.Split(string(buf), "\n")
+    for i := range lines {
+        lines[i] = strings.TrimSpace(lines[i])
+    }
+    // i386 branches the same way
+    targets := collectBranchTargets(ArchX86_64, lines)
+
+    for _, line := range lines {
+        if line == "" || strings.Contains(line, "file format") {
+            continue
+        }
+
+        if section, ok := strings.CutPrefix(line, "Disassembly of section "); ok {
+            finish()
+            sections = append(sections, Section{strings.TrimSuffix(section, ":"), []AssemblyFunction{}})
+            continue
+        }
+        if funcName, ok := strings.CutSuffix(line, ">:"); ok && !strings.ContainsRune(line, '\t') {
+            finish()
+            address, _ := strconv.ParseUint(strings.TrimSpace(funcName[:strings.Index(funcName, "<")]), 16, 64)
+            funcName = funcName[strings.Index(funcName, "<")+1:]
+            last := len(sections)-1
+            sections[last].Funcs = append(sections[last].Funcs, AssemblyFunction{funcName, int(address), []string{}, SymbolInfo{}})
+            continue
+        }
+
+        address, rest, ok := strings.Cut(line, ":")
+        addr, err := strconv.ParseUint(address, 16, 64)
+        if !ok || err != nil || len(sections) == 0 || len(sections[len(sections)-1].Funcs) == 0 {
+            continue
+        }
+        rest = strings.TrimSpace(rest)
+        if strings.HasPrefix(rest, "R_") {
+            fields := strings.Fields(rest)
+            if len(fields) == 2 {
+                relocations = append(relocations, dumpedRelocation{int(addr), fields[0], fields[1]})
+            }
+            continue
+        }
+
+        code := strings.ReplaceAll(rest, " PTR", "")
+        code = strings.ReplaceAll(code, "\t", " ")
+        funcs := sections[len(sections)-1].Funcs
+        if label, ok := branchTargetLabel(targets[sections[len(sections)-1].Name], funcs[len(funcs)-1].Address, int(addr)); ok {
+            // In step with the code, so each relocation finds its instruction
+            instructions = append(instructions, dumpedInstruction{int(addr), label})
+            funcs[len(funcs)-1].Content = append(funcs[len(funcs)-1].Content, label)
+        }
+        instructions = append(instructions, dumpedInstruction{int(addr), code})
+        funcs[len(funcs)-1].Content = append(funcs[len(funcs)-1].Content, code)
+    }
+    finish()
+
+    // What's left are the addresses inside the object, like jumps, which get named like in executables
+    return MonkeyPatchAssembly(sections, nil, nil, nil), nil
+}
+
+// Whether the file is a relocatable object, rather than an executable or library
+func IsObjectFile(file string) bool {
+    if f, err := elf.Open(file); err == nil {
+        defer f.Close()
+        return f.Type == elf.ET_REL
+    }
+    if f, err := macho.Open(file); err == nil {
+        defer f.Close()
+        return f.Type == macho.TypeObj
+    }
+    // COFF objects are PE files without the optional header
+    if f, err := pe.Open(file); err == nil {
+        defer f.Close()
+        return f.OptionalHeader == nil
+    }
+
+    return false
+}
+
+// One line of a side by side diff
+type DiffLine struct {
+    // ' ' for the same, '|' for changed, '<' for only in the first and '>' for only in the second
+    Kind byte
+    Left string
+    Right string
+}
+
+// A diff of two lists of lines, by their longest common subsequence
+func DiffLines(a []string, b []string) []DiffLine {
+    // common[i][j] is how long the LCS of a[i:] and b[j:] is
+    common := make([][]int, len(a)+1)
+    for i := range common {
+        common[i] = make([]int, len(b)+1)
+    }
+    for i := len(a)-1; i >= 0; i-- {
+        for j := len(b)-1; j >= 0; j-- {
+            if a[i] == b[j] {
+                common[i][j] = common[i+1][j+1] + 1
+            } else {
+                common[i][j] = max(common[i+1][j], common[i][j+1])
+            }
+        }
+    }
+
+    diff := []DiffLine{}
+    removed, added := []string{}, []string{}
+    // Lines that were removed and added in the same place were changed
+    flush := func() {
+        for k := 0; k < len(removed) || k < len(added); k++ {
+            switch {
+            case k < len(removed) && k < len(added):
+                diff = append(diff, DiffLine{'|', removed[k], added[k]})
+            case k < len(removed):
+                diff = append(diff, DiffLine{'<', removed[k], ""})
+            default:
+                diff = append(diff, DiffLine{'>', "", added[k]})
+            }
+        }
+        removed, added = removed[:0], added[:0]
+    }
+
+    i, j := 0, 0
+    for i < len(a) || j < len(b) {
+        switch {
+        case i < len(a) && j < len(b) && a[i] == b[j]:
+            flush()
+            diff = append(diff, DiffLine{' ', a[i], b[j]})
+            i++
+            j++
+        case j == len(b) || (i < len(a) && common[i+1][j] >= common[i][j+1]):
+            removed = append(removed, a[i])
+            i++
+        default:
+            added = append(added, b[j])
+            j++
+        }
+    }
+    flush()
+
+    return diff
+}
+
+// The instructions of two functions side by side, with the addresses named and the padding left out
+func DiffFunctions(fun AssemblyFunction, other AssemblyFunction) []DiffLine {
+    return DiffLines(normalizedCode(fun), normalizedCode(other))
+}
+
+// The instructions of a function and the same one from another executable or object file side by side,
+// with duplicates told apart by their address. renames gives the duplicates of other the names they have for fun.
+func DiffPairedFunctions(fun AssemblyFunction, other AssemblyFunction, renames map[string]string) []DiffLine {
+    return DiffLines(pairedCode(fun, nil), pairedCode(other, renames))
+}
+
+// The function of other that is name in original, and the names other has for the duplicates of original
+func FindPairedFunction(original Object, other Object, name string) (AssemblyFunction, map[string]string, bool) {
+    pairs := pairNames(original, other)
+    for _, section := range other.Sections {
+        for _, fun := range section.Funcs {
+            if pairs[fun.Name] == name {
+                return fun, renamedPairs(pairs), true
+            }
+        }
+    }
+
+    return AssemblyFunction{}, nil, false
+}
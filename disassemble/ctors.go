@@ -0,0 +1,166 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"fmt"
+)
+
+// RELATIVE dynamic relocations, which the dynamic linker fills in with the load address plus their addend
+func isRelativeDynamic(machine elf.Machine, kind uint32) bool {
+    switch machine {
+    case elf.EM_X86_64:
+        return elf.R_X86_64(kind) == elf.R_X86_64_RELATIVE
+    case elf.EM_386:
+        return elf.R_386(kind) == elf.R_386_RELATIVE
+    case elf.EM_AARCH64:
+        return elf.R_AARCH64(kind) == elf.R_AARCH64_RELATIVE
+    case elf.EM_RISCV:
+        return elf.R_RISCV(kind) == elf.R_RISCV_RELATIVE
+    }
+
+    return false
+}
+
+// Address -> addend of the RELATIVE relocations with one, which is what the dynamic linker puts there
+func relativeAddends(f *elf.File) map[int]int {
+    addends := map[int]int{}
+
+    for _, section := range f.Sections {
+        if section.Type != elf.SHT_RELA {
+            continue
+        }
+        data, err := section.Data()
+        if err != nil {
+            continue
+        }
+
+        if f.Class == elf.ELFCLASS64 {
+            for i := 0; i+24 <= len(data); i += 24 {
+                if isRelativeDynamic(f.Machine, elf.R_TYPE64(f.ByteOrder.Uint64(data[i+8:]))) {
+                    addends[int(f.ByteOrder.Uint64(data[i:]))] = int(f.ByteOrder.Uint64(data[i+16:]))
+                }
+            }
+        } else {
+            for i := 0; i+12 <= len(data); i += 12 {
+                if isRelativeDynamic(f.Machine, elf.R_TYPE32(f.ByteOrder.Uint32(data[i+4:]))) {
+                    addends[int(f.ByteOrder.Uint32(data[i:]))] = int(f.ByteOrder.Uint32(data[i+8:]))
+                }
+            }
+        }
+    }
+
+    return addends
+}
+
+// The function pointers in .init_array, .fini_array or .preinit_array.
+// lld, and ld.bfd on some architectures, leave position independent ones zero and put them in the relocation's addend.
+func readPointerArray(f *elf.File, name string, addends map[int]int) []int {
+    section := f.Section(name)
+    if section == nil || section.Type == elf.SHT_NOBITS {
+        return []int{}
+    }
+    data, err := section.Data()
+    if err != nil {
+        return []int{}
+    }
+
+    size := 8
+    if f.Class == elf.ELFCLASS32 {
+        size = 4
+    }
+
+    pointers := []int{}
+    for i := 0; i+size <= len(data); i += size {
+        var pointer uint64
+        if size == 8 {
+            pointer = f.ByteOrder.Uint64(data[i:])
+        } else {
+            pointer = uint64(f.ByteOrder.Uint32(data[i:]))
+        }
+        if addend, ok := addends[int(section.Addr)+i]; ok {
+            pointers = append(pointers, addend)
+            continue
+        }
+        // Old linkers use 0 and -1 as terminators
+        if pointer == 0 || pointer == 1<<(size*8)-1 {
+            continue
+        }
+        pointers = append(pointers, int(pointer))
+    }
+
+    return pointers
+}
+
+// crtbegin.o registers these itself when the object is linked again
+var crtConstructors = map[string]bool{"frame_dummy": true, "__do_global_dtors_aux": true}
+
+// Constructors and destructors, by name, in the order they run.
+// renames is applied on top, for functions that signatures named.
+func GetConstructors(file string, renames map[string]string) ([]string, []string, error) {
+    f, err := elf.Open(file)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer f.Close()
+
+    symbols, err := f.Symbols()
+    if err != nil && err != elf.ErrNoSymbols {
+        return nil, nil, err
+    }
+
+    // objdump labels functions after the global symbol if there is one
+    names := map[int]string{}
+    for _, sym := range symbols {
+        if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Section == elf.SHN_UNDEF {
+            continue
+        }
+        if _, ok := names[int(sym.Value)]; ok && elf.ST_BIND(sym.Info) == elf.STB_LOCAL {
+            continue
+        }
+        names[int(sym.Value)] = sym.Name
+    }
+
+    name := func(addr int) string {
+        name, ok := names[addr]
+        if !ok {
+            // Same as the stripped code got split into
+            name = fmt.Sprintf("sub_%x", addr)
+        }
+        if renamed, ok := renames[name]; ok {
+            return renamed
+        }
+        return name
+    }
+
+    addends := relativeAddends(f)
+    init := []string{}
+    for _, addr := range append(readPointerArray(f, ".preinit_array", addends), readPointerArray(f, ".init_array", addends)...) {
+        if !crtConstructors[name(addr)] {
+            init = append(init, name(addr))
+        }
+    }
+    fini := []string{}
+    for _, addr := range readPointerArray(f, ".fini_array", addends) {
+        if !crtConstructors[name(addr)] {
+            fini = append(fini, name(addr))
+        }
+    }
+
+    return init, fini, nil
+}
+
+// Only the constructors and destructors of functions this object has, since the rest belong to some other object
+func (o Object) ownConstructors(names []string) []string {
+    own := []string{}
+    for _, name := range names {
+        for _, section := range o.Sections {
+            for _, fun := range section.Funcs {
+                if fun.Name == name {
+                    own = append(own, name)
+                }
+            }
+        }
+    }
+
+    return own
+}
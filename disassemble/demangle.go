@@ -0,0 +1,988 @@
+package disassemble
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A C++ type, kept as a tree since pointers to functions and arrays are printed inside out
+type cxxType struct {
+    kind int
+    // The whole type for names, the qualifier for qualified types, the size for arrays and the class for member pointers
+    name string
+    inner *cxxType
+    ret *cxxType
+    params []*cxxType
+    // cv and ref qualifiers of function types
+    suffix string
+}
+
+const (
+    cxxNamed = iota
+    cxxPointer
+    cxxReference
+    cxxRvalueReference
+    cxxQualified
+    cxxFunction
+    cxxArray
+    cxxMember
+    // A pack expansion, T&&... with T being Args
+    cxxPack
+    // Template arguments that are a pack themselves, in params
+    cxxArgPack
+)
+
+func namedType(name string) *cxxType {
+    return &cxxType{kind: cxxNamed, name: name}
+}
+
+// Prints the type around a declarator, like C does: int (*)(int) or char const*
+func (t *cxxType) declare(declarator string) string {
+    switch t.kind {
+    case cxxReference, cxxRvalueReference:
+        // References to references collapse, which happens with template parameters
+        if t.inner.kind == cxxReference || t.inner.kind == cxxRvalueReference {
+            if t.kind == cxxReference {
+                return (&cxxType{kind: cxxReference, inner: t.inner.inner}).declare(declarator)
+            }
+            return t.inner.declare(declarator)
+        }
+        fallthrough
+    case cxxPointer:
+        symbol := map[int]string{cxxPointer: "*", cxxReference: "&", cxxRvalueReference: "&&"}[t.kind]
+        if t.inner.kind == cxxFunction || t.inner.kind == cxxArray {
+            return t.inner.declare("(" + symbol + declarator + ")")
+        }
+        return t.inner.declare(symbol + declarator)
+    case cxxQualified:
+        return t.inner.declare(t.name + declarator)
+    case cxxFunction:
+        return t.ret.declare("") + " " + declarator + "(" + cxxParams(t.params) + ")" + t.suffix
+    case cxxArray:
+        if declarator == "" {
+            return t.inner.declare("") + " [" + t.name + "]"
+        }
+        return t.inner.declare("") + " " + declarator + " [" + t.name + "]"
+    case cxxMember:
+        if t.inner.kind == cxxFunction {
+            return t.inner.declare("(" + t.name + "::*" + declarator + ")")
+        }
+        return t.inner.declare(" " + t.name + "::*" + declarator)
+    case cxxPack:
+        pack := t.inner.findPack()
+        if pack == nil {
+            return t.inner.declare(declarator) + "..."
+        }
+        expanded := make([]string, 0, len(pack.params))
+        for _, element := range pack.params {
+            expanded = append(expanded, t.inner.replace(pack, element).declare(declarator))
+        }
+        return strings.Join(expanded, ", ")
+    case cxxArgPack:
+        return cxxParams(t.params)
+    }
+
+    return t.name + declarator
+}
+
+func (t *cxxType) findPack() *cxxType {
+    if t == nil {
+        return nil
+    }
+    if t.kind == cxxArgPack {
+        return t
+    }
+    if pack := t.inner.findPack(); pack != nil {
+        return pack
+    }
+    if pack := t.ret.findPack(); pack != nil {
+        return pack
+    }
+    for _, param := range t.params {
+        if pack := param.findPack(); pack != nil {
+            return pack
+        }
+    }
+    return nil
+}
+
+// A copy with old swapped for replacement
+func (t *cxxType) replace(old *cxxType, replacement *cxxType) *cxxType {
+    if t == nil {
+        return nil
+    }
+    if t == old {
+        return replacement
+    }
+
+    copied := *t
+    copied.inner = t.inner.replace(old, replacement)
+    copied.ret = t.ret.replace(old, replacement)
+    copied.params = make([]*cxxType, 0, len(t.params))
+    for _, param := range t.params {
+        copied.params = append(copied.params, param.replace(old, replacement))
+    }
+    return &copied
+}
+
+func (t *cxxType) String() string {
+    return t.declare("")
+}
+
+func cxxParams(params []*cxxType) string {
+    if len(params) == 1 && params[0].kind == cxxNamed && params[0].name == "void" {
+        return ""
+    }
+
+    printed := make([]string, 0, len(params))
+    for _, param := range params {
+        printed = append(printed, param.String())
+    }
+    return strings.Join(printed, ", ")
+}
+
+func cxxTemplateArgs(args []*cxxType) string {
+    printed := make([]string, 0, len(args))
+    for _, arg := range args {
+        printed = append(printed, arg.String())
+    }
+
+    list := strings.Join(printed, ", ")
+    // c++filt style, from before >> was a token
+    if strings.HasSuffix(list, ">") {
+        list += " "
+    }
+    return "<" + list + ">"
+}
+
+var cxxBuiltins = map[byte]string{
+    'v': "void", 'w': "wchar_t", 'b': "bool", 'c': "char", 'a': "signed char", 'h': "unsigned char",
+    's': "short", 't': "unsigned short", 'i': "int", 'j': "unsigned int", 'l': "long", 'm': "unsigned long",
+    'x': "long long", 'y': "unsigned long long", 'n': "__int128", 'o': "unsigned __int128",
+    'f': "float", 'd': "double", 'e': "long double", 'g': "__float128", 'z': "...",
+}
+
+var cxxDBuiltins = map[byte]string{
+    'd': "decimal64", 'e': "decimal128", 'f': "decimal32", 'h': "half", 'i': "char32_t", 's': "char16_t",
+    'u': "char8_t", 'a': "auto", 'c': "decltype(auto)", 'n': "decltype(nullptr)",
+}
+
+// The standard abbreviations, and the name their constructors have
+var cxxAbbreviations = map[byte][2]string{
+    'a': {"std::allocator", "allocator"},
+    'b': {"std::basic_string", "basic_string"},
+    's': {"std::basic_string<char, std::char_traits<char>, std::allocator<char> >", "basic_string"},
+    'i': {"std::basic_istream<char, std::char_traits<char> >", "basic_istream"},
+    'o': {"std::basic_ostream<char, std::char_traits<char> >", "basic_ostream"},
+    'd': {"std::basic_iostream<char, std::char_traits<char> >", "basic_iostream"},
+}
+
+var cxxOperators = map[string]string{
+    "nw": "new", "na": "new[]", "dl": "delete", "da": "delete[]",
+    "ps": "+", "ng": "-", "ad": "&", "de": "*", "co": "~",
+    "pl": "+", "mi": "-", "ml": "*", "dv": "/", "rm": "%", "an": "&", "or": "|", "eo": "^",
+    "aS": "=", "pL": "+=", "mI": "-=", "mL": "*=", "dV": "/=", "rM": "%=", "aN": "&=", "oR": "|=", "eO": "^=",
+    "ls": "<<", "rs": ">>", "lS": "<<=", "rS": ">>=", "eq": "==", "ne": "!=", "lt": "<", "gt": ">",
+    "le": "<=", "ge": ">=", "ss": "<=>", "nt": "!", "aa": "&&", "oo": "||", "pp": "++", "mm": "--",
+    "cm": ",", "pm": "->*", "pt": "->", "cl": "()", "ix": "[]", "qu": "?", "aw": "co_await",
+}
+
+var errBadMangling = errors.New("Bad mangled name")
+
+type demangler struct {
+    s string
+    pos int
+    subs []*cxxType
+    // Template arguments T_ refers to, from the function's own name
+    params []*cxxType
+    // Whether template arguments are the function's rather than some type's
+    top bool
+    // The last name, for constructors and destructors
+    lastSource string
+}
+
+// What an encoding turned out to be
+type cxxEncoding struct {
+    // The name without return type or parameters, like net::Socket::send
+    qualified string
+    // With the parameters but not the return type, which names in a function's scope leave out
+    signature string
+    full string
+    // The class or namespace it is in, like net::Socket
+    scope string
+}
+
+type cxxEntity struct {
+    name string
+    template bool
+    // Constructors, destructors and conversion operators have no return type even as templates
+    noReturn bool
+    // cv and ref qualifiers of member functions
+    qualifiers string
+    scope string
+}
+
+func (d *demangler) peek() byte {
+    if d.pos >= len(d.s) {
+        return 0
+    }
+    return d.s[d.pos]
+}
+
+func (d *demangler) peekAt(offset int) byte {
+    if d.pos+offset >= len(d.s) {
+        return 0
+    }
+    return d.s[d.pos+offset]
+}
+
+func (d *demangler) consume(prefix string) bool {
+    if strings.HasPrefix(d.s[d.pos:], prefix) {
+        d.pos += len(prefix)
+        return true
+    }
+    return false
+}
+
+func (d *demangler) expect(prefix string) {
+    if !d.consume(prefix) {
+        panic(errBadMangling)
+    }
+}
+
+func (d *demangler) number() int {
+    negative := d.consume("n")
+    start := d.pos
+    for d.peek() >= '0' && d.peek() <= '9' {
+        d.pos++
+    }
+    if start == d.pos {
+        panic(errBadMangling)
+    }
+
+    n, err := strconv.Atoi(d.s[start:d.pos])
+    if err != nil {
+        panic(errBadMangling)
+    }
+    if negative {
+        return -n
+    }
+    return n
+}
+
+// The number in S<seq>_ and friends, in base 36. Nothing at all is -1, so S_ is the first one
+func (d *demangler) seqID() int {
+    start := d.pos
+    for c := d.peek(); (c >= '0' && c <= '9') || (c >= 'A' && c <= 'Z'); c = d.peek() {
+        d.pos++
+    }
+    if start == d.pos {
+        return -1
+    }
+
+    n, err := strconv.ParseInt(d.s[start:d.pos], 36, 64)
+    if err != nil {
+        panic(errBadMangling)
+    }
+    return int(n)
+}
+
+func (d *demangler) sourceName() string {
+    length := d.number()
+    if length <= 0 || d.pos+length > len(d.s) {
+        panic(errBadMangling)
+    }
+
+    name := d.s[d.pos:d.pos+length]
+    d.pos += length
+    if strings.HasPrefix(name, "_GLOBAL__N") {
+        return "(anonymous namespace)"
+    }
+
+    d.lastSource = name
+    return name
+}
+
+func (d *demangler) abiTags(name string) string {
+    for d.consume("B") {
+        saved := d.lastSource
+        name += "[abi:" + d.sourceName() + "]"
+        d.lastSource = saved
+    }
+    return name
+}
+
+// _Z <encoding>, plus the .cold and .constprop clones GCC makes
+func (d *demangler) mangledName() cxxEncoding {
+    d.expect("_Z")
+    encoding := d.encoding()
+
+    for d.pos < len(d.s) {
+        if d.peek() != '.' {
+            panic(errBadMangling)
+        }
+        start := d.pos
+        d.pos++
+        for c := d.peek(); c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'); c = d.peek() {
+            d.pos++
+        }
+        for d.peek() == '.' && d.peekAt(1) >= '0' && d.peekAt(1) <= '9' {
+            d.pos++
+            for d.peek() >= '0' && d.peek() <= '9' {
+                d.pos++
+            }
+        }
+        if d.pos == start+1 {
+            panic(errBadMangling)
+        }
+        encoding.full += " [clone " + d.s[start:d.pos] + "]"
+    }
+
+    return encoding
+}
+
+func (d *demangler) encoding() cxxEncoding {
+    if c := d.peek(); c == 'T' || c == 'G' {
+        special, scope := d.specialName()
+        return cxxEncoding{special, special, special, scope}
+    }
+
+    savedTop := d.top
+    d.top = true
+    name := d.name()
+    d.top = savedTop
+
+    if d.pos >= len(d.s) || d.peek() == 'E' || d.peek() == '.' {
+        // Variables
+        return cxxEncoding{name.name, name.name, name.name, name.scope}
+    }
+
+    ret := ""
+    if name.template && !name.noReturn {
+        ret = d.cxxType().String() + " "
+    }
+    params := []*cxxType{}
+    for d.pos < len(d.s) && d.peek() != 'E' && d.peek() != '.' {
+        params = append(params, d.cxxType())
+    }
+    signature := name.name + "(" + cxxParams(params) + ")" + name.qualifiers
+
+    return cxxEncoding{name.name, signature, ret + signature, name.scope}
+}
+
+func (d *demangler) callOffset(kind byte) {
+    switch kind {
+    case 'h':
+        d.number()
+        d.expect("_")
+    case 'v':
+        d.number()
+        d.expect("_")
+        d.number()
+        d.expect("_")
+    default:
+        panic(errBadMangling)
+    }
+}
+
+// The name, and the scope of what a thunk calls, since thunks go with their class
+func (d *demangler) specialName() (string, string) {
+    switch {
+    case d.consume("TV"):
+        return "vtable for " + d.cxxType().String(), ""
+    case d.consume("TT"):
+        return "VTT for " + d.cxxType().String(), ""
+    case d.consume("TI"):
+        return "typeinfo for " + d.cxxType().String(), ""
+    case d.consume("TS"):
+        return "typeinfo name for " + d.cxxType().String(), ""
+    case d.consume("TC"):
+        derived := d.cxxType().String()
+        d.number()
+        d.expect("_")
+        return "construction vtable for " + d.cxxType().String() + "-in-" + derived, ""
+    case d.consume("Th"):
+        d.callOffset('h')
+        target := d.encoding()
+        return "non-virtual thunk to " + target.full, target.scope
+    case d.consume("Tv"):
+        d.callOffset('v')
+        target := d.encoding()
+        return "virtual thunk to " + target.full, target.scope
+    case d.consume("Tc"):
+        d.callOffset(d.s[d.pos])
+        d.pos++
+        d.callOffset(d.s[d.pos])
+        d.pos++
+        target := d.encoding()
+        return "covariant return thunk to " + target.full, target.scope
+    case d.consume("TW"):
+        return "TLS wrapper function for " + d.name().name, ""
+    case d.consume("TH"):
+        return "TLS init function for " + d.name().name, ""
+    case d.consume("GV"):
+        return "guard variable for " + d.name().name, ""
+    case d.consume("GR"):
+        name := d.name().name
+        seq := d.seqID() + 1
+        d.expect("_")
+        return "reference temporary #" + strconv.Itoa(seq) + " for " + name, ""
+    case d.consume("GTt"):
+        return "transaction clone for " + d.encoding().full, ""
+    }
+
+    panic(errBadMangling)
+}
+
+func (d *demangler) name() cxxEntity {
+    switch c := d.peek(); {
+    case c == 'N':
+        return d.nestedName()
+    case c == 'Z':
+        return d.localName()
+    case c == 'S' && d.peekAt(1) != 't':
+        // Only templates can be substitutions here
+        sub := d.substitution()
+        if d.peek() != 'I' {
+            panic(errBadMangling)
+        }
+        return cxxEntity{d.templateArgs(sub.String()), true, false, "", ""}
+    }
+
+    prefix := ""
+    if d.consume("St") {
+        prefix = "std::"
+    }
+    unqualified, noReturn := d.unqualifiedName()
+    name := cxxEntity{prefix + unqualified, false, noReturn, "", strings.TrimSuffix(prefix, "::")}
+    if d.peek() == 'I' {
+        d.subs = append(d.subs, namedType(name.name))
+        name.name = d.templateArgs(name.name)
+        name.template = true
+    }
+    return name
+}
+
+func (d *demangler) localName() cxxEntity {
+    d.expect("Z")
+    function := d.encoding().signature
+    d.expect("E")
+
+    var entity cxxEntity
+    if d.consume("s") {
+        entity = cxxEntity{"string literal", false, false, "", ""}
+    } else {
+        entity = d.name()
+    }
+
+    // Discriminators tell apart local entities with the same name, but aren't printed
+    if d.consume("_") {
+        if d.consume("_") {
+            d.number()
+            d.expect("_")
+        } else {
+            d.number()
+        }
+    }
+
+    entity.name = function + "::" + entity.name
+    if entity.scope == "" {
+        entity.scope = function
+    } else {
+        entity.scope = function + "::" + entity.scope
+    }
+    return entity
+}
+
+func (d *demangler) nestedName() cxxEntity {
+    d.expect("N")
+    name := cxxEntity{}
+    for {
+        switch d.peek() {
+        case 'r':
+            name.qualifiers += " restrict"
+        case 'V':
+            name.qualifiers += " volatile"
+        case 'K':
+            name.qualifiers += " const"
+        default:
+            goto refs
+        }
+        d.pos++
+    }
+    refs:
+    if d.consume("R") {
+        name.qualifiers += " &"
+    } else if d.consume("O") {
+        name.qualifiers += " &&"
+    }
+
+    prefix := ""
+    for !d.consume("E") {
+        if d.pos >= len(d.s) {
+            panic(errBadMangling)
+        }
+        if d.peek() != 'I' {
+            name.template = false
+            name.noReturn = false
+        }
+
+        switch c := d.peek(); {
+        case c == 'S' && d.peekAt(1) == 't':
+            d.pos += 2
+            prefix = "std"
+            continue
+        case c == 'S':
+            if prefix != "" {
+                panic(errBadMangling)
+            }
+            prefix = d.substitution().String()
+            continue
+        case c == 'I':
+            if prefix == "" {
+                panic(errBadMangling)
+            }
+            prefix = d.templateArgs(prefix)
+            name.template = true
+        case c == 'T':
+            if prefix != "" {
+                panic(errBadMangling)
+            }
+            prefix = d.templateParam().String()
+        default:
+            unqualified, noReturn := d.unqualifiedName()
+            name.noReturn = noReturn
+            name.scope = prefix
+            if prefix != "" {
+                prefix += "::"
+            }
+            prefix += unqualified
+        }
+
+        // Every prefix is a substitution candidate, but the name itself isn't
+        if d.peek() != 'E' {
+            d.subs = append(d.subs, namedType(prefix))
+        }
+    }
+
+    name.name = prefix
+    return name
+}
+
+func (d *demangler) unqualifiedName() (string, bool) {
+    // GCC marks functions with internal linkage like this
+    d.consume("L")
+
+    c := d.peek()
+    switch {
+    case c >= '0' && c <= '9':
+        return d.abiTags(d.sourceName()), false
+    case c == 'C' && (d.peekAt(1) == 'I' || (d.peekAt(1) >= '1' && d.peekAt(1) <= '5')):
+        d.pos++
+        if d.consume("I") {
+            // Inheriting constructors name the base class too
+            d.pos++
+            saved := d.lastSource
+            d.cxxType()
+            d.lastSource = saved
+        } else {
+            d.pos++
+        }
+        return d.abiTags(d.lastSource), true
+    case c == 'D' && d.peekAt(1) >= '0' && d.peekAt(1) <= '5':
+        d.pos += 2
+        return d.abiTags("~" + d.lastSource), true
+    case c == 'D' && d.peekAt(1) == 'C':
+        d.pos += 2
+        names := []string{}
+        for !d.consume("E") {
+            names = append(names, d.sourceName())
+        }
+        return "[" + strings.Join(names, ", ") + "]", false
+    case c == 'U' && d.peekAt(1) == 't':
+        d.pos += 2
+        n := d.seqID() + 2
+        d.expect("_")
+        return "{unnamed type#" + strconv.Itoa(n) + "}", false
+    case c == 'U' && d.peekAt(1) == 'l':
+        d.pos += 2
+        params := []*cxxType{}
+        for !d.consume("E") {
+            params = append(params, d.cxxType())
+        }
+        n := d.seqID() + 2
+        d.expect("_")
+        return "{lambda(" + cxxParams(params) + ")#" + strconv.Itoa(n) + "}", false
+    case c >= 'a' && c <= 'z':
+        return d.operatorName()
+    }
+
+    panic(errBadMangling)
+}
+
+func (d *demangler) operatorName() (string, bool) {
+    if d.consume("cv") {
+        savedTop := d.top
+        d.top = false
+        conversion := d.cxxType().String()
+        d.top = savedTop
+        return "operator " + conversion, true
+    }
+    if d.consume("li") {
+        return "operator\"\" " + d.sourceName(), false
+    }
+    if d.peek() == 'v' && d.peekAt(1) >= '0' && d.peekAt(1) <= '9' {
+        d.pos += 2
+        return "operator " + d.sourceName(), false
+    }
+
+    if d.pos+2 > len(d.s) {
+        panic(errBadMangling)
+    }
+    operator, ok := cxxOperators[d.s[d.pos:d.pos+2]]
+    if !ok {
+        panic(errBadMangling)
+    }
+    d.pos += 2
+
+    name := "operator" + operator
+    if operator[0] >= 'a' && operator[0] <= 'z' {
+        name = "operator " + operator
+    }
+    return d.abiTags(name), false
+}
+
+func (d *demangler) substitution() *cxxType {
+    d.expect("S")
+
+    if abbreviation, ok := cxxAbbreviations[d.peek()]; ok {
+        d.pos++
+        d.lastSource = abbreviation[1]
+        return namedType(abbreviation[0])
+    }
+
+    i := d.seqID() + 1
+    d.expect("_")
+    if i >= len(d.subs) {
+        panic(errBadMangling)
+    }
+    return d.subs[i]
+}
+
+func (d *demangler) templateParam() *cxxType {
+    d.expect("T")
+    i := d.seqID() + 1
+    d.expect("_")
+    if i >= len(d.params) {
+        panic(errBadMangling)
+    }
+    return d.params[i]
+}
+
+// Template arguments to put after name
+func (d *demangler) templateArgs(name string) string {
+    d.expect("I")
+
+    top := d.top
+    d.top = false
+    saved := d.lastSource
+    args := []*cxxType{}
+    for !d.consume("E") {
+        if d.pos >= len(d.s) {
+            panic(errBadMangling)
+        }
+        args = append(args, d.templateArg())
+    }
+    d.lastSource = saved
+    d.top = top
+
+    if top {
+        d.params = args
+    }
+    if strings.HasSuffix(name, "<") {
+        // operator< <int>
+        return name + " " + cxxTemplateArgs(args)
+    }
+    return name + cxxTemplateArgs(args)
+}
+
+func (d *demangler) templateArg() *cxxType {
+    switch d.peek() {
+    case 'L':
+        return d.literal()
+    case 'J', 'I':
+        // Older GCCs used I for these
+        d.pos++
+        pack := &cxxType{kind: cxxArgPack}
+        for !d.consume("E") {
+            if d.pos >= len(d.s) {
+                panic(errBadMangling)
+            }
+            pack.params = append(pack.params, d.templateArg())
+        }
+        return pack
+    case 'X':
+        d.pos++
+        expression := d.expression()
+        d.expect("E")
+        return expression
+    }
+
+    return d.cxxType()
+}
+
+// Only the simplest expressions are understood: template parameters, literals and names, like in enable_if
+func (d *demangler) expression() *cxxType {
+    switch c := d.peek(); {
+    case c == 'T':
+        return d.templateParam()
+    case c == 'L':
+        return d.literal()
+    case c >= '0' && c <= '9':
+        return namedType(d.simpleID())
+    case c == 's' && d.peekAt(1) == 'r':
+        d.pos += 2
+        scope := d.cxxType().String()
+        return namedType(scope + "::" + d.simpleID())
+    }
+
+    panic(errBadMangling)
+}
+
+func (d *demangler) simpleID() string {
+    saved := d.lastSource
+    name := d.sourceName()
+    d.lastSource = saved
+    if d.peek() == 'I' {
+        name = d.templateArgs(name)
+    }
+    return name
+}
+
+func (d *demangler) literal() *cxxType {
+    d.expect("L")
+
+    if d.consume("_Z") {
+        name := d.encoding().full
+        d.expect("E")
+        return namedType(name)
+    }
+
+    kind := d.cxxType()
+    negative := d.consume("n")
+    start := d.pos
+    for d.peek() != 'E' {
+        if d.pos >= len(d.s) {
+            panic(errBadMangling)
+        }
+        d.pos++
+    }
+    value := d.s[start:d.pos]
+    d.expect("E")
+    if negative {
+        value = "-" + value
+    }
+
+    switch kind.String() {
+    case "bool":
+        if value == "0" {
+            return namedType("false")
+        }
+        return namedType("true")
+    case "int":
+        return namedType(value)
+    case "unsigned int":
+        return namedType(value + "u")
+    case "long":
+        return namedType(value + "l")
+    case "unsigned long":
+        return namedType(value + "ul")
+    case "long long":
+        return namedType(value + "ll")
+    case "unsigned long long":
+        return namedType(value + "ull")
+    case "decltype(nullptr)":
+        return namedType("nullptr")
+    }
+    return namedType("(" + kind.String() + ")" + value)
+}
+
+func (d *demangler) cxxType() *cxxType {
+    top := d.top
+    d.top = false
+    defer func() {
+        d.top = top
+    }()
+
+    c := d.peek()
+    if builtin, ok := cxxBuiltins[c]; ok {
+        d.pos++
+        return namedType(builtin)
+    }
+
+    var t *cxxType
+    switch c {
+    case 'u':
+        d.pos++
+        return namedType(d.sourceName())
+    case 'r', 'V', 'K':
+        qualifiers := ""
+        for {
+            if d.consume("r") {
+                qualifiers = " restrict" + qualifiers
+            } else if d.consume("V") {
+                qualifiers = " volatile" + qualifiers
+            } else if d.consume("K") {
+                qualifiers = " const" + qualifiers
+            } else {
+                break
+            }
+        }
+        inner := d.cxxType()
+        if inner.kind == cxxFunction {
+            // const on a function type is a member function's, and part of the same substitution
+            qualified := *inner
+            qualified.suffix = qualifiers + qualified.suffix
+            d.subs[len(d.subs)-1] = &qualified
+            return &qualified
+        } else if inner.kind == cxxArray {
+            // Arrays can't be const, their elements are
+            element := &cxxType{kind: cxxQualified, name: qualifiers, inner: inner.inner}
+            t = &cxxType{kind: cxxArray, name: inner.name, inner: element}
+        } else {
+            t = &cxxType{kind: cxxQualified, name: qualifiers, inner: inner}
+        }
+    case 'P':
+        d.pos++
+        t = &cxxType{kind: cxxPointer, inner: d.cxxType()}
+    case 'R':
+        d.pos++
+        t = &cxxType{kind: cxxReference, inner: d.cxxType()}
+    case 'O':
+        d.pos++
+        t = &cxxType{kind: cxxRvalueReference, inner: d.cxxType()}
+    case 'C':
+        d.pos++
+        t = namedType(d.cxxType().String() + " _Complex")
+    case 'G':
+        d.pos++
+        t = namedType(d.cxxType().String() + " _Imaginary")
+    case 'F':
+        d.pos++
+        d.consume("Y")
+        t = &cxxType{kind: cxxFunction, ret: d.cxxType()}
+        for d.peek() != 'E' && !((d.peek() == 'R' || d.peek() == 'O') && d.peekAt(1) == 'E') {
+            if d.pos >= len(d.s) {
+                panic(errBadMangling)
+            }
+            t.params = append(t.params, d.cxxType())
+        }
+        if d.consume("R") {
+            t.suffix = " &"
+        } else if d.consume("O") {
+            t.suffix = " &&"
+        }
+        d.expect("E")
+    case 'A':
+        d.pos++
+        size := ""
+        if d.peek() == 'T' {
+            size = d.templateParam().String()
+        } else if d.peek() != '_' {
+            size = strconv.Itoa(d.number())
+        }
+        d.expect("_")
+        t = &cxxType{kind: cxxArray, name: size, inner: d.cxxType()}
+    case 'M':
+        d.pos++
+        class := d.cxxType().String()
+        t = &cxxType{kind: cxxMember, name: class, inner: d.cxxType()}
+    case 'T':
+        switch d.peekAt(1) {
+        case 's', 'u', 'e':
+            // Elaborated struct, union or enum
+            d.pos += 2
+            t = namedType(d.name().name)
+        default:
+            t = d.templateParam()
+            if d.peek() == 'I' {
+                d.subs = append(d.subs, t)
+                t = namedType(d.templateArgs(t.String()))
+            }
+        }
+    case 'S':
+        if d.peekAt(1) == 't' {
+            t = namedType(d.name().name)
+            break
+        }
+        sub := d.substitution()
+        if d.peek() != 'I' {
+            // Substitutions aren't candidates themselves
+            return sub
+        }
+        t = namedType(d.templateArgs(sub.String()))
+    case 'D':
+        d.pos++
+        switch c := d.peek(); {
+        case c == 'p':
+            d.pos++
+            t = &cxxType{kind: cxxPack, inner: d.cxxType()}
+        case c == 'F':
+            d.pos++
+            bits := d.number()
+            d.expect("_")
+            return namedType("_Float" + strconv.Itoa(bits))
+        case c == 'v':
+            d.pos++
+            size := d.number()
+            d.expect("_")
+            t = namedType(d.cxxType().String() + " __vector(" + strconv.Itoa(size) + ")")
+        default:
+            builtin, ok := cxxDBuiltins[c]
+            if !ok {
+                // decltype and friends need expressions
+                panic(errBadMangling)
+            }
+            d.pos++
+            return namedType(builtin)
+        }
+    case 'N', 'Z', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+        t = namedType(d.name().name)
+    default:
+        panic(errBadMangling)
+    }
+
+    d.subs = append(d.subs, t)
+    return t
+}
+
+func demangle(name string) (encoding cxxEncoding, ok bool) {
+    if !strings.HasPrefix(name, "_Z") {
+        return cxxEncoding{name, name, name, ""}, false
+    }
+
+    defer func() {
+        if recover() != nil {
+            encoding = cxxEncoding{name, name, name, ""}
+            ok = false
+        }
+    }()
+
+    d := &demangler{s: name}
+    return d.mangledName(), true
+}
+
+// Turns an Itanium C++ ABI name (what GCC and Clang use everywhere but Windows) back into C++,
+// like _ZN3net6Socket4sendEPKci into net::Socket::send(char const*, int).
+// Anything else, or anything too unusual, is returned as is.
+func Demangle(name string) string {
+    encoding, _ := demangle(name)
+    return encoding.full
+}
+
+// Just the qualified name, without the return type, parameters or clone suffixes, like net::Socket::send
+func DemangleName(name string) string {
+    encoding, _ := demangle(name)
+    return encoding.qualified
+}
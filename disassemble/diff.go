@@ -0,0 +1,343 @@
+package disassemble
+
+import (
+	"bytes"
+	"hash/fnv"
+	"regexp"
+	"sort"
+)
+
+// A function that's in both versions, but isn't the same anymore
+type FunctionChange struct {
+    Old string
+    // Only different from Old when the function was found by its code rather than by its name
+    New string
+    // How many instructions were changed, added or removed. 0 if it was only renamed
+    Changed int
+    AddedCalls []string
+    RemovedCalls []string
+}
+
+func (c FunctionChange) Renamed() bool {
+    return normalizedName(c.Old) != normalizedName(c.New)
+}
+
+// A piece of data that's in both versions, but isn't the same anymore
+type DataChange struct {
+    Old string
+    New string
+    // Whether its bytes or what its pointers point at changed, rather than only its name
+    Changed bool
+}
+
+func (c DataChange) Renamed() bool {
+    return normalizedName(c.Old) != normalizedName(c.New)
+}
+
+// What changed between two versions of an executable
+type BinaryDiff struct {
+    AddedFunctions []string
+    RemovedFunctions []string
+    ChangedFunctions []FunctionChange
+    // Literals, globals and the other data
+    AddedData []string
+    RemovedData []string
+    ChangedData []DataChange
+}
+
+func (d BinaryDiff) Empty() bool {
+    return len(d.AddedFunctions) == 0 && len(d.RemovedFunctions) == 0 && len(d.ChangedFunctions) == 0 &&
+        len(d.AddedData) == 0 && len(d.RemovedData) == 0 && len(d.ChangedData) == 0
+}
+
+func (o Object) allFunctions() []AssemblyFunction {
+    funcs := []AssemblyFunction{}
+    for _, section := range o.Sections {
+        funcs = append(funcs, section.Funcs...)
+    }
+
+    return funcs
+}
+
+func (o Object) allData() []Data {
+    datas := []Data{}
+    for _, data := range [][]Data{o.Globals, o.Variables, o.ThreadLocals, o.Literals, o.Relocated} {
+        datas = append(datas, data...)
+    }
+
+    return datas
+}
+
+// Every function and piece of data, renamed to the same thing, for structuralHash
+func (o Object) definedNames() map[string]string {
+    names := map[string]string{}
+    for _, fun := range o.allFunctions() {
+        names[fun.Name] = "symbol"
+    }
+    for _, data := range o.allData() {
+        names[data.Name] = "symbol"
+    }
+
+    return names
+}
+
+var symbolOffset = regexp.MustCompile(`\bsymbol[+-]0x[0-9a-f]+\b`)
+// Like [rel __cxa_finalize@plt+0x2f78], which is named after whatever comes before
+var relativeOffset = regexp.MustCompile(`(\[rel [^\]\s+-]+)[+-]0x[0-9a-f]+`)
+
+// A hash of the code of a function without the functions and data it mentions, or where in them.
+// Functions that only moved, or got renamed along with what they use, hash the same.
+func structuralHash(fun AssemblyFunction, defined map[string]string) uint64 {
+    hash := fnv.New64a()
+    for _, line := range normalizedCode(fun) {
+        line = symbolOffset.ReplaceAllString(renameInLine(line, defined), "symbol")
+        line = relativeOffset.ReplaceAllString(line, "$1")
+        hash.Write([]byte(line + "\n"))
+    }
+
+    return hash.Sum64()
+}
+
+func dataHash(data Data) uint64 {
+    hash := fnv.New64a()
+    hash.Write(data.Data)
+    return hash.Sum64()
+}
+
+// Pairs up the things that are left by their hashes, where a hash belongs to only one of them on each side
+func matchUnique(old []int, oldHash func(int) uint64, new []int, newHash func(int) uint64) map[int]int {
+    group := func(things []int, hash func(int) uint64) map[uint64][]int {
+        groups := map[uint64][]int{}
+        for _, thing := range things {
+            h := hash(thing)
+            groups[h] = append(groups[h], thing)
+        }
+        return groups
+    }
+
+    olds, news := group(old, oldHash), group(new, newHash)
+    matched := map[int]int{}
+    for h, o := range olds {
+        if n := news[h]; len(o) == 1 && len(n) == 1 {
+            matched[o[0]] = n[0]
+        }
+    }
+    return matched
+}
+
+// Matches things by name, and then what's left by hash. Returns the pairs, and what's left on each side
+func matchByNameAndHash(oldNames []string, oldHash func(int) uint64, newNames []string, newHash func(int) uint64) (map[int]int, []int, []int) {
+    byName := map[string]int{}
+    for j, name := range newNames {
+        if _, ok := byName[normalizedName(name)]; !ok {
+            byName[normalizedName(name)] = j
+        }
+    }
+
+    pairs := map[int]int{}
+    taken := map[int]bool{}
+    leftOld := []int{}
+    for i, name := range oldNames {
+        if j, ok := byName[normalizedName(name)]; ok && !taken[j] {
+            pairs[i] = j
+            taken[j] = true
+        } else {
+            leftOld = append(leftOld, i)
+        }
+    }
+    leftNew := []int{}
+    for j := range newNames {
+        if !taken[j] {
+            leftNew = append(leftNew, j)
+        }
+    }
+
+    for i, j := range matchUnique(leftOld, oldHash, leftNew, newHash) {
+        pairs[i] = j
+        taken[j] = true
+    }
+    removed := []int{}
+    for _, i := range leftOld {
+        if _, ok := pairs[i]; !ok {
+            removed = append(removed, i)
+        }
+    }
+    added := []int{}
+    for _, j := range leftNew {
+        if !taken[j] {
+            added = append(added, j)
+        }
+    }
+
+    return pairs, removed, added
+}
+
+// The functions and imports the function calls, or jumps to as a tail call, by their name in the new version
+func callTargets(arch Arch, fun AssemblyFunction, callable map[string]bool, renames map[string]string) map[string]bool {
+    targets := map[string]bool{}
+    for _, line := range fun.Content {
+        mnemonic, operands := splitOperands(line)
+        if instructionPrefixes[mnemonic] && len(operands) > 0 {
+            mnemonic, operands = splitOperands(operands[0])
+        }
+        if !leavesFunction(arch, mnemonic) || len(operands) == 0 {
+            continue
+        }
+
+        // call printf wrt ..plt, or jmp foo+0x12
+        target := operands[len(operands)-1]
+        for i := 0; i < len(target); i++ {
+            if !isSymbolChar(target[i]) {
+                target = target[:i]
+                break
+            }
+        }
+        if !callable[target] || target == fun.Name {
+            continue
+        }
+        if renamed, ok := renames[target]; ok {
+            target = renamed
+        }
+        targets[normalizedName(target)] = true
+    }
+
+    return targets
+}
+
+// What's in a but not in b, sorted
+func missingFrom(a map[string]bool, b map[string]bool) []string {
+    missing := []string{}
+    for s := range a {
+        if !b[s] {
+            missing = append(missing, s)
+        }
+    }
+    sort.Strings(missing)
+
+    return missing
+}
+
+func callable(o Object, symbols []string) map[string]bool {
+    names := map[string]bool{}
+    for _, fun := range o.allFunctions() {
+        names[fun.Name] = true
+    }
+    for _, symbol := range symbols {
+        names[symbol] = true
+    }
+
+    return names
+}
+
+// Compares two versions of an executable, with symbols being what each of them imports.
+// Functions and data are matched by name, and what's left by its code (or its bytes, for data), so the ones
+// stripped executables name after their address are still found when they only moved.
+func DiffObjects(old Object, oldSymbols []string, new Object, newSymbols []string) BinaryDiff {
+    diff := BinaryDiff{[]string{}, []string{}, []FunctionChange{}, []string{}, []string{}, []DataChange{}}
+    // What the old version calls the things in the new one, for comparing the code
+    renames := map[string]string{}
+
+    oldData, newData := old.allData(), new.allData()
+    names := func(datas []Data) []string {
+        output := make([]string, 0, len(datas))
+        for _, data := range datas {
+            output = append(output, data.Name)
+        }
+        return output
+    }
+    // Zeroed data, like globals, says nothing about which one it is, so it gets a hash nothing else has
+    hashData := func(datas []Data, side uint64) func(int) uint64 {
+        return func(i int) uint64 {
+            if len(bytes.Trim(datas[i].Data, "\x00")) == 0 {
+                return uint64(i) << 1 | side
+            }
+            return dataHash(datas[i])
+        }
+    }
+    dataPairs, removedData, addedData := matchByNameAndHash(names(oldData), hashData(oldData, 0), names(newData), hashData(newData, 1))
+    for i, j := range dataPairs {
+        if normalizedName(oldData[i].Name) != normalizedName(newData[j].Name) {
+            renames[oldData[i].Name] = newData[j].Name
+        }
+    }
+
+    oldFuncs, newFuncs := old.allFunctions(), new.allFunctions()
+    funcNames := func(funcs []AssemblyFunction) []string {
+        output := make([]string, 0, len(funcs))
+        for _, fun := range funcs {
+            output = append(output, fun.Name)
+        }
+        return output
+    }
+    oldDefined, newDefined := old.definedNames(), new.definedNames()
+    funcPairs, removedFuncs, addedFuncs := matchByNameAndHash(funcNames(oldFuncs), func(i int) uint64 {
+        return structuralHash(oldFuncs[i], oldDefined)
+    }, funcNames(newFuncs), func(j int) uint64 {
+        return structuralHash(newFuncs[j], newDefined)
+    })
+    for i, j := range funcPairs {
+        if normalizedName(oldFuncs[i].Name) != normalizedName(newFuncs[j].Name) {
+            renames[oldFuncs[i].Name] = newFuncs[j].Name
+        }
+    }
+
+    oldCallable, newCallable := callable(old, oldSymbols), callable(new, newSymbols)
+    for i, fun := range oldFuncs {
+        j, ok := funcPairs[i]
+        if !ok {
+            continue
+        }
+        other := newFuncs[j]
+
+        renamed := fun
+        renamed.Content = make([]string, 0, len(fun.Content))
+        for _, line := range fun.Content {
+            renamed.Content = append(renamed.Content, renameInLine(line, renames))
+        }
+        changed := 0
+        for _, line := range DiffFunctions(renamed, other) {
+            if line.Kind != ' ' {
+                changed++
+            }
+        }
+
+        oldCalls := callTargets(old.Arch, fun, oldCallable, renames)
+        newCalls := callTargets(new.Arch, other, newCallable, nil)
+        change := FunctionChange{fun.Name, other.Name, changed, missingFrom(newCalls, oldCalls), missingFrom(oldCalls, newCalls)}
+        if changed > 0 || change.Renamed() {
+            diff.ChangedFunctions = append(diff.ChangedFunctions, change)
+        }
+    }
+    for _, i := range removedFuncs {
+        diff.RemovedFunctions = append(diff.RemovedFunctions, oldFuncs[i].Name)
+    }
+    for _, j := range addedFuncs {
+        diff.AddedFunctions = append(diff.AddedFunctions, newFuncs[j].Name)
+    }
+
+    pointerSize := 8
+    if old.Arch == ArchI386 {
+        pointerSize = 4
+    }
+    renamedData := renameData(oldData, renames)
+    for i, data := range oldData {
+        j, ok := dataPairs[i]
+        if !ok {
+            continue
+        }
+        change := DataChange{data.Name, newData[j].Name, !dataMatches(renamedData[i], newData[j], pointerSize, func(pointer string, other string) bool {
+            return normalizedName(pointer) == normalizedName(other)
+        })}
+        if change.Changed || change.Renamed() {
+            diff.ChangedData = append(diff.ChangedData, change)
+        }
+    }
+    for _, i := range removedData {
+        diff.RemovedData = append(diff.RemovedData, oldData[i].Name)
+    }
+    for _, j := range addedData {
+        diff.AddedData = append(diff.AddedData, newData[j].Name)
+    }
+
+    return diff
+}
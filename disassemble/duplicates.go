@@ -0,0 +1,142 @@
+package disassemble
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Static functions and variables from different files can have the same name, like init or count.
+// Each of them gets name.address instead, which is also what selects one of them.
+func disambiguatedName(name string, addr int) string {
+    return fmt.Sprintf("%s.%x", name, addr)
+}
+
+// The name a symbol had before disambiguatedName, or the name itself if it wasn't a duplicate
+func originalName(name string, addr int) string {
+    if original, ok := strings.CutSuffix(name, fmt.Sprintf(".%x", addr)); ok {
+        return original
+    }
+    return name
+}
+
+// "helper@0x1139" or "helper@1139" -> "helper", 0x1139
+func parseAddressSelector(pattern string) (string, int, bool) {
+    name, address, ok := strings.Cut(pattern, "@")
+    if !ok || name == "" {
+        return "", 0, false
+    }
+
+    addr, err := strconv.ParseUint(strings.TrimPrefix(address, "0x"), 16, 64)
+    if err != nil {
+        return "", 0, false
+    }
+
+    return name, int(addr), true
+}
+
+// Whether name@address selects a symbol at addr
+func matchesAddressSelector(pattern string, name string, addr int) bool {
+    selected, at, ok := parseAddressSelector(pattern)
+    return ok && at == addr && (selected == name || selected == originalName(name, addr))
+}
+
+// Names more than one function, or more than one piece of data, has
+func duplicateNames(sections []Section, datas ...[]Data) map[string]bool {
+    seen := map[string]bool{}
+    duplicates := map[string]bool{}
+
+    for _, section := range sections {
+        for _, fun := range section.Funcs {
+            if seen[fun.Name] {
+                duplicates[fun.Name] = true
+            }
+            seen[fun.Name] = true
+        }
+    }
+    for _, data := range datas {
+        for _, d := range data {
+            if seen[d.Name] {
+                duplicates[d.Name] = true
+            }
+            seen[d.Name] = true
+        }
+    }
+
+    return duplicates
+}
+
+func disambiguateData(datas []Data, duplicates map[string]bool) []Data {
+    output := make([]Data, 0, len(datas))
+
+    for _, data := range datas {
+        if duplicates[data.Name] {
+            data.Name = disambiguatedName(data.Name, data.Location)
+        }
+        output = append(output, data)
+    }
+
+    return output
+}
+
+// Renames functions and data that share a name with something else to name.address, along with the <name> objdump
+// put next to every address that points into them. This must run before anything looks the names up.
+func DisambiguateSymbols(sections []Section, datas [][]Data) ([]Section, [][]Data) {
+    duplicates := duplicateNames(sections, datas...)
+    if len(duplicates) == 0 {
+        return sections, datas
+    }
+
+    output := make([][]Data, 0, len(datas))
+    for _, data := range datas {
+        output = append(output, disambiguateData(data, duplicates))
+    }
+
+    renamed := make([]Section, 0, len(sections))
+    functions := []AssemblyFunction{}
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+        for _, fun := range section.Funcs {
+            if duplicates[fun.Name] {
+                fun.Name = disambiguatedName(fun.Name, fun.Address)
+            }
+            funcs = append(funcs, fun)
+            functions = append(functions, fun)
+        }
+        renamed = append(renamed, Section{section.Name, funcs})
+    }
+    sort.Slice(functions, func(i, j int) bool {
+        return functions[i].Address < functions[j].Address
+    })
+
+    renamedData := map[string]bool{}
+    for _, data := range output {
+        for _, d := range data {
+            if duplicates[originalName(d.Name, d.Location)] {
+                renamedData[d.Name] = true
+            }
+        }
+    }
+
+    return NameAddresses(renamed, func(addr int) (string, bool) {
+        // Data first, since the last function has no end to check against
+        if name, ok := FindDataAt(addr, output...); ok {
+            data, _ := splitSymbolOffset(name)
+            return name, renamedData[data]
+        }
+
+        // The function the address is in, which ends where the next one starts
+        i := sort.Search(len(functions), func(i int) bool {
+            return functions[i].Address > addr
+        }) - 1
+        if i >= 0 && duplicates[originalName(functions[i].Name, functions[i].Address)] {
+            if addr == functions[i].Address {
+                return functions[i].Name, true
+            }
+            return fmt.Sprintf("%s+0x%x", functions[i].Name, addr - functions[i].Address), true
+        }
+
+        return "", false
+    }), output
+}
@@ -0,0 +1,99 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"os/exec"
+)
+
+type Format int
+
+const (
+    FormatELF Format = iota
+    FormatPE
+    FormatMachO
+)
+
+func GetFormat(file string) (Format, error) {
+    if f, err := elf.Open(file); err == nil {
+        f.Close()
+        return FormatELF, nil
+    }
+
+    if f, err := pe.Open(file); err == nil {
+        f.Close()
+        return FormatPE, nil
+    }
+
+    if f, err := macho.Open(file); err == nil {
+        f.Close()
+        return FormatMachO, nil
+    }
+
+    return FormatELF, errors.New("Unknown executable format for " + file)
+}
+
+func (f Format) String() string {
+    switch f {
+    case FormatPE:
+        return "PE"
+    case FormatMachO:
+        return "Mach-O"
+    }
+
+    return "ELF"
+}
+
+// Where string literals and other constants go
+func (f Format) ReadonlySection() string {
+    if f == FormatPE {
+        return ".rdata"
+    }
+
+    return ".rodata"
+}
+
+// The -f argument nasm needs to emit an object file in this format
+func (f Format) NasmFormat(a Arch) string {
+    switch f {
+    case FormatPE:
+        return "win64"
+    case FormatMachO:
+        return "macho64"
+    }
+
+    return a.NasmFormat()
+}
+
+// Mach-O sections are named after their segment too
+func (f Format) GasSection(name string) string {
+    if f != FormatMachO {
+        return name
+    }
+
+    switch name {
+    case ".text":
+        return "__TEXT,__text"
+    case ".rodata":
+        return "__TEXT,__const"
+    case ".data":
+        return "__DATA,__data"
+    }
+
+    return name
+}
+
+// The GNU assembler can't write Mach-O, but the LLVM one can
+func (f Format) AssembleCommand(a Arch, input string, output string) *exec.Cmd {
+    if f == FormatMachO {
+        triple := "x86_64-apple-macos"
+        if a == ArchAArch64 {
+            triple = "arm64-apple-macos"
+        }
+        return exec.Command("llvm-mc", "-triple", triple, "-filetype=obj", input, "-o", output)
+    }
+
+    return exec.Command(a.Assembler(), input, "-o", output)
+}
@@ -0,0 +1,153 @@
+package disassemble
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Same layout as the nasm output, but in GNU assembler syntax for the architectures nasm can't do
+func (o Object) outputGas(file *os.File, filepath string, imports []ImportedSymbol, symbols []string) error {
+    // Mach-O has no .extern or .type, undefined symbols are external anyways
+    macho := o.Format == FormatMachO
+
+    if !macho {
+        for _, symbol := range symbols {
+            fmt.Fprintf(file, ".extern %s\n", symbol)
+            if imp, ok := FindImport(imports, symbol); ok && imp.Version != "" {
+                fmt.Fprintf(file, ".symver %s, %s\n", symbol, imp)
+            }
+        }
+    }
+    if len(o.Globals) > 0 {
+        if !macho {
+            fmt.Fprintln(file, ".section .bss")
+        }
+        for _, global := range o.Globals {
+            if global.Extern {
+                if !macho {
+                    fmt.Fprintf(file, ".extern %s\n", global.Name)
+                }
+            } else if macho {
+                fmt.Fprintf(file, "%s.zerofill __DATA,__bss,%s,%d\n", o.gasGlobal(global.Name, global.Info), global.Name, len(global.Data))
+            } else {
+                fmt.Fprintf(file, "%s%s:\n", o.gasGlobal(global.Name, global.Info), global.Name)
+                fmt.Fprintf(file, "\t.zero %d\n", len(global.Data))
+                fmt.Fprint(file, o.gasTypeAndSize(global.Name, strconv.Itoa(dataSize(global)), global.Info))
+            }
+        }
+    }
+    if len(o.Variables) > 0 {
+        fmt.Fprintf(file, ".section %s\n", o.Format.GasSection(".data"))
+        for _, variable := range o.Variables {
+            if variable.Extern {
+                if !macho {
+                    fmt.Fprintf(file, ".extern %s\n", variable.Name)
+                }
+                continue
+            }
+            fmt.Fprintf(file, "%s%s:\n", o.gasGlobal(variable.Name, variable.Info), variable.Name)
+            writeGasData(file, variable)
+            fmt.Fprint(file, o.gasTypeAndSize(variable.Name, strconv.Itoa(dataSize(variable)), variable.Info))
+        }
+    }
+    // Mach-O thread-locals are nothing like ELF ones, and only ELF has any here
+    if len(o.ThreadLocals) > 0 && !macho {
+        for _, local := range o.ThreadLocals {
+            if local.Extern {
+                fmt.Fprintf(file, ".extern %s\n", local.Name)
+                continue
+            }
+            info := local.Info
+            info.Type = SymbolTLS
+            if isZero(local.Data) {
+                fmt.Fprintln(file, ".section .tbss,\"awT\",@nobits")
+                fmt.Fprintf(file, "%s%s:\n", o.gasGlobal(local.Name, info), local.Name)
+                fmt.Fprintf(file, "\t.zero %d\n", len(local.Data))
+            } else {
+                fmt.Fprintln(file, ".section .tdata,\"awT\",@progbits")
+                fmt.Fprintf(file, "%s%s:\n", o.gasGlobal(local.Name, info), local.Name)
+                writeGasData(file, local)
+            }
+            fmt.Fprint(file, o.gasTypeAndSize(local.Name, strconv.Itoa(dataSize(local)), info))
+        }
+    }
+    if len(o.Literals) > 0 {
+        fmt.Fprintf(file, ".section %s\n", o.Format.GasSection(".rodata"))
+        for _, literal := range o.Literals {
+            fmt.Fprintf(file, "%s:\n", literal.Name)
+            writeGasData(file, literal)
+        }
+    }
+    if len(o.Relocated) > 0 && !macho {
+        fmt.Fprintln(file, ".section .data.rel.ro,\"aw\"")
+        for _, r := range o.Relocated {
+            if r.Extern {
+                fmt.Fprintf(file, ".extern %s\n", r.Name)
+                continue
+            }
+            fmt.Fprintf(file, "%s%s:\n", o.gasGlobal(r.Name, r.Info), r.Name)
+            writeGasData(file, r)
+            fmt.Fprint(file, o.gasTypeAndSize(r.Name, strconv.Itoa(dataSize(r)), r.Info))
+        }
+    }
+    if init := o.ownConstructors(o.InitArray); len(init) > 0 && !macho {
+        fmt.Fprintln(file, ".section .init_array,\"aw\",@init_array")
+        for _, name := range init {
+            fmt.Fprintf(file, "\t.8byte %s\n", name)
+        }
+    }
+    if fini := o.ownConstructors(o.FiniArray); len(fini) > 0 && !macho {
+        fmt.Fprintln(file, ".section .fini_array,\"aw\",@fini_array")
+        for _, name := range fini {
+            fmt.Fprintf(file, "\t.8byte %s\n", name)
+        }
+    }
+    for _, section := range o.Sections {
+        fmt.Fprintf(file, ".section %s\n", o.Format.GasSection(section.Name))
+        for _, fun := range section.Funcs {
+            // Code is always a function, even when stripped
+            info := fun.Info
+            info.Type = SymbolFunction
+            fmt.Fprintf(file, "%s%s:\n", o.gasGlobal(fun.Name, info), fun.Name)
+            for _, line := range fun.Content {
+                fmt.Fprintf(file, "\t%s\n", line)
+            }
+            fmt.Fprint(file, o.gasTypeAndSize(fun.Name, ".-" + fun.Name, info))
+        }
+    }
+
+    assembling := o.Format.AssembleCommand(o.Arch, file.Name(), filepath)
+    out, err := assembling.CombinedOutput()
+
+    if err != nil {
+        return errors.New(string(out))
+    }
+
+    return nil
+}
+
+// Like writeNasmData. Only 64-bit architectures are written with gas
+func writeGasData(file *os.File, data Data) {
+    for i := 0; i < len(data.Data); {
+        if target, ok := data.Pointers[i]; ok {
+            fmt.Fprintf(file, "\t.8byte %s\n", target)
+            i += 8
+            continue
+        }
+
+        fmt.Fprintf(file, "\t.byte ")
+        for ; i < len(data.Data); i++ {
+            if _, ok := data.Pointers[i]; ok {
+                break
+            }
+            fmt.Fprintf(file, "%d", data.Data[i])
+            if _, ok := data.Pointers[i+1]; ok || i == len(data.Data)-1 {
+                fmt.Fprint(file, "\n")
+            } else {
+                fmt.Fprintf(file, ",")
+            }
+        }
+    }
+}
@@ -0,0 +1,107 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Everything needed to turn absolute and GOT-relative addressing back into symbols
+type GotInfo struct {
+    // Address of _GLOBAL_OFFSET_TABLE_
+    Got int
+    // GOT slots filled in by the dynamic linker, keyed by slot address
+    GotSymbols map[int]string
+    // GOT slots with the thread pointer offset of another module's thread-local, keyed by slot address
+    TLSSymbols map[int]string
+    PIE bool
+}
+
+func GetGotInfo(file string) (GotInfo, error) {
+    info := GotInfo{0, map[int]string{}, map[int]string{}, false}
+
+    f, err := elf.Open(file)
+    if err != nil {
+        return info, err
+    }
+    defer f.Close()
+
+    info.PIE = f.Type == elf.ET_DYN
+
+    if got := f.Section(".got.plt"); got != nil {
+        info.Got = int(got.Addr)
+    } else if got := f.Section(".got"); got != nil {
+        info.Got = int(got.Addr)
+    }
+
+    cmd := exec.Command(objdumpCommand(file), "-R", file)
+    buf, err := cmd.CombinedOutput()
+    if err != nil {
+        // Statically linked, no dynamic relocations
+        return info, nil
+    }
+
+    // RISC-V fills its GOT slots with plain 64-bit relocations, which pointers in the data have too
+    inGot := func(slot int) bool {
+        got := f.Section(".got")
+        return got != nil && slot >= int(got.Addr) && slot < int(got.Addr + got.Size)
+    }
+
+    for _, line := range strings.Split(string(buf), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) != 3 {
+            continue
+        }
+
+        slot, err := strconv.ParseUint(fields[0], 16, 64)
+        if err != nil {
+            continue
+        }
+
+        tls := strings.HasSuffix(fields[1], "_TPOFF64") || (fields[1] == "R_RISCV_TLS_TPREL64" && inGot(int(slot)))
+        if !tls && !strings.HasSuffix(fields[1], "_GLOB_DAT") && !(fields[1] == "R_RISCV_64" && inGot(int(slot))) {
+            continue
+        }
+
+        name, _, _ := strings.Cut(fields[2], "@")
+        if tls {
+            info.TLSSymbols[int(slot)] = name
+        } else {
+            info.GotSymbols[int(slot)] = name
+        }
+    }
+
+    return info, nil
+}
+
+func (info GotInfo) ExternSymbols() []string {
+    symbols := make([]string, 0, len(info.GotSymbols))
+
+    for _, symbol := range info.GotSymbols {
+        symbols = append(symbols, symbol)
+    }
+    // Sorted, so the same executable always makes the same object
+    sort.Strings(symbols)
+
+    return symbols
+}
+
+func FindDataAt(addr int, datas ...[]Data) (string, bool) {
+    for _, data := range datas {
+        for _, d := range data {
+            if addr < d.Location || addr >= d.Location+len(d.Data) {
+                continue
+            }
+
+            if addr == d.Location {
+                return d.Name, true
+            }
+            return fmt.Sprintf("%s+0x%x", d.Name, addr-d.Location), true
+        }
+    }
+
+    return "", false
+}
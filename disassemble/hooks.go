@@ -0,0 +1,236 @@
+package disassemble
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func (o Object) FindFunction(name string, section string) (AssemblyFunction, bool) {
+    for _, sec := range o.Sections {
+        if sec.Name != section {
+            continue
+        }
+        for _, fun := range sec.Funcs {
+            if fun.Name == name {
+                return fun, true
+            }
+        }
+    }
+
+    return AssemblyFunction{}, false
+}
+
+var x86Arguments = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+
+// Which integer argument a register holds when the function starts, like rdi, x0 or a0 for the first one.
+// 32-bit x86 passes them on the stack, so it has none.
+func argumentIndex(arch Arch, word string) (int, bool) {
+    switch arch {
+    case ArchX86_64:
+        reg, ok := x86Register64(word)
+        if !ok {
+            return 0, false
+        }
+        for i, argument := range x86Arguments {
+            if argument == reg {
+                return i, true
+            }
+        }
+    case ArchAArch64:
+        if len(word) == 2 && (word[0] == 'x' || word[0] == 'w') && word[1] >= '0' && word[1] <= '7' {
+            return int(word[1] - '0'), true
+        }
+    case ArchRISCV64:
+        if len(word) == 2 && word[0] == 'a' && word[1] >= '0' && word[1] <= '7' {
+            return int(word[1] - '0'), true
+        }
+    }
+
+    return 0, false
+}
+
+// Instructions that only write their first operand, so reading an argument register isn't what they do with it
+func writesOnlyDestination(arch Arch, mnemonic string, operands []string) bool {
+    switch arch {
+    case ArchX86_64:
+        switch mnemonic {
+        case "mov", "movzx", "movsx", "movsxd", "movabs", "lea", "pop":
+            return true
+        case "xor", "sub":
+            // Zeroing
+            return len(operands) == 2 && operands[0] == operands[1]
+        }
+        return false
+    case ArchAArch64:
+        if strings.HasPrefix(mnemonic, "st") {
+            return false
+        }
+        switch mnemonic {
+        case "cmp", "cmn", "tst", "cbz", "cbnz", "tbz", "tbnz", "movk":
+            return false
+        }
+        return true
+    case ArchRISCV64:
+        mnemonic = strings.TrimPrefix(mnemonic, "c.")
+        switch mnemonic {
+        case "sd", "sw", "sh", "sb", "fsd", "fsw":
+            return false
+        }
+        return !strings.HasPrefix(mnemonic, "b")
+    }
+
+    return false
+}
+
+// Whether the code after this instruction can't be reading the function's arguments anymore
+func leavesFunction(arch Arch, mnemonic string) bool {
+    switch arch {
+    case ArchX86_64:
+        return mnemonic == "call" || mnemonic == "jmp" || mnemonic == "ret"
+    case ArchAArch64:
+        return mnemonic == "bl" || mnemonic == "blr" || mnemonic == "b" || mnemonic == "br" || mnemonic == "ret"
+    case ArchRISCV64:
+        switch strings.TrimPrefix(mnemonic, "c.") {
+        case "call", "tail", "jal", "jalr", "j", "jr", "ret":
+            return true
+        }
+    }
+
+    return false
+}
+
+// How many integer arguments the function takes, going by the argument registers it reads before writing them,
+// up to the first call. Floats and anything on the stack aren't counted. -1 if the architecture has no argument registers.
+func GuessArguments(arch Arch, fun AssemblyFunction) int {
+    if arch == ArchI386 {
+        return -1
+    }
+
+    written := map[int]bool{}
+    count := 0
+    for _, line := range fun.Content {
+        mnemonic, operands := splitOperands(line)
+        if instructionPrefixes[mnemonic] {
+            _, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+            mnemonic, operands = splitOperands(rest)
+        }
+        if leavesFunction(arch, mnemonic) {
+            break
+        }
+
+        read := operands
+        destination := -1
+        if len(operands) > 0 {
+            if i, ok := argumentIndex(arch, operands[0]); ok {
+                destination = i
+                if writesOnlyDestination(arch, mnemonic, operands) {
+                    read = operands[1:]
+                }
+            }
+        }
+
+        for _, operand := range read {
+            words := strings.FieldsFunc(operand, func(c rune) bool {
+                return !isSymbolChar(byte(c))
+            })
+            for _, word := range words {
+                if i, ok := argumentIndex(arch, word); ok && !written[i] && i+1 > count {
+                    count = i+1
+                }
+            }
+        }
+        if destination != -1 {
+            written[destination] = true
+        }
+    }
+
+    return count
+}
+
+func isCIdentifier(name string) bool {
+    for i := 0; i < len(name); i++ {
+        c := name[i]
+        if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+            continue
+        }
+        return false
+    }
+
+    return name != ""
+}
+
+// Mach-O puts an underscore in front of every C name
+func (o Object) cName(symbol string) string {
+    if o.Format == FormatMachO {
+        symbol = strings.TrimPrefix(symbol, "_")
+    }
+
+    return symbol
+}
+
+func (o Object) writeHookStub(file *os.File, fun AssemblyFunction) {
+    fmt.Fprintf(file, "// %s, at 0x%x", fun.Name, fun.Address)
+    if demangled := Demangle(fun.Name); demangled != fun.Name {
+        fmt.Fprintf(file, ": %s", demangled)
+    }
+    fmt.Fprintln(file)
+
+    arguments := GuessArguments(o.Arch, fun)
+    parameters := "void"
+    if arguments == -1 {
+        fmt.Fprintln(file, "// Its arguments are on the stack, so they have to be filled in")
+    } else if arguments > 0 {
+        names := make([]string, 0, arguments)
+        for i := 0; i < arguments; i++ {
+            names = append(names, fmt.Sprintf("long a%d", i))
+        }
+        parameters = strings.Join(names, ", ")
+        fmt.Fprintln(file, "// The arguments are guessed from the registers it reads, and only count integers and pointers")
+    }
+
+    name := o.cName(fun.Name)
+    if !isCIdentifier(name) {
+        // helper.117b or the like, which only the assembler can name
+        identifier := []byte(name)
+        for i, c := range identifier {
+            if !isSymbolChar(c) || c == '.' || c == '$' || c == '@' {
+                identifier[i] = '_'
+            }
+        }
+        fmt.Fprintf(file, "long %s(%s) __asm__(\"%s\");\n", identifier, parameters, fun.Name)
+        name = string(identifier)
+    }
+
+    fmt.Fprintf(file, "long %s(%s) {\n", name, parameters)
+    fmt.Fprintln(file, "    // It used to be:")
+    for _, line := range fun.Content {
+        fmt.Fprintf(file, "    //     %s\n", strings.TrimSpace(line))
+    }
+    fmt.Fprintln(file, "    return 0;")
+    fmt.Fprintln(file, "}")
+}
+
+// Writes a C file with an empty replacement for each hooked function, to fill in and link with the object they were
+// taken out of. A file that's already there is kept, since it's probably been filled in.
+func (o Object) WriteHookStubs(path string, object string, hooked []AssemblyFunction) error {
+    if _, err := os.Stat(path); err == nil {
+        return errors.New(path + " already exists, so it was left alone")
+    }
+
+    file, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    fmt.Fprintf(file, "// Replacements for the functions taken out of %s. Link them in with it:\n", object)
+    fmt.Fprintf(file, "//     cc %s %s\n", object, path)
+    for _, fun := range hooked {
+        fmt.Fprintln(file)
+        o.writeHookStub(file, fun)
+    }
+
+    return nil
+}
@@ -0,0 +1,148 @@
+package disassemble
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func parseDisplacement(operand string) (int, bool) {
+    neg := false
+    if rest, ok := strings.CutPrefix(operand, "-"); ok {
+        neg = true
+        operand = rest
+    } else {
+        operand = strings.TrimPrefix(operand, "+")
+    }
+
+    hex, ok := strings.CutPrefix(operand, "0x")
+    if !ok {
+        return 0, false
+    }
+
+    disp, err := strconv.ParseUint(hex, 16, 32)
+    if err != nil {
+        return 0, false
+    }
+
+    if neg {
+        return -int(disp), true
+    }
+    return int(disp), true
+}
+
+// Where the call/pop that replaces a thunk call pops the address from
+const gotLabel = ".got_"
+
+// 32-bit code has no rip-relative addressing.
+// PIC code calls __x86.get_pc_thunk.* and adds the distance to the GOT, then addresses data relative to that register.
+// Non-PIC code just uses absolute addresses.
+// This must run after MonkeyPatchAssembly.
+func PatchAssembly32(sections []Section, globals []Data, literals []Data, info GotInfo, symbols []string) []Section {
+    output := make([]Section, 0, len(sections))
+
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            code := make([]string, 0, len(fun.Content))
+            gotReg := ""
+            pending := ""
+            labels := 0
+
+            for _, line := range fun.Content {
+                if thunk, ok := strings.CutPrefix(line, "call __x86.get_pc_thunk."); ok {
+                    // Replaced with call/pop so the object does not depend on the thunk
+                    gotReg = "e" + thunk
+                    pending = fmt.Sprintf("%s%d", gotLabel, labels)
+                    labels++
+                    code = append(code, "call " + pending)
+                    continue
+                }
+
+                if pending != "" {
+                    // One instruction per line, like the rest of the code, so the other passes see each of them
+                    label := pending
+                    pending = ""
+                    code = append(code, label + ":", "pop " + gotReg)
+                    fields := strings.Fields(line)
+                    if len(fields) == 2 && fields[0] == "add" && strings.HasPrefix(fields[1], gotReg + ",") {
+                        code = append(code, fmt.Sprintf("add %s,_GLOBAL_OFFSET_TABLE_+$$-%s wrt ..gotpc", gotReg, label))
+                        continue
+                    }
+                }
+
+                if gotReg != "" && strings.Contains(line, "[" + gotReg) {
+                    start := strings.Index(line, "[" + gotReg)
+                    end := start + strings.Index(line[start:], "]")
+                    disp, ok := parseDisplacement(line[start+1+len(gotReg):end])
+                    if ok {
+                        target := info.Got + disp
+                        if symbol, ok := info.GotSymbols[target]; ok {
+                            line = fmt.Sprintf("%s[%s+%s wrt ..got]%s", line[:start], gotReg, symbol, line[end+1:])
+                        } else if symbol, ok := FindDataAt(target, globals, literals); ok {
+                            line = fmt.Sprintf("%s[%s+%s wrt ..gotoff]%s", line[:start], gotReg, symbol, line[end+1:])
+                        }
+                    }
+                }
+
+                if !info.PIE {
+                    line = patchAbsolute32(line, globals, literals)
+                }
+
+                if callee, ok := strings.CutPrefix(line, "call "); ok {
+                    for _, symbol := range symbols {
+                        if symbol == callee {
+                            line += " wrt ..plt"
+                            break
+                        }
+                    }
+                }
+
+                code = append(code, line)
+            }
+
+            funcs = append(funcs, AssemblyFunction{fun.Name, fun.Address, code, fun.Info})
+        }
+
+        output = append(output, Section{section.Name, funcs})
+    }
+
+    return output
+}
+
+func patchAbsolute32(line string, globals []Data, literals []Data) string {
+    if strings.Contains(line, "ds:0x") {
+        start := strings.Index(line, "ds:0x")
+        end := start + 3
+        for end < len(line) && strings.ContainsRune("0123456789abcdefx", rune(line[end])) {
+            end++
+        }
+        addr, err := strconv.ParseUint(line[start+5:end], 16, 32)
+        if err == nil {
+            if symbol, ok := FindDataAt(int(addr), globals, literals); ok {
+                return fmt.Sprintf("%s[%s]%s", line[:start], symbol, line[end:])
+            }
+        }
+        return line
+    }
+
+    // Immediates that land inside data are almost certainly its address
+    operandStart := strings.LastIndexAny(line, " ,") + 1
+    if operandStart == 0 {
+        return line
+    }
+    hex, ok := strings.CutPrefix(line[operandStart:], "0x")
+    if !ok {
+        return line
+    }
+    addr, err := strconv.ParseUint(hex, 16, 32)
+    if err != nil {
+        return line
+    }
+    if symbol, ok := FindDataAt(int(addr), globals, literals); ok {
+        return line[:operandStart] + symbol
+    }
+
+    return line
+}
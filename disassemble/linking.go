@@ -1,83 +1,327 @@
 package disassemble
 
 import (
-    "os/exec"
-    "strings"
-    "errors"
+	"bufio"
+	"debug/elf"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-func GetLinkedFiles(file string) ([]string, error) {
-    dynlink, err := IsDynamicallyLinked(file)
+type LibraryStatus int
+
+const (
+    LibraryFound LibraryStatus = iota
+    // There are files with that name, but they are for another architecture, just like ld.so we skip them
+    LibraryIncompatible
+    LibraryNotFound
+)
+
+func (s LibraryStatus) String() string {
+    switch s {
+    case LibraryIncompatible:
+        return "incompatible"
+    case LibraryNotFound:
+        return "not found"
+    }
+
+    return "found"
+}
+
+type Library struct {
+    // As written in DT_NEEDED
+    Name string
+    // Where it was found, if it was
+    Path string
+    Status LibraryStatus
+    // The executable or library that has the DT_NEEDED entry
+    NeededBy string
+}
+
+// Finds every library the executable needs, including the ones needed by those libraries, the way ld.so would.
+// Unlike ldd, this never runs anything from the executable, so it is safe on untrusted and foreign binaries.
+// If sysroot isn't empty, libraries are looked for in it instead of in the host system.
+func ResolveLibraries(file string, sysroot string) ([]Library, error) {
+    f, err := elf.Open(file)
     if err != nil {
         return nil, err
     }
+    defer f.Close()
+
+    defaults := defaultLibraryPaths(f, sysroot)
+    libraries := []Library{}
+    seen := map[string]bool{}
+
+    queue := []string{file}
+    for len(queue) > 0 {
+        current := queue[0]
+        queue = queue[1:]
 
-    if !dynlink {
-        return []string{}, nil
+        obj, err := elf.Open(current)
+        if err != nil {
+            return nil, err
+        }
+        needed, _ := obj.DynString(elf.DT_NEEDED)
+        rpath, _ := obj.DynString(elf.DT_RPATH)
+        runpath, _ := obj.DynString(elf.DT_RUNPATH)
+        obj.Close()
+
+        // current is already a host path, so $ORIGIN doesn't need the sysroot again
+        origin := filepath.Dir(current)
+        dirs := []string{}
+        // DT_RPATH is ignored when there is a DT_RUNPATH
+        if len(runpath) == 0 {
+            dirs = append(dirs, splitSearchPath(rpath, origin, sysroot)...)
+        }
+        dirs = append(dirs, splitSearchPath([]string{os.Getenv("LD_LIBRARY_PATH")}, origin, sysroot)...)
+        dirs = append(dirs, splitSearchPath(runpath, origin, sysroot)...)
+        dirs = append(dirs, defaults...)
+
+        for _, name := range needed {
+            if seen[name] {
+                continue
+            }
+            seen[name] = true
+
+            library := findLibrary(name, dirs, f, sysroot)
+            library.NeededBy = current
+            libraries = append(libraries, library)
+
+            if library.Status == LibraryFound {
+                queue = append(queue, library.Path)
+            }
+        }
     }
 
-    cmd := exec.Command("ldd", file)
-    buf, err := cmd.CombinedOutput()
+    return libraries, nil
+}
 
-    if err != nil {
-        return nil, errors.New(string(buf)) 
+func findLibrary(name string, dirs []string, target *elf.File, sysroot string) Library {
+    library := Library{name, "", LibraryNotFound, ""}
+
+    candidates := []string{}
+    if strings.Contains(name, "/") {
+        candidates = append(candidates, SysrootPath(sysroot, name))
+    } else {
+        for _, dir := range dirs {
+            candidates = append(candidates, rerootPath(sysroot, filepath.Join(dir, name)))
+        }
     }
 
-    content := string(buf)
+    for _, candidate := range candidates {
+        lib, err := elf.Open(candidate)
+        if err != nil {
+            continue
+        }
+        compatible := lib.Class == target.Class && lib.Machine == target.Machine
+        lib.Close()
 
-    files := strings.Split(strings.TrimSpace(content), "\n")
-    interpreter, err := GetInterpreter(file)
-    if err != nil {
-        return nil, err
+        if !compatible {
+            library.Status = LibraryIncompatible
+            library.Path = candidate
+            continue
+        }
+
+        library.Status = LibraryFound
+        library.Path = candidate
+        return library
     }
 
-    for i := 0; i < len(files); i++ {
-        files[i] = strings.TrimSpace(files[i])
+    return library
+}
+
+// Expands $ORIGIN, splits up colon separated lists and puts the directories in the sysroot.
+// The ones relative to $ORIGIN already are host paths.
+func splitSearchPath(paths []string, origin string, sysroot string) []string {
+    dirs := []string{}
+
+    for _, path := range paths {
+        for _, dir := range strings.Split(path, ":") {
+            if dir == "" {
+                continue
+            }
+            if strings.Contains(dir, "$ORIGIN") || strings.Contains(dir, "${ORIGIN}") {
+                dir = strings.ReplaceAll(dir, "${ORIGIN}", origin)
+                dir = strings.ReplaceAll(dir, "$ORIGIN", origin)
+            } else {
+                dir = filepath.Join(sysroot, dir)
+            }
+            dirs = append(dirs, dir)
+        }
+    }
+
+    return dirs
+}
+
+// Follows the symlinks again for a host path that is in the sysroot
+func rerootPath(sysroot string, path string) string {
+    if sysroot == "" {
+        return path
+    }
+
+    rel, err := filepath.Rel(sysroot, path)
+    if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+        return path
+    }
+
+    return SysrootPath(sysroot, rel)
+}
+
+// Where path (as the target system would see it) is on the host.
+// Symlinks are followed inside the sysroot, because absolute ones would point into the host system otherwise.
+func SysrootPath(sysroot string, path string) string {
+    if sysroot == "" {
+        return path
     }
 
-    withoutInterpreter := make([]string, len(files)-1)
-    j := 0
-    for i := 0; i < len(files); i++ {
-        // because of =>
-        if strings.HasPrefix(files[i], interpreter) {
+    resolved := "/"
+    pending := strings.Split(filepath.Clean("/" + path), "/")
+    links := 0
+    for len(pending) > 0 {
+        part := pending[0]
+        pending = pending[1:]
+        if part == "" || part == "." {
             continue
         }
-        withoutInterpreter[j] = files[i][0:strings.Index(files[i], " (")]
-        j++
+        if part == ".." {
+            resolved = filepath.Dir(resolved)
+            continue
+        }
+
+        next := filepath.Join(resolved, part)
+        target, err := os.Readlink(filepath.Join(sysroot, next))
+        // Gives up on loops after as many links as the kernel would
+        if err != nil || links >= 40 {
+            resolved = next
+            continue
+        }
+        links++
+
+        if filepath.IsAbs(target) {
+            resolved = "/"
+        }
+        pending = append(strings.Split(target, "/"), pending...)
     }
 
-    return withoutInterpreter, nil
+    return filepath.Join(sysroot, resolved)
 }
 
-func IsDynamicallyLinked(file string) (bool, error) {
-    cmd := exec.Command("file", file)
+// ld.so.conf first, then the built-in directories
+func defaultLibraryPaths(f *elf.File, sysroot string) []string {
+    dirs := readLdSoConf(SysrootPath(sysroot, "/etc/ld.so.conf"), sysroot, map[string]bool{})
 
-    buf, err := cmd.CombinedOutput()
+    builtin := []string{}
+    if f.Class == elf.ELFCLASS64 {
+        builtin = append(builtin, "/lib64", "/usr/lib64")
+    }
+    builtin = append(builtin, "/lib", "/usr/lib")
+    for _, dir := range builtin {
+        dirs = append(dirs, filepath.Join(sysroot, dir))
+    }
 
+    return dirs
+}
+
+func readLdSoConf(path string, sysroot string, visited map[string]bool) []string {
+    if visited[path] {
+        return []string{}
+    }
+    visited[path] = true
+
+    file, err := os.Open(path)
     if err != nil {
-        return false, errors.New(string(buf))
+        return []string{}
+    }
+    defer file.Close()
+
+    dirs := []string{}
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if comment := strings.IndexRune(line, '#'); comment != -1 {
+            line = line[:comment]
+        }
+        line = strings.TrimSpace(line)
+        if line == "" {
+            continue
+        }
+
+        if pattern, ok := strings.CutPrefix(line, "include "); ok {
+            pattern = strings.TrimSpace(pattern)
+            if filepath.IsAbs(pattern) {
+                pattern = filepath.Join(sysroot, pattern)
+            } else {
+                pattern = filepath.Join(filepath.Dir(path), pattern)
+            }
+            matches, _ := filepath.Glob(pattern)
+            for _, match := range matches {
+                dirs = append(dirs, readLdSoConf(rerootPath(sysroot, match), sysroot, visited)...)
+            }
+            continue
+        }
+
+        dirs = append(dirs, filepath.Join(sysroot, line))
     }
 
-    out := string(buf)
+    return dirs
+}
+
+// Paths of every library that could be found
+func FoundLibraries(libraries []Library) []string {
+    files := make([]string, 0, len(libraries))
+    for _, library := range libraries {
+        if library.Status == LibraryFound {
+            files = append(files, library.Path)
+        }
+    }
 
-    return strings.Contains(out, "dynamically linked"), nil
+    return files
 }
 
-func GetInterpreter(file string) (string, error) {
-    cmd := exec.Command("file", file)
+func GetLinkedFiles(file string, sysroot string) ([]string, error) {
+    libraries, err := ResolveLibraries(file, sysroot)
+    if err != nil {
+        return nil, err
+    }
 
-    buf, err := cmd.CombinedOutput()
+    return FoundLibraries(libraries), nil
+}
 
+func IsDynamicallyLinked(file string) (bool, error) {
+    f, err := elf.Open(file)
     if err != nil {
-        return "", errors.New(string(buf))
+        return false, err
     }
+    defer f.Close()
 
-    out := strings.Split(string(buf), ", ")
+    for _, prog := range f.Progs {
+        if prog.Type == elf.PT_DYNAMIC || prog.Type == elf.PT_INTERP {
+            return true, nil
+        }
+    }
+
+    return false, nil
+}
+
+// The interpreter's path on the host, which is inside the sysroot if there is one
+func GetInterpreter(file string, sysroot string) (string, error) {
+    f, err := elf.Open(file)
+    if err != nil {
+        return "", err
+    }
+    defer f.Close()
+
+    for _, prog := range f.Progs {
+        if prog.Type != elf.PT_INTERP {
+            continue
+        }
 
-    for _, part := range out {
-        if interpreter, ok := strings.CutPrefix(part, "interpreter "); ok {
-            return interpreter, nil
+        buf := make([]byte, prog.Filesz)
+        if _, err := prog.ReadAt(buf, 0); err != nil {
+            return "", err
         }
+        return SysrootPath(sysroot, strings.TrimRight(string(buf), "\x00")), nil
     }
 
     return "", errors.New("Unable to find interpreter")
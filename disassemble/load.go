@@ -0,0 +1,387 @@
+package disassemble
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Everything extracted from an executable
+type Executable struct {
+    // Libraries it is linked against
+    Files []string
+    // Where each library was looked for and whether it was found, only for ELF
+    Libraries []Library
+    // The dynamic loader, if there is one
+    Interpreter string
+    // For static executables, the runtime archives whose functions were turned into externs
+    Runtime []string
+    // Symbols that come from those libraries
+    Symbols []string
+    // Where the imported symbols come from, as far as we can tell
+    Imports []ImportedSymbol
+    // All sections and symbols, except the insignificant ones
+    Object Object
+}
+
+type LoadOptions struct {
+    // Libraries, the interpreter and the runtime archives are looked for here, if it isn't empty
+    Sysroot string
+    // Copy the libc and libgcc code in static executables as if it was the user's
+    KeepRuntime bool
+    // Signature files for naming library functions in stripped executables
+    Signatures []string
+}
+
+func LoadExecutable(input string, options LoadOptions) (Executable, error) {
+    arch, err := GetArch(input)
+    if err != nil {
+        return Executable{}, err
+    }
+    format, err := GetFormat(input)
+    if err != nil {
+        return Executable{}, err
+    }
+
+    sections, err := GetDumpedAssembly(input)
+    if err != nil {
+        return Executable{}, err
+    }
+
+    var exe Executable
+    switch format {
+    case FormatPE:
+        exe, err = loadPE(input, sections)
+    case FormatMachO:
+        exe, err = loadMachO(input, arch, sections)
+    default:
+        exe, err = loadELF(input, arch, sections, options)
+    }
+    if err != nil {
+        return exe, err
+    }
+
+    exe.Object.Arch = arch
+    exe.Object.Format = format
+    return exe, nil
+}
+
+func loadELF(input string, arch Arch, sections []Section, options LoadOptions) (Executable, error) {
+    exe := Executable{}
+
+    libraries, err := ResolveLibraries(input, options.Sysroot)
+    if err != nil {
+        return exe, err
+    }
+
+    // Static executables don't have one
+    interpreter, _ := GetInterpreter(input, options.Sysroot)
+
+    imports, err := GetImportedSymbols(input)
+    if err != nil {
+        return exe, err
+    }
+    imports = AttributeImports(imports, libraries)
+
+    symbols := FindExternSymbols(sections)
+    sections = RemoveJunk(sections)
+
+    rodata, err := GetDumpedReadonlyData(input)
+    if err != nil {
+        return exe, err
+    }
+
+    globaldata, err := GetDumpedGlobalData(input)
+    if err != nil {
+        return exe, err
+    }
+
+    variables, err := GetDumpedVariableData(input)
+    if err != nil {
+        return exe, err
+    }
+
+    relocated, err := GetDumpedRelocatedData(input)
+    if err != nil {
+        return exe, err
+    }
+
+    // Static functions and variables from different files can share a name
+    var datas [][]Data
+    sections, datas = DisambiguateSymbols(sections, [][]Data{rodata, globaldata, variables, relocated})
+    rodata, globaldata, variables, relocated = datas[0], datas[1], datas[2], datas[3]
+
+    threadLocals, tlsStart, err := GetThreadLocals(input)
+    if err != nil {
+        return exe, err
+    }
+
+    info, err := GetGotInfo(input)
+    if err != nil {
+        return exe, err
+    }
+
+    stripped, err := IsStripped(input)
+    if err != nil {
+        return exe, err
+    }
+    static, err := HasStaticRuntime(input)
+    if err != nil {
+        return exe, err
+    }
+    separate := static && !options.KeepRuntime
+
+    if stripped {
+        // Data only has the section names objdump gave it, so the references need naming too
+        sections = NameAddresses(sections, func(addr int) (string, bool) {
+            return FindDataAt(addr, globaldata, variables, rodata, relocated)
+        })
+    }
+
+    runtime := Runtime{}
+    if separate {
+        runtime, err = LoadRuntime(input, options.Sysroot)
+        if err != nil {
+            return exe, err
+        }
+    }
+
+    signatures := []Signature{}
+    for _, file := range options.Signatures {
+        signatureArch, read, err := ReadSignatures(file)
+        if err != nil {
+            return exe, err
+        }
+        if signatureArch != arch {
+            return exe, fmt.Errorf("%s has signatures for %s, not %s", file, signatureArch, arch)
+        }
+        signatures = append(signatures, read...)
+    }
+    if stripped && separate {
+        // Without a symbol table, the runtime can only be recognized by its code, which the other signatures' calls go to
+        _, runtimeSignatures, err := GenerateSignatures(runtime.Files)
+        if err != nil {
+            return exe, err
+        }
+        signatures = append(signatures, runtimeSignatures...)
+    }
+
+    matched := map[int]string{}
+    if len(signatures) > 0 {
+        matched, err = MatchSignatures(input, sections, signatures)
+        if err != nil {
+            return exe, err
+        }
+        sections = RenameFunctions(sections, signatureRenames(matched))
+    }
+
+    init, fini, err := GetConstructors(input, signatureRenames(matched))
+    if err != nil {
+        return exe, err
+    }
+
+    if separate {
+        names, err := runtime.NameAddresses(input, matched)
+        if err != nil {
+            return exe, err
+        }
+
+        sections = NameAddresses(sections, func(addr int) (string, bool) {
+            name, ok := names[addr]
+            return name, ok
+        })
+        user, err := runtime.UserDefinitions(input)
+        if err != nil {
+            return exe, err
+        }
+        var externs []string
+        sections, externs = runtime.Separate(sections, user)
+        externLoop: for _, extern := range externs {
+            // Literals are copied even if the runtime defines them, like _IO_stdin_used, which user strings come after
+            for _, literal := range rodata {
+                if literal.Name == extern {
+                    continue externLoop
+                }
+            }
+            symbols = append(symbols, extern)
+        }
+        globaldata = runtime.SeparateData(globaldata)
+        variables = runtime.SeparateData(variables)
+        threadLocals = runtime.SeparateData(threadLocals)
+        relocated = runtime.SeparateData(relocated)
+        exe.Runtime = runtime.Files
+    }
+
+    // Vtables, typeinfo and function pointer tables, so they point at symbols again
+    names := pointerNames(input, sections, globaldata, variables, rodata, relocated)
+    variables, err = FindPointers(input, variables, names)
+    if err != nil {
+        return exe, err
+    }
+    relocated, err = FindPointers(input, relocated, names)
+    if err != nil {
+        return exe, err
+    }
+
+    // The patches only need to know where the writable data is
+    writable := append(append(append([]Data{}, globaldata...), variables...), relocated...)
+
+    switch arch {
+    case ArchAArch64:
+        symbols = append(symbols, info.ExternSymbols()...)
+        sections = PatchAssemblyA64(sections, writable, rodata, info)
+    case ArchRISCV64:
+        symbols = append(symbols, info.ExternSymbols()...)
+        sections = PatchAssemblyRV64(sections, writable, rodata, info, symbols)
+    case ArchI386:
+        sections = MonkeyPatchAssembly(sections, globaldata, rodata, symbols)
+        symbols = append(symbols, info.ExternSymbols()...)
+        sections = PatchAssembly32(sections, writable, rodata, info, symbols)
+    default:
+        // Thread-locals from libraries are accessed through GOT slots too
+        sections = NameAddresses(sections, func(addr int) (string, bool) {
+            if symbol, ok := info.TLSSymbols[addr]; ok {
+                return symbol + " wrt ..gottpoff", true
+            }
+            return "", false
+        })
+        tlsSymbols := make([]string, 0, len(info.TLSSymbols))
+        for _, symbol := range info.TLSSymbols {
+            tlsSymbols = append(tlsSymbols, symbol)
+        }
+        sort.Strings(tlsSymbols)
+        symbols = append(symbols, tlsSymbols...)
+        sections = MonkeyPatchAssembly(sections, globaldata, rodata, symbols)
+        sections = PatchTLS64(sections, threadLocals, tlsStart)
+    }
+
+    exe.Files = FoundLibraries(libraries)
+    exe.Libraries = libraries
+    exe.Interpreter = interpreter
+    exe.Symbols = symbols
+    exe.Imports = imports
+    exe.Object = Object{
+        Globals: globaldata,
+        Variables: variables,
+        ThreadLocals: threadLocals,
+        Literals: rodata,
+        Relocated: relocated,
+        Sections: sections,
+        InitArray: init,
+        FiniArray: fini,
+    }
+
+    infos, err := GetSymbolInfo(input)
+    if err != nil {
+        return exe, err
+    }
+    exe.Object = exe.Object.WithSymbolInfo(infos)
+    return exe, nil
+}
+
+func loadPE(input string, sections []Section) (Executable, error) {
+    exe := Executable{}
+
+    rodata, err := GetDumpedData(input, ".rdata")
+    if err != nil {
+        return exe, err
+    }
+
+    globaldata, err := GetDumpedGlobalData(input)
+    if err != nil {
+        return exe, err
+    }
+
+    variables, err := GetDumpedVariableData(input)
+    if err != nil {
+        return exe, err
+    }
+
+    imports, err := GetImports(input)
+    if err != nil {
+        return exe, err
+    }
+
+    sections = PatchImports(sections, imports)
+    sections = MonkeyPatchAssembly(sections, globaldata, rodata, []string{})
+
+    exe.Files = imports.Libraries
+    exe.Symbols = append(FindImportThunks(sections), imports.Symbols()...)
+    exe.Imports = imports.Attributed()
+    exe.Object = Object{
+        Globals: globaldata,
+        Variables: variables,
+        Literals: rodata,
+        Sections: RemoveImportThunks(sections),
+    }
+    return exe, nil
+}
+
+func loadMachO(input string, arch Arch, sections []Section) (Executable, error) {
+    exe := Executable{}
+
+    rodata := []Data{}
+    for _, section := range []string{"__cstring", "__const"} {
+        data, err := GetMachOData(input, "__TEXT", section)
+        if err != nil {
+            return exe, err
+        }
+        rodata = append(rodata, data...)
+    }
+
+    globaldata := []Data{}
+    for _, section := range []string{"__bss", "__common"} {
+        data, err := GetMachOData(input, "__DATA", section)
+        if err != nil {
+            return exe, err
+        }
+        for _, d := range data {
+            d.Extern = true
+            globaldata = append(globaldata, d)
+        }
+    }
+
+    variables, err := GetMachOData(input, "__DATA", "__data")
+    if err != nil {
+        return exe, err
+    }
+    for i := range variables {
+        variables[i].Extern = true
+    }
+
+    imports, info, err := GetMachOImports(input)
+    if err != nil {
+        return exe, err
+    }
+
+    writable := append(append([]Data{}, globaldata...), variables...)
+
+    sections = NormalizeMachOSections(sections)
+    if arch == ArchAArch64 {
+        sections = PatchImports(sections, imports)
+        sections = PatchAssemblyA64(sections, writable, rodata, info)
+        sections = PatchAssemblyA64MachO(sections)
+    } else {
+        // Stripped executables don't have names for the stubs, GOT slots or C strings, so name them ourselves
+        sections = NameAddresses(sections, func(addr int) (string, bool) {
+            if symbol, ok := imports.Slots[addr]; ok {
+                return symbol, true
+            }
+            if symbol, ok := info.GotSymbols[addr]; ok {
+                return symbol + " wrt ..gotpcrel", true
+            }
+            return FindDataAt(addr, writable, rodata)
+        })
+        sections = MonkeyPatchAssembly(sections, globaldata, rodata, []string{})
+    }
+
+    exe.Files = imports.Libraries
+    exe.Symbols = append(imports.Symbols(), info.ExternSymbols()...)
+    exe.Imports = imports.Attributed()
+    exe.Object = Object{
+        Globals: globaldata,
+        Variables: variables,
+        Literals: rodata,
+        Sections: sections,
+    }
+    return exe, nil
+}
@@ -0,0 +1,243 @@
+package disassemble
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+    machoSectionType = 0xff
+    machoNonLazySymbolPointers = 0x6
+    machoLazySymbolPointers = 0x7
+    machoSymbolStubs = 0x8
+
+    machoIndirectSymbolLocal = 0x80000000
+    machoIndirectSymbolAbs = 0x40000000
+
+    machoSegmentHeaderSize = 72
+    machoSectionHeaderSize = 80
+)
+
+// Mach-O calls imports through __stubs and loads imported data through __got.
+// Which symbol each entry belongs to is in the indirect symbol table, indexed by reserved1 of the section header,
+// which debug/macho doesn't expose.
+func GetMachOImports(file string) (Imports, GotInfo, error) {
+    imports := Imports{map[int]string{}, []string{}, map[string]string{}}
+    info := GotInfo{0, map[int]string{}, map[int]string{}, true}
+
+    f, err := macho.Open(file)
+    if err != nil {
+        return imports, info, err
+    }
+    defer f.Close()
+
+    libraries, err := f.ImportedLibraries()
+    if err != nil {
+        return imports, info, err
+    }
+    imports.Libraries = libraries
+
+    if f.Symtab == nil || f.Dysymtab == nil {
+        return imports, info, nil
+    }
+
+    for _, load := range f.Loads {
+        segment, ok := load.(*macho.Segment)
+        if !ok {
+            continue
+        }
+
+        raw := segment.Raw()
+        for i := 0; i < int(segment.Nsect); i++ {
+            header := raw[machoSegmentHeaderSize + i*machoSectionHeaderSize:]
+            if len(header) < machoSectionHeaderSize {
+                break
+            }
+
+            addr := binary.LittleEndian.Uint64(header[32:40])
+            size := binary.LittleEndian.Uint64(header[40:48])
+            flags := binary.LittleEndian.Uint32(header[64:68])
+            first := binary.LittleEndian.Uint32(header[68:72])
+            stride := uint64(binary.LittleEndian.Uint32(header[72:76]))
+
+            kind := flags & machoSectionType
+            if kind != machoSymbolStubs {
+                stride = 8
+            }
+            if kind != machoSymbolStubs && kind != machoLazySymbolPointers && kind != machoNonLazySymbolPointers {
+                continue
+            }
+            if stride == 0 {
+                continue
+            }
+
+            for j := uint64(0); j < size/stride; j++ {
+                index := int(first) + int(j)
+                if index >= len(f.Dysymtab.IndirectSyms) {
+                    break
+                }
+                sym := f.Dysymtab.IndirectSyms[index]
+                if sym & (machoIndirectSymbolLocal | machoIndirectSymbolAbs) != 0 || int(sym) >= len(f.Symtab.Syms) {
+                    continue
+                }
+
+                slot := int(addr + j*stride)
+                name := f.Symtab.Syms[sym].Name
+                // Two-level namespace: the high byte of n_desc is the 1-based index of the dylib
+                ordinal := int(f.Symtab.Syms[sym].Desc >> 8)
+                if ordinal > 0 && ordinal <= len(imports.Libraries) {
+                    imports.From[name] = imports.Libraries[ordinal-1]
+                }
+                if kind == machoNonLazySymbolPointers {
+                    info.GotSymbols[slot] = name
+                } else {
+                    imports.Slots[slot] = name
+                }
+            }
+        }
+    }
+
+    return imports, info, nil
+}
+
+// Reads a section straight from the file and splits it up by the symbols in it.
+// Bytes before the first symbol (linked C strings usually have none) get a name made up from the address.
+func GetMachOData(file string, segment string, section string) ([]Data, error) {
+    f, err := macho.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    index := 0
+    var sect *macho.Section
+    for i, s := range f.Sections {
+        if s.Seg == segment && s.Name == section {
+            index = i+1
+            sect = s
+            break
+        }
+    }
+    if sect == nil {
+        return []Data{}, nil
+    }
+
+    var content []byte
+    if sect.Flags & machoSectionType == 0x1 || sect.Flags & machoSectionType == 0xc {
+        // zerofill, nothing in the file
+        content = make([]byte, sect.Size)
+    } else {
+        content, err = sect.Data()
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    symbols := []macho.Symbol{}
+    if f.Symtab != nil {
+        for _, sym := range f.Symtab.Syms {
+            // Skip debugging entries
+            if sym.Type & 0xe0 != 0 || int(sym.Sect) != index {
+                continue
+            }
+            symbols = append(symbols, sym)
+        }
+    }
+    sort.Slice(symbols, func(i, j int) bool {
+        return symbols[i].Value < symbols[j].Value
+    })
+
+    data := []Data{}
+    start := sect.Addr
+    name := fmt.Sprintf("%s_%x", strings.TrimLeft(section, "_"), sect.Addr)
+    for _, sym := range symbols {
+        if sym.Value > start {
+            data = append(data, Data{name, int(start), content[start-sect.Addr:sym.Value-sect.Addr], false, nil, SymbolInfo{}})
+        }
+        start = sym.Value
+        name = sym.Name
+    }
+    if start < sect.Addr+sect.Size {
+        data = append(data, Data{name, int(start), content[start-sect.Addr:], false, nil, SymbolInfo{}})
+    }
+
+    return data, nil
+}
+
+// Makes the sections look like they came from an ELF file, so -s .text works the same everywhere.
+// The stubs go away, like .plt does.
+func NormalizeMachOSections(sections []Section) []Section {
+    useful := make([]Section, 0, len(sections))
+
+    for _, section := range sections {
+        switch section.Name {
+        case "__TEXT,__stubs", "__TEXT,__stub_helper":
+            continue
+        case "__TEXT,__text":
+            section.Name = ".text"
+        }
+
+        useful = append(useful, section)
+    }
+
+    return useful
+}
+
+// A64 Mach-O spells the page relocations differently, and its local labels start with L rather than .L
+func PatchAssemblyA64MachO(sections []Section) []Section {
+    output := make([]Section, 0, len(sections))
+
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            code := make([]string, 0, len(fun.Content))
+
+            for _, line := range fun.Content {
+                if label, ok := strings.CutSuffix(line, ":"); ok {
+                    if _, ok := splitGasBranchLabel(label); ok {
+                        code = append(code, strings.TrimPrefix(line, "."))
+                        continue
+                    }
+                }
+                mnemonic, operands := splitOperands(line)
+
+                for i, operand := range operands {
+                    if _, ok := splitGasBranchLabel(operand); ok {
+                        operands[i] = strings.TrimPrefix(operand, ".")
+                    } else if symbol, ok := strings.CutPrefix(operand, ":got:"); ok {
+                        operands[i] = symbol + "@GOTPAGE"
+                    } else if symbol, ok := strings.CutPrefix(operand, ":got_lo12:"); ok {
+                        operands[i] = symbol + "@GOTPAGEOFF"
+                    } else if symbol, ok := strings.CutPrefix(operand, ":lo12:"); ok {
+                        operands[i] = symbol + "@PAGEOFF"
+                    } else if strings.HasPrefix(operand, "[") && strings.Contains(operand, ":") {
+                        inner := strings.TrimSuffix(strings.TrimPrefix(operand, "["), "]")
+                        base, reloc, _ := strings.Cut(inner, ", ")
+                        if symbol, ok := strings.CutPrefix(reloc, ":got_lo12:"); ok {
+                            operands[i] = "[" + base + ", " + symbol + "@GOTPAGEOFF]"
+                        } else if symbol, ok := strings.CutPrefix(reloc, ":lo12:"); ok {
+                            operands[i] = "[" + base + ", " + symbol + "@PAGEOFF]"
+                        }
+                    } else if mnemonic == "adrp" && i == 1 && !strings.HasPrefix(operand, "0x") {
+                        operands[i] = operand + "@PAGE"
+                    }
+                }
+
+                if len(operands) > 0 {
+                    line = mnemonic + " " + strings.Join(operands, ", ")
+                }
+                code = append(code, line)
+            }
+
+            funcs = append(funcs, AssemblyFunction{fun.Name, fun.Address, code, fun.Info})
+        }
+
+        output = append(output, Section{section.Name, funcs})
+    }
+
+    return output
+}
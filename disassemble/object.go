@@ -5,21 +5,31 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 type Object struct {
+    Arch Arch
+    Format Format
     Globals []Data
+    // Initialized writable data, which is extern by default like Globals
+    Variables []Data
+    // Thread-locals, located by their offset in the TLS block. Extern by default too
+    ThreadLocals []Data
     Literals []Data
+    // Read-only data with pointers in it, like vtables and typeinfo. Extern by default as well
+    Relocated []Data
     Sections []Section
+    // Functions in .init_array and .fini_array, which go with whichever object ends up with them
+    InitArray []string
+    FiniArray []string
 }
 
 func (o Object) Empty() Object {
-    return Object {
-        o.Globals,
-        o.Literals,
-        make([]Section, 0, len(o.Sections)),
-    }
+    o.Sections = make([]Section, 0, len(o.Sections))
+    return o
 }
 
 func (o Object) RemoveSection(name string) Object {
@@ -33,11 +43,8 @@ func (o Object) RemoveSection(name string) Object {
         sections = append(sections, section)
     }
 
-    return Object {
-        o.Globals,
-        o.Literals,
-        sections,
-    }
+    o.Sections = sections
+    return o
 }
 
 func (o Object) RemoveSymbol(name string, section string) Object {
@@ -60,11 +67,8 @@ func (o Object) RemoveSymbol(name string, section string) Object {
         sections = append(sections, sec)
     }
 
-    return Object {
-        o.Globals,
-        o.Literals,
-        sections,
-    }
+    o.Sections = sections
+    return o
 }
 
 func (o Object) HasSection(name string) bool {
@@ -79,7 +83,7 @@ func (o Object) HasSection(name string) bool {
 
 func (o Object) HasSymbol(name string, section string) bool {
     for _, sec := range o.Sections {
-        if sec.Name == name {
+        if sec.Name == section {
             for _, fun := range sec.Funcs {
                 if fun.Name == name {
                     return true
@@ -106,11 +110,8 @@ func (o Object) TakeSectionFrom(name string, source Object) Object {
         }
     }
 
-    return Object {
-        o.Globals,
-        o.Literals,
-        sections,
-    }
+    o.Sections = sections
+    return o
 }
 
 func (o Object) TakeSymbolFrom(name string, section string, source Object) Object {
@@ -154,11 +155,8 @@ func (o Object) TakeSymbolFrom(name string, section string, source Object) Objec
         sections = append(sections, sec)
     }
 
-    return Object {
-        o.Globals,
-        o.Literals,
-        sections,
-    }
+    o.Sections = sections
+    return o
 }
 
 func (o Object) IncludeGlobal(name string) Object {
@@ -171,22 +169,152 @@ func (o Object) IncludeGlobal(name string) Object {
         globals = append(globals, global)
     }
 
-    return Object{
-        globals,
-        o.Literals,
-        o.Sections,
+    variables := make([]Data, 0, len(o.Variables))
+
+    for _, variable := range o.Variables {
+        if variable.Name == name {
+            variable.Extern = false
+        }
+        variables = append(variables, variable)
+    }
+
+    threadLocals := make([]Data, 0, len(o.ThreadLocals))
+
+    for _, local := range o.ThreadLocals {
+        if local.Name == name {
+            local.Extern = false
+        }
+        threadLocals = append(threadLocals, local)
+    }
+
+    relocated := make([]Data, 0, len(o.Relocated))
+
+    for _, r := range o.Relocated {
+        if r.Name == name {
+            r.Extern = false
+        }
+        relocated = append(relocated, r)
+    }
+
+    o.Globals = globals
+    o.Variables = variables
+    o.ThreadLocals = threadLocals
+    o.Relocated = relocated
+    return o
+}
+
+func isSymbolChar(c byte) bool {
+    return c == '_' || c == '.' || c == '$' || c == '@' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Mach-O writes relocations after the symbol, and @ can be in a symbol too (stderr@GLIBC_2.2.5)
+var machORelocations = []string{"@PAGE", "@PAGEOFF", "@GOTPAGE", "@GOTPAGEOFF", "@TLVPPAGE", "@TLVPPAGEOFF"}
+
+// Splits "_x@PAGEOFF" into "_x" and "@PAGEOFF"
+func splitRelocation(word string) (string, string) {
+    for _, reloc := range machORelocations {
+        if symbol, ok := strings.CutSuffix(word, reloc); ok && symbol != "" {
+            return symbol, reloc
+        }
+    }
+
+    return word, ""
+}
+
+// Checks if a symbol ending at the start of rest ends there as a whole word
+func endsWord(rest string) bool {
+    if rest == "" || !isSymbolChar(rest[0]) {
+        return true
+    }
+    for _, reloc := range machORelocations {
+        if strings.HasPrefix(rest, reloc) && (len(rest) == len(reloc) || !isSymbolChar(rest[len(reloc)])) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// Checks if the line mentions the symbol as a whole word, so [rel x], [ebx+x wrt ..gotoff], :lo12:x or _x@PAGEOFF but not [rel xs]
+func ReferencesData(line string, name string) bool {
+    for i := strings.Index(line, name); i != -1; {
+        end := i + len(name)
+        if (i == 0 || !isSymbolChar(line[i-1])) && endsWord(line[end:]) {
+            return true
+        }
+
+        next := strings.Index(line[i+1:], name)
+        if next == -1 {
+            break
+        }
+        i += next + 1
+    }
+
+    return false
+}
+
+func (o Object) referencedByCode(name string) bool {
+    for _, sec := range o.Sections {
+        for _, fun := range sec.Funcs {
+            for _, line := range fun.Content {
+                if ReferencesData(line, name) {
+                    return true
+                }
+            }
+        }
+    }
+
+    return false
+}
+
+// Everything the pointers in data this object defines point at
+func pointedTo(datas ...[]Data) map[string]bool {
+    targets := map[string]bool{}
+    for _, data := range datas {
+        for _, d := range data {
+            if d.Extern {
+                continue
+            }
+            for _, pointer := range d.Pointers {
+                targets[pointerTarget(pointer)] = true
+            }
+        }
     }
+
+    return targets
 }
 
 func (o Object) Trim() Object {
     globals := make([]Data, 0, len(o.Globals))
+    variables := make([]Data, 0, len(o.Variables))
+    threadLocals := make([]Data, 0, len(o.ThreadLocals))
     literals := make([]Data, 0, len(o.Literals))
 
+    // A vtable points to the typeinfo, which points to its name, so this goes on until nothing new is found
+    relocated := make([]Data, 0, len(o.Relocated))
+    kept := map[string]bool{}
+    for found := true; found; {
+        found = false
+        targets := pointedTo(o.Variables, relocated)
+        for _, r := range o.Relocated {
+            if !kept[r.Name] && (targets[r.Name] || o.referencedByCode(r.Name)) {
+                relocated = append(relocated, r)
+                kept[r.Name] = true
+                found = true
+            }
+        }
+    }
+    targets := pointedTo(o.Variables, relocated)
+
     globalLoop: for _, global := range o.Globals {
+        if targets[global.Name] {
+            globals = append(globals, global)
+            continue
+        }
         for _, sec := range o.Sections {
             for _, fun := range sec.Funcs {
                 for _, line := range fun.Content {
-                    if strings.Contains(line, "[rel " + global.Name + "]") || strings.Contains(line, "[rel " + global.Name + "+") {
+                    if ReferencesData(line, global.Name) {
                         globals = append(globals, global)
                         continue globalLoop
                     }
@@ -194,7 +322,43 @@ func (o Object) Trim() Object {
             }
         }
     }
+    variableLoop: for _, variable := range o.Variables {
+        if targets[variable.Name] {
+            variables = append(variables, variable)
+            continue
+        }
+        for _, sec := range o.Sections {
+            for _, fun := range sec.Funcs {
+                for _, line := range fun.Content {
+                    if ReferencesData(line, variable.Name) {
+                        variables = append(variables, variable)
+                        continue variableLoop
+                    }
+                }
+            }
+        }
+    }
+    threadLocalLoop: for _, local := range o.ThreadLocals {
+        if targets[local.Name] {
+            threadLocals = append(threadLocals, local)
+            continue
+        }
+        for _, sec := range o.Sections {
+            for _, fun := range sec.Funcs {
+                for _, line := range fun.Content {
+                    if ReferencesData(line, local.Name) {
+                        threadLocals = append(threadLocals, local)
+                        continue threadLocalLoop
+                    }
+                }
+            }
+        }
+    }
     literalLoop: for _, literal := range o.Literals {
+        if targets[literal.Name] {
+            literals = append(literals, literal)
+            continue
+        }
         for _, sec := range o.Sections {
             for _, fun := range sec.Funcs {
                 for _, line := range fun.Content {
@@ -207,21 +371,33 @@ func (o Object) Trim() Object {
         }
     }
 
-    return Object {
-        globals,
-        literals,
-        o.Sections,
-    }
+    o.Globals = globals
+    o.Variables = variables
+    o.ThreadLocals = threadLocals
+    o.Literals = literals
+    o.Relocated = relocated
+    return o
 }
 
 func (o Object) TrimSymbols(symbols []string) []string {
     used := make([]string, 0, len(symbols))
 
+    // Functions this object defines aren't external, even if other objects have calls to them too
+    defined := map[string]bool{}
+    for _, sec := range o.Sections {
+        for _, fun := range sec.Funcs {
+            defined[fun.Name] = true
+        }
+    }
+
     symbolLoop: for _, symbol := range symbols {
+        if defined[symbol] {
+            continue
+        }
         for _, sec := range o.Sections {
             for _, fun := range sec.Funcs {
                 for _, line := range fun.Content {
-                    if strings.Contains(line, symbol) {
+                    if ReferencesData(line, symbol) {
                         used = append(used, symbol)
                         continue symbolLoop 
                     }
@@ -240,7 +416,7 @@ func (o Object) AddUnusedSymbols(symbols []string) []string {
         for _, fun := range sec.Funcs {
             for _, line := range fun.Content {
                 for _, symbol := range symbols {
-                    if strings.Contains(line, symbol) {
+                    if ReferencesData(line, symbol) {
                         found := false
                         for _, u := range used {
                             if u == symbol {
@@ -275,7 +451,7 @@ func (o Object) AddNecessarySymbols(in Object, symbols []string) []string {
                         continue
                     }
                     for _, oLine := range oFun.Content {
-                        if strings.Contains(oLine, inFun.Name) {
+                        if ReferencesData(oLine, inFun.Name) {
                             found := false
                             for _, u := range used {
                                 if u == inFun.Name {
@@ -295,7 +471,65 @@ func (o Object) AddNecessarySymbols(in Object, symbols []string) []string {
     return used
 }
 
-func (o Object) Output(filepath string, files []string, symbols []string) error {
+// Bytes, except for pointers, which are written as whatever they point at
+func (o Object) writeNasmData(file *os.File, data Data) {
+    pointer, size := "dq", 8
+    if o.Arch == ArchI386 {
+        pointer, size = "dd", 4
+    }
+
+    for i := 0; i < len(data.Data); {
+        if target, ok := data.Pointers[i]; ok {
+            fmt.Fprintf(file, "\t%s %s\n", pointer, target)
+            i += size
+            continue
+        }
+
+        fmt.Fprintf(file, "\tdb ")
+        for ; i < len(data.Data); i++ {
+            if _, ok := data.Pointers[i]; ok {
+                break
+            }
+            fmt.Fprintf(file, "%d", data.Data[i])
+            if _, ok := data.Pointers[i+1]; ok || i == len(data.Data)-1 {
+                fmt.Fprint(file, "\n")
+            } else {
+                fmt.Fprintf(file, ",")
+            }
+        }
+    }
+}
+
+// What defined data points at but nothing declares, like functions that weren't taken or the ABI's vtables for typeinfo
+func (o Object) undefinedPointers(symbols []string) []string {
+    declared := map[string]bool{}
+    for _, symbol := range symbols {
+        declared[symbol] = true
+    }
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            declared[fun.Name] = true
+        }
+    }
+    for _, datas := range [][]Data{o.Globals, o.Variables, o.ThreadLocals, o.Literals, o.Relocated} {
+        for _, data := range datas {
+            declared[data.Name] = true
+        }
+    }
+
+    undefined := []string{}
+    for target := range pointedTo(o.Variables, o.Relocated) {
+        if !declared[target] {
+            undefined = append(undefined, target)
+            declared[target] = true
+        }
+    }
+    sort.Strings(undefined)
+
+    return undefined
+}
+
+func (o Object) Output(filepath string, imports []ImportedSymbol, symbols []string) error {
     file, err := os.CreateTemp("", "unld_asm_")
     //file, err := os.Create(filepath)
     if err != nil {
@@ -307,8 +541,27 @@ func (o Object) Output(filepath string, files []string, symbols []string) error
     o = o.Trim()
     symbols = o.TrimSymbols(symbols)
     symbols = o.AddUnusedSymbols(symbols)
+    // Vtables and typeinfo need the library's type_info classes, which no code references
+    symbols = append(symbols, o.undefinedPointers(symbols)...)
+
+    if err := o.checkThreadLocals(); err != nil {
+        return err
+    }
+    if err := o.checkBranchLabels(); err != nil {
+        return err
+    }
+    if err := o.checkPageAddresses(); err != nil {
+        return err
+    }
+    if !o.Arch.UsesNasm() {
+        return o.outputGas(file, filepath, imports, symbols)
+    }
 
     for _, symbol := range symbols {
+        // nasm has no .symver, but the linker treats an @ in an undefined symbol's name as its version
+        if imp, ok := FindImport(imports, symbol); ok && imp.Version != "" {
+            fmt.Fprintf(file, "%%define %s %s\n", symbol, imp)
+        }
         fmt.Fprintln(file, "extern", symbol)
     }
     if len(o.Globals) > 0 {
@@ -317,42 +570,125 @@ func (o Object) Output(filepath string, files []string, symbols []string) error
             if global.Extern {
                 fmt.Fprintf(file, "extern %s\n", global.Name)
             } else {
-                fmt.Fprintf(file, "global %s\n%s:\n", global.Name, global.Name)
+                fmt.Fprintf(file, "%s%s:\n", o.nasmGlobal(global.Name, strconv.Itoa(dataSize(global)), global.Info), global.Name)
                 fmt.Fprintf(file, "\tresb %d\n", len(global.Data))
             }
         }
     }
+    if len(o.Variables) > 0 {
+        fmt.Fprintln(file, "section .data")
+        for _, variable := range o.Variables {
+            if variable.Extern {
+                fmt.Fprintf(file, "extern %s\n", variable.Name)
+                continue
+            }
+            fmt.Fprintf(file, "%s%s:\n", o.nasmGlobal(variable.Name, strconv.Itoa(dataSize(variable)), variable.Info), variable.Name)
+            o.writeNasmData(file, variable)
+        }
+    }
+    if len(o.ThreadLocals) > 0 {
+        for _, local := range o.ThreadLocals {
+            if local.Extern {
+                fmt.Fprintf(file, "extern %s\n", local.Name)
+                continue
+            }
+            if isZero(local.Data) {
+                fmt.Fprintln(file, "section .tbss")
+                fmt.Fprintf(file, "%s%s:\n", o.nasmGlobal(local.Name, "", local.Info), local.Name)
+                fmt.Fprintf(file, "\tresb %d\n", len(local.Data))
+                continue
+            }
+            fmt.Fprintln(file, "section .tdata")
+            fmt.Fprintf(file, "%s%s:\n", o.nasmGlobal(local.Name, "", local.Info), local.Name)
+            o.writeNasmData(file, local)
+        }
+    }
     if len(o.Literals) > 0 {
-        fmt.Fprintln(file, "section .rodata")
+        fmt.Fprintf(file, "section %s\n", o.Format.ReadonlySection())
         for _, literal := range o.Literals {
             fmt.Fprintf(file, "%s:\n", literal.Name)
-            fmt.Fprintf(file, "\tdb ")
-            for i := 0; i < len(literal.Data); i++ {
-                fmt.Fprintf(file, "%d", literal.Data[i])
-                if i == len(literal.Data)-1 {
-                    fmt.Fprint(file, "\n")
-                } else {
-                    fmt.Fprintf(file, ",")
-                }
+            o.writeNasmData(file, literal)
+        }
+    }
+    if len(o.Relocated) > 0 {
+        // Writable until the dynamic linker is done with it, like the linker would make it
+        fmt.Fprintln(file, "section .data.rel.ro progbits alloc noexec write align=8")
+        for _, r := range o.Relocated {
+            if r.Extern {
+                fmt.Fprintf(file, "extern %s\n", r.Name)
+                continue
             }
+            fmt.Fprintf(file, "%s%s:\n", o.nasmGlobal(r.Name, strconv.Itoa(dataSize(r)), r.Info), r.Name)
+            o.writeNasmData(file, r)
+        }
+    }
+    pointer := "dq"
+    if o.Arch == ArchI386 {
+        pointer = "dd"
+    }
+    if init := o.ownConstructors(o.InitArray); len(init) > 0 && o.Format == FormatELF {
+        fmt.Fprintln(file, "section .init_array")
+        for _, name := range init {
+            fmt.Fprintf(file, "\t%s %s\n", pointer, name)
+        }
+    }
+    if fini := o.ownConstructors(o.FiniArray); len(fini) > 0 && o.Format == FormatELF {
+        fmt.Fprintln(file, "section .fini_array")
+        for _, name := range fini {
+            fmt.Fprintf(file, "\t%s %s\n", pointer, name)
         }
     }
     for _, section := range o.Sections {
         fmt.Fprintf(file, "section %s\n", section.Name)
         for _, fun := range section.Funcs {
-            fmt.Fprintf(file, "global %s\n%s:\n", fun.Name, fun.Name)
+            // Code is always a function, even when stripped
+            info := fun.Info
+            info.Type = SymbolFunction
+            fmt.Fprintf(file, "%s%s:\n", o.nasmGlobal(fun.Name, fmt.Sprintf("(%s.end - %s)", fun.Name, fun.Name), info), fun.Name)
             for _, line := range fun.Content {
                 fmt.Fprintf(file, "\t%s\n", line)
             }
+            fmt.Fprintln(file, ".end:")
         }
     }
 
-    assembling := exec.Command("nasm", "-felf64", file.Name(), "-o", filepath)
+    assembling := exec.Command("nasm", "-f" + o.Format.NasmFormat(o.Arch), file.Name(), "-o", filepath)
     out, err := assembling.CombinedOutput()
 
     if err != nil {
         return errors.New(string(out))
     }
 
+    return o.stripLabels(filepath)
+}
+
+// nasm puts local labels in the symbol table, so the .end after every function and the labels in the code (the ones
+// branches go to, PatchAssembly32's call/pop and the trace logger's loop) are taken out again.
+// Otherwise objdump (and whatever reads it, like verify and compare) takes them for functions.
+func (o Object) stripLabels(object string) error {
+    list, err := os.CreateTemp("", "unld_labels_")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(list.Name())
+
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            fmt.Fprintln(list, fun.Name + ".end")
+            for _, line := range fun.Content {
+                // Every local label in the code, since the trace logger's loop has some too
+                if label, ok := strings.CutSuffix(strings.TrimSpace(line), ":"); ok && strings.HasPrefix(label, ".") && !strings.ContainsAny(label, " \t") {
+                    fmt.Fprintln(list, fun.Name + label)
+                }
+            }
+        }
+    }
+    list.Close()
+
+    out, err := exec.Command(objcopyCommand(o.Format), "--strip-symbols=" + list.Name(), object).CombinedOutput()
+    if err != nil {
+        return errors.New(string(out))
+    }
+
     return nil
 }
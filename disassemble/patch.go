@@ -0,0 +1,406 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Where Patch put the new code of a function
+type PatchResult struct {
+    Function string
+    // The function in the executable
+    Address int
+    Size int
+    // Where the new code starts, which is Address when it fit in place
+    Location int
+    Length int
+    InPlace bool
+}
+
+// Every address a replacement can refer to, under the names unld gives them: functions, data and the PLT entries of imports
+func patchSymbols(input string, exe Executable) (map[string]int, error) {
+    symbols := map[string]int{}
+
+    sections, err := GetDumpedAssembly(input)
+    if err != nil {
+        return symbols, err
+    }
+    for _, section := range sections {
+        for _, fun := range section.Funcs {
+            if name, ok := strings.CutSuffix(fun.Name, "@plt"); ok {
+                symbols[name] = fun.Address
+            }
+        }
+    }
+
+    for _, section := range exe.Object.Sections {
+        for _, fun := range section.Funcs {
+            symbols[fun.Name] = fun.Address
+        }
+    }
+    // Thread-locals are at offsets, not addresses, so they can't be referred to like this
+    for _, datas := range [][]Data{exe.Object.Globals, exe.Object.Variables, exe.Object.Literals, exe.Object.Relocated} {
+        for _, data := range datas {
+            symbols[data.Name] = data.Location
+        }
+    }
+
+    return symbols, nil
+}
+
+// The function to patch, and how big it is
+func findPatchedFunction(o Object, name string) (AssemblyFunction, int, error) {
+    for _, section := range o.Sections {
+        matched := o.MatchFunctions(name, section.Name)
+        if len(matched) == 0 {
+            continue
+        }
+        if len(matched) > 1 {
+            return AssemblyFunction{}, 0, fmt.Errorf("%s matches %s, which one should be patched?", name, strings.Join(matched, ", "))
+        }
+
+        fun, _ := o.FindFunction(matched[0], section.Name)
+        if fun.Info.Size > 0 {
+            return fun, fun.Info.Size, nil
+        }
+
+        // It goes on until the next one
+        next := -1
+        for _, other := range section.Funcs {
+            if other.Address > fun.Address && (next == -1 || other.Address < next) {
+                next = other.Address
+            }
+        }
+        if next == -1 {
+            return fun, 0, errors.New("The size of " + fun.Name + " isn't known")
+        }
+        return fun, next - fun.Address, nil
+    }
+
+    return AssemblyFunction{}, 0, errors.New(name + " isn't a function of the executable")
+}
+
+// Relocations that still point to the right place wherever a position independent executable is loaded
+func isRelativeRelocation(kind uint32) bool {
+    switch elf.R_X86_64(kind) {
+    case elf.R_X86_64_PC32, elf.R_X86_64_PLT32, elf.R_X86_64_PC64:
+        return true
+    }
+
+    return false
+}
+
+// A replacement object linked at some address
+type linkedPatch struct {
+    // The code, then read-only data, then writable data
+    Content []byte
+    // Where Content starts, which can be a little after where it was linked to, for alignment
+    Address int
+    Entry int
+    Writable bool
+}
+
+// Links the replacement object at address, with its undefined symbols resolved against the executable's
+func linkPatch(object string, address int, function string, symbols map[string]int, pie bool) (linkedPatch, error) {
+    patch := linkedPatch{}
+
+    f, err := elf.Open(object)
+    if err != nil {
+        return patch, err
+    }
+    defer f.Close()
+    if f.Machine != elf.EM_X86_64 || f.Type != elf.ET_REL {
+        return patch, errors.New(object + " isn't an x86-64 object file")
+    }
+
+    objectSymbols, err := f.Symbols()
+    if err != nil {
+        return patch, err
+    }
+
+    // printf@GLIBC_2.2.5 is just printf here, since the PLT entry already has the version
+    renamed := []string{}
+    assignments := []string{}
+    for _, sym := range objectSymbols {
+        if sym.Section != elf.SHN_UNDEF || sym.Name == "" {
+            continue
+        }
+        name, _, versioned := strings.Cut(sym.Name, "@")
+        addr, ok := symbols[sym.Name]
+        if !ok {
+            addr, ok = symbols[name]
+        }
+        if !ok {
+            return patch, fmt.Errorf("%s needs %s, which the executable doesn't have", object, sym.Name)
+        }
+        if versioned {
+            renamed = append(renamed, "--redefine-sym", sym.Name + "=" + name)
+        }
+        assignments = append(assignments, fmt.Sprintf("\"%s\" = 0x%x;", name, addr))
+    }
+    sort.Strings(assignments)
+
+    for i, section := range f.Sections {
+        if section.Flags & elf.SHF_ALLOC == 0 {
+            continue
+        }
+        if section.Flags & elf.SHF_WRITE != 0 && section.Size > 0 {
+            patch.Writable = true
+        }
+        if !pie {
+            continue
+        }
+        for _, rel := range sectionRelocations(f, i) {
+            if !isRelativeRelocation(rel.kind) {
+                return patch, fmt.Errorf("%s has a %s in %s, which doesn't work in a position independent executable. Only rip-relative references do", object, elf.R_X86_64(rel.kind), section.Name)
+            }
+        }
+    }
+
+    dir, err := os.MkdirTemp("", "unld_patch_")
+    if err != nil {
+        return patch, err
+    }
+    defer os.RemoveAll(dir)
+
+    if len(renamed) > 0 {
+        copied := filepath.Join(dir, "replacement.o")
+        out, err := exec.Command("objcopy", append(renamed, object, copied)...).CombinedOutput()
+        if err != nil {
+            return patch, errors.New(string(out))
+        }
+        object = copied
+    }
+
+    script := fmt.Sprintf(`SECTIONS {
+    . = 0x%x;
+    .patch : {
+        *(.text .text.*)
+        *(.rodata .rodata.*)
+        *(.data .data.* .data.rel.ro .got .got.plt)
+        *(.bss .bss.* COMMON)
+    }
+    /DISCARD/ : { *(.note.GNU-stack) *(.note.gnu.property) *(.comment) *(.eh_frame) }
+}
+%s
+`, address, strings.Join(assignments, "\n"))
+    scriptFile := filepath.Join(dir, "patch.ld")
+    if err := os.WriteFile(scriptFile, []byte(script), 0644); err != nil {
+        return patch, err
+    }
+
+    linked := filepath.Join(dir, "patch")
+    out, err := exec.Command("ld", "-static", "-nostdlib", "-e", function, "-T", scriptFile, "-o", linked, object).CombinedOutput()
+    if err != nil {
+        return patch, errors.New(string(out))
+    }
+
+    l, err := elf.Open(linked)
+    if err != nil {
+        return patch, err
+    }
+    defer l.Close()
+
+    for _, section := range l.Sections {
+        if section.Flags & elf.SHF_ALLOC == 0 || section.Size == 0 {
+            continue
+        }
+        if section.Name != ".patch" {
+            return patch, fmt.Errorf("%s has %s, which can't be patched in", object, section.Name)
+        }
+        patch.Content, err = section.Data()
+        if err != nil {
+            return patch, err
+        }
+        patch.Address = int(section.Addr)
+    }
+
+    linkedSymbols, err := l.Symbols()
+    if err != nil {
+        return patch, err
+    }
+    for _, sym := range linkedSymbols {
+        if sym.Name == function && sym.Section != elf.SHN_UNDEF && sym.Section != elf.SHN_ABS {
+            patch.Entry = int(sym.Value)
+            return patch, nil
+        }
+    }
+
+    return patch, errors.New(object + " doesn't define " + function)
+}
+
+// Where addr is in the file
+func fileOffset(f *elf.File, addr int) (int, bool) {
+    for _, prog := range f.Progs {
+        if prog.Type == elf.PT_LOAD && uint64(addr) >= prog.Vaddr && uint64(addr) < prog.Vaddr + prog.Filesz {
+            return int(prog.Off + uint64(addr) - prog.Vaddr), true
+        }
+    }
+
+    return 0, false
+}
+
+// A jmp from one address to another, if it fits in room bytes
+func jumpCode(from int, to int, room int) ([]byte, bool) {
+    if distance := to - (from + 2); room >= 2 && distance >= -128 && distance < 128 {
+        return []byte{0xeb, byte(int8(distance))}, true
+    }
+    if room >= 5 {
+        code := []byte{0xe9, 0, 0, 0, 0}
+        binary.LittleEndian.PutUint32(code[1:], uint32(int32(to - (from + 5))))
+        return code, true
+    }
+
+    return nil, false
+}
+
+func alignUp(n int, alignment int) int {
+    return (n + alignment - 1) / alignment * alignment
+}
+
+// Writes the function from the replacement object over the executable's and saves the result to output.
+// It goes where the old one was when it fits, and otherwise into a new segment (taking the place of PT_NOTE),
+// with a jump to it where the old one starts. Only x86-64 ELF executables can be patched.
+func Patch(input string, function string, replacement string, output string) (PatchResult, error) {
+    result := PatchResult{}
+
+    f, err := elf.Open(input)
+    if err != nil {
+        return result, err
+    }
+    defer f.Close()
+    if f.Machine != elf.EM_X86_64 {
+        return result, errors.New("Only x86-64 ELF executables can be patched")
+    }
+    pie := f.Type == elf.ET_DYN
+
+    exe, err := LoadExecutable(input, LoadOptions{KeepRuntime: true})
+    if err != nil {
+        return result, err
+    }
+    fun, size, err := findPatchedFunction(exe.Object, function)
+    if err != nil {
+        return result, err
+    }
+    symbols, err := patchSymbols(input, exe)
+    if err != nil {
+        return result, err
+    }
+    result.Function, result.Address, result.Size = fun.Name, fun.Address, size
+
+    // The replacement can be called what it was called in the source, or what unld called it
+    name := fun.Name
+    if r, err := elf.Open(replacement); err == nil {
+        names := map[string]bool{}
+        if defined, err := r.Symbols(); err == nil {
+            for _, sym := range defined {
+                if sym.Section != elf.SHN_UNDEF {
+                    names[sym.Name] = true
+                }
+            }
+        }
+        r.Close()
+        if !names[name] && names[originalName(fun.Name, fun.Address)] {
+            name = originalName(fun.Name, fun.Address)
+        }
+    }
+
+    content, err := os.ReadFile(input)
+    if err != nil {
+        return result, err
+    }
+    start, ok := fileOffset(f, fun.Address)
+    if !ok {
+        return result, errors.New(fun.Name + " isn't in the file")
+    }
+
+    patch, err := linkPatch(replacement, fun.Address, name, symbols, pie)
+    if err != nil {
+        return result, err
+    }
+
+    // Alignment can leave a gap in front, and the entry doesn't have to be first, so there may need to be a jump to it
+    gap := patch.Address - fun.Address
+    jump, ok := []byte{}, true
+    if patch.Entry != fun.Address {
+        jump, ok = jumpCode(fun.Address, patch.Entry, gap)
+    }
+    if ok && gap + len(patch.Content) <= size && !patch.Writable {
+        // Whatever is left can't be reached anymore
+        for i := 0; i < size; i++ {
+            content[start+i] = 0xcc
+        }
+        copy(content[start:], jump)
+        copy(content[start+gap:], patch.Content)
+        result.Location, result.Length, result.InPlace = patch.Entry, len(patch.Content), true
+        return result, writeExecutable(input, output, content)
+    }
+
+    note := -1
+    end := 0
+    for i, prog := range f.Progs {
+        if prog.Type == elf.PT_NOTE {
+            note = i
+        }
+        if prog.Type == elf.PT_LOAD && int(prog.Vaddr + prog.Memsz) > end {
+            end = int(prog.Vaddr + prog.Memsz)
+        }
+    }
+    if note == -1 {
+        return result, errors.New(input + " has no PT_NOTE segment to put the new code in")
+    }
+
+    // Both page aligned, so the offset and address agree like mmap wants them to
+    address := alignUp(end, 0x1000)
+    offset := alignUp(len(content), 0x1000)
+    patch, err = linkPatch(replacement, address, name, symbols, pie)
+    if err != nil {
+        return result, err
+    }
+    jump, ok = jumpCode(fun.Address, patch.Entry, size)
+    if !ok {
+        return result, fmt.Errorf("%s is too small to jump from (%d bytes)", fun.Name, size)
+    }
+    // The segment starts at address, even if the code starts a little later
+    blob := append(make([]byte, patch.Address - address), patch.Content...)
+    content = append(content, make([]byte, offset - len(content))...)
+    content = append(content, blob...)
+
+    flags := elf.PF_R | elf.PF_X
+    if patch.Writable {
+        flags |= elf.PF_W
+    }
+    phoff := int(binary.LittleEndian.Uint64(content[0x20:]))
+    phentsize := int(binary.LittleEndian.Uint16(content[0x36:]))
+    header := content[phoff + note*phentsize:]
+    binary.LittleEndian.PutUint32(header[0:], uint32(elf.PT_LOAD))
+    binary.LittleEndian.PutUint32(header[4:], uint32(flags))
+    binary.LittleEndian.PutUint64(header[8:], uint64(offset))
+    binary.LittleEndian.PutUint64(header[16:], uint64(address))
+    binary.LittleEndian.PutUint64(header[24:], uint64(address))
+    binary.LittleEndian.PutUint64(header[32:], uint64(len(blob)))
+    binary.LittleEndian.PutUint64(header[40:], uint64(len(blob)))
+    binary.LittleEndian.PutUint64(header[48:], 0x1000)
+
+    copy(content[start:], jump)
+
+    result.Location, result.Length = patch.Entry, len(patch.Content)
+    return result, writeExecutable(input, output, content)
+}
+
+// Keeps the executable executable
+func writeExecutable(input string, output string, content []byte) error {
+    mode := os.FileMode(0755)
+    if info, err := os.Stat(input); err == nil {
+        mode = info.Mode().Perm()
+    }
+
+    return os.WriteFile(output, content, mode)
+}
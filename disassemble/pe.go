@@ -0,0 +1,206 @@
+package disassemble
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func readRVA(f *pe.File, rva uint32) ([]byte, error) {
+    for _, section := range f.Sections {
+        if rva < section.VirtualAddress || rva >= section.VirtualAddress+section.VirtualSize {
+            continue
+        }
+
+        data, err := section.Data()
+        if err != nil {
+            return nil, err
+        }
+
+        offset := rva - section.VirtualAddress
+        if int(offset) >= len(data) {
+            return nil, errors.New("RVA outside of section data")
+        }
+        return data[offset:], nil
+    }
+
+    return nil, fmt.Errorf("RVA 0x%x is not in any section", rva)
+}
+
+func readCString(data []byte) string {
+    if end := bytes.IndexByte(data, 0); end != -1 {
+        return string(data[:end])
+    }
+
+    return string(data)
+}
+
+type Imports struct {
+    // The import address table is the PE equivalent of the GOT. Each slot gets named __imp_<symbol>,
+    // which is also what import libraries call them.
+    Slots map[int]string
+    // DLLs that are imported from
+    Libraries []string
+    // Imported symbol -> the library it comes from
+    From map[string]string
+}
+
+func GetImports(file string) (Imports, error) {
+    imports := Imports{map[int]string{}, []string{}, map[string]string{}}
+
+    f, err := pe.Open(file)
+    if err != nil {
+        return imports, err
+    }
+    defer f.Close()
+
+    header, ok := f.OptionalHeader.(*pe.OptionalHeader64)
+    if !ok {
+        return imports, errors.New("Only PE32+ executables are supported")
+    }
+
+    if len(header.DataDirectory) <= pe.IMAGE_DIRECTORY_ENTRY_IMPORT {
+        return imports, nil
+    }
+    dir := header.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_IMPORT]
+    if dir.VirtualAddress == 0 {
+        return imports, nil
+    }
+
+    descriptors, err := readRVA(f, dir.VirtualAddress)
+    if err != nil {
+        return imports, err
+    }
+
+    for len(descriptors) >= 20 {
+        lookup := binary.LittleEndian.Uint32(descriptors[0:4])
+        name := binary.LittleEndian.Uint32(descriptors[12:16])
+        iat := binary.LittleEndian.Uint32(descriptors[16:20])
+        descriptors = descriptors[20:]
+
+        if lookup == 0 && iat == 0 {
+            break
+        }
+        if lookup == 0 {
+            lookup = iat
+        }
+
+        library, err := readRVA(f, name)
+        if err != nil {
+            return imports, err
+        }
+        dll := readCString(library)
+        imports.Libraries = append(imports.Libraries, dll)
+
+        thunks, err := readRVA(f, lookup)
+        if err != nil {
+            return imports, err
+        }
+
+        for i := 0; len(thunks) >= 8*(i+1); i++ {
+            thunk := binary.LittleEndian.Uint64(thunks[8*i:])
+            if thunk == 0 {
+                break
+            }
+
+            slot := int(header.ImageBase) + int(iat) + 8*i
+            if thunk & (1 << 63) != 0 {
+                ordinal := "ordinal_" + strconv.Itoa(int(thunk & 0xffff))
+                imports.Slots[slot] = "__imp_" + ordinal
+                imports.From[ordinal] = dll
+                imports.From["__imp_" + ordinal] = dll
+                continue
+            }
+
+            // Skip the 2 byte hint
+            hintName, err := readRVA(f, uint32(thunk) + 2)
+            if err != nil {
+                return imports, err
+            }
+            name := readCString(hintName)
+            imports.Slots[slot] = "__imp_" + name
+            imports.From[name] = dll
+            imports.From["__imp_" + name] = dll
+        }
+    }
+
+    return imports, nil
+}
+
+// objdump only names IAT slots when the executable still has its symbol table, so name them ourselves.
+// This must run before MonkeyPatchAssembly.
+func PatchImports(sections []Section, imports Imports) []Section {
+    return NameAddresses(sections, func(addr int) (string, bool) {
+        symbol, ok := imports.Slots[addr]
+        return symbol, ok
+    })
+}
+
+// The linker generates `printf: jmp [__imp_printf]` thunks for imported functions, which is the PE version of .plt.
+// This must run after MonkeyPatchAssembly.
+func FindImportThunks(sections []Section) []string {
+    symbols := []string{}
+
+    for _, section := range sections {
+        for _, fun := range section.Funcs {
+            if isImportThunk(fun) {
+                symbols = append(symbols, fun.Name)
+            }
+        }
+    }
+
+    return symbols
+}
+
+func isImportThunk(fun AssemblyFunction) bool {
+    return len(fun.Content) > 0 && strings.HasPrefix(fun.Content[0], "jmp") && strings.Contains(fun.Content[0], "[rel __imp_")
+}
+
+func RemoveImportThunks(sections []Section) []Section {
+    useful := make([]Section, 0, len(sections))
+
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            if isImportThunk(fun) {
+                continue
+            }
+
+            funcs = append(funcs, fun)
+        }
+
+        useful = append(useful, Section{section.Name, funcs})
+    }
+
+    return useful
+}
+
+func (imports Imports) Symbols() []string {
+    symbols := make([]string, 0, len(imports.Slots))
+
+    for _, symbol := range imports.Slots {
+        symbols = append(symbols, symbol)
+    }
+    sort.Strings(symbols)
+
+    return symbols
+}
+
+func (imports Imports) Attributed() []ImportedSymbol {
+    symbols := make([]ImportedSymbol, 0, len(imports.From))
+
+    for symbol, library := range imports.From {
+        symbols = append(symbols, ImportedSymbol{symbol, "", library})
+    }
+    sort.Slice(symbols, func(i, j int) bool {
+        return symbols[i].Name < symbols[j].Name
+    })
+
+    return symbols
+}
@@ -0,0 +1,166 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Read-only data that still needs relocating, like vtables and typeinfo
+func GetDumpedRelocatedData(file string) ([]Data, error) {
+    relocated, err := GetDumpedData(file, ".data.rel.ro")
+    if err != nil {
+        return nil, err
+    }
+
+    for i, r := range relocated {
+        r.Extern = true
+        relocated[i] = r
+    }
+
+    return relocated, nil
+}
+
+// Relocations the dynamic linker applies to data, which are the pointers in it
+var pointerRelocations = []string{"_RELATIVE", "_64", "_32", "_ABS64", "_ABS32"}
+
+func isPointerRelocation(kind string) bool {
+    for _, suffix := range pointerRelocations {
+        if strings.HasSuffix(kind, suffix) {
+            return true
+        }
+    }
+    return false
+}
+
+// Finds the pointers in the data, so they can be written out as symbols instead of whatever address they had.
+// Position independent executables have a dynamic relocation for each of them, anything else just has the address,
+// which resolve names like NameAddresses does.
+func FindPointers(file string, datas []Data, resolve func(int) (string, bool)) ([]Data, error) {
+    if len(datas) == 0 {
+        return datas, nil
+    }
+
+    f, err := elf.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    size := 8
+    if f.Class == elf.ELFCLASS32 {
+        size = 4
+    }
+    readPointer := func(data []byte) int {
+        if size == 8 {
+            return int(f.ByteOrder.Uint64(data))
+        }
+        return int(f.ByteOrder.Uint32(data))
+    }
+
+    // Address -> symbol, from the dynamic relocations
+    relocated := map[int]string{}
+    buf, err := exec.Command(objdumpCommand(file), "-R", file).CombinedOutput()
+    if err == nil {
+        for _, line := range strings.Split(string(buf), "\n") {
+            fields := strings.Fields(line)
+            if len(fields) != 3 || !isPointerRelocation(fields[1]) {
+                continue
+            }
+            addr, err := strconv.ParseUint(fields[0], 16, 64)
+            if err != nil {
+                continue
+            }
+
+            symbol, addend, _ := strings.Cut(fields[2], "+")
+            symbol, _, _ = strings.Cut(symbol, "@")
+            if symbol != "*ABS*" {
+                if offset, err := strconv.ParseUint(strings.TrimPrefix(addend, "0x"), 16, 64); err == nil && offset != 0 {
+                    symbol += "+0x" + strconv.FormatUint(offset, 16)
+                }
+                relocated[int(addr)] = symbol
+                continue
+            }
+
+            // RELA has the address in the relocation, REL in the data itself
+            target, err := strconv.ParseUint(strings.TrimPrefix(addend, "0x"), 16, 64)
+            if addend == "" || err != nil {
+                relocated[int(addr)] = ""
+                continue
+            }
+            if name, ok := resolve(int(target)); ok {
+                relocated[int(addr)] = name
+            }
+        }
+    }
+
+    loaded := func(addr int) bool {
+        for _, prog := range f.Progs {
+            if prog.Type == elf.PT_LOAD && addr >= int(prog.Vaddr) && addr < int(prog.Vaddr+prog.Memsz) {
+                return true
+            }
+        }
+        return false
+    }
+
+    output := make([]Data, 0, len(datas))
+    for _, data := range datas {
+        data.Pointers = map[int]string{}
+        for offset := (size - data.Location%size) % size; offset+size <= len(data.Data); offset += size {
+            name, ok := relocated[data.Location + offset]
+            if ok && name == "" {
+                name, ok = resolve(readPointer(data.Data[offset:]))
+            } else if !ok && f.Type == elf.ET_EXEC {
+                // Not position independent, so any address is a pointer
+                if value := readPointer(data.Data[offset:]); loaded(value) {
+                    name, ok = resolve(value)
+                }
+            }
+            if ok {
+                data.Pointers[offset] = name
+            }
+        }
+        output = append(output, data)
+    }
+
+    return output, nil
+}
+
+// Names for the pointers FindPointers finds: functions by their symbol, or the data they point into
+func pointerNames(file string, sections []Section, datas ...[]Data) func(int) (string, bool) {
+    functions := map[int]string{}
+    // The names functions ended up with, which can differ from the symbol table's for duplicates
+    for _, section := range sections {
+        for _, fun := range section.Funcs {
+            functions[fun.Address] = fun.Name
+        }
+    }
+    if f, err := elf.Open(file); err == nil {
+        symbols, _ := f.Symbols()
+        for _, sym := range symbols {
+            if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Section == elf.SHN_UNDEF {
+                continue
+            }
+            // Aliases, like the two constructors C++ has, only have the name objdump used defined
+            if _, ok := functions[int(sym.Value)]; ok {
+                continue
+            }
+            functions[int(sym.Value)] = sym.Name
+        }
+        f.Close()
+    }
+
+    return func(addr int) (string, bool) {
+        if name, ok := functions[addr]; ok {
+            return name, true
+        }
+        return FindDataAt(addr, datas...)
+    }
+}
+
+// The name a pointer points at, without the offset
+func pointerTarget(pointer string) string {
+    name, _, _ := strings.Cut(pointer, "+")
+    return name
+}
@@ -0,0 +1,162 @@
+package disassemble
+
+import (
+	"strings"
+)
+
+// Instruction prefixes, which come before the mnemonic and can't be symbols either
+var instructionPrefixes = map[string]bool{
+    "lock": true, "rep": true, "repe": true, "repz": true, "repne": true, "repnz": true, "notrack": true, "bnd": true,
+}
+
+// Renames the symbols in the operands of an instruction, as whole words only, so renaming add leaves add rax,1 alone
+func renameInLine(line string, names map[string]string) string {
+    return renameOperands(line, names, false)
+}
+
+// renameInLine, but with offsetsOnly only where there's an offset after the symbol, like in jmp foo+0x12,
+// or a label in it, like jmp foo.at_12
+func renameOperands(line string, names map[string]string, offsetsOnly bool) string {
+    // The mnemonic could be named like a function
+    start := 0
+    for {
+        end := strings.IndexByte(line[start:], ' ')
+        if end == -1 {
+            return line
+        }
+        word := line[start:start+end]
+        start += end + 1
+        if !instructionPrefixes[word] {
+            break
+        }
+    }
+
+    var output strings.Builder
+    output.WriteString(line[:start])
+    for i := start; i < len(line); {
+        if !isSymbolChar(line[i]) {
+            output.WriteByte(line[i])
+            i++
+            continue
+        }
+
+        end := i
+        for end < len(line) && isSymbolChar(line[end]) {
+            end++
+        }
+        word, reloc := splitRelocation(line[i:end])
+        if renamed, ok := names[word]; ok && (!offsetsOnly || (end < len(line) && line[end] == '+')) {
+            word = renamed
+        } else if symbol, label := splitBranchLabel(word); label != "" {
+            // A label in the middle of the function, which goes with it
+            if renamed, ok := names[symbol]; ok {
+                word = renamed + label
+            }
+        }
+        output.WriteString(word + reloc)
+        i = end
+    }
+
+    return output.String()
+}
+
+func renameData(datas []Data, names map[string]string) []Data {
+    output := make([]Data, 0, len(datas))
+
+    for _, data := range datas {
+        if renamed, ok := names[data.Name]; ok {
+            data.Name = renamed
+        }
+        if data.Pointers != nil {
+            pointers := make(map[int]string, len(data.Pointers))
+            for offset, pointer := range data.Pointers {
+                name, addend := splitSymbolOffset(pointer)
+                if renamed, ok := names[name]; ok {
+                    name = renamed
+                }
+                pointers[offset] = name + addend
+            }
+            data.Pointers = pointers
+        }
+        output = append(output, data)
+    }
+
+    return output
+}
+
+// Renames the functions in names, and the symbols in names their code uses, after MonkeyPatchAssembly
+func RenamePatchedFunctions(funcs []AssemblyFunction, names map[string]string) []AssemblyFunction {
+    output := make([]AssemblyFunction, 0, len(funcs))
+
+    for _, fun := range funcs {
+        code := make([]string, 0, len(fun.Content))
+        for _, line := range fun.Content {
+            code = append(code, renameInLine(line, names))
+        }
+
+        name := fun.Name
+        if renamed, ok := names[name]; ok {
+            name = renamed
+        }
+        output = append(output, AssemblyFunction{name, fun.Address, code, fun.Info})
+    }
+
+    return output
+}
+
+// Renames the symbols in names everywhere in the object: the functions, the data and literals,
+// every reference to them in the code, the pointers in the data, and the constructors.
+// Symbols the object only uses need RenameSymbols too.
+func (o Object) Rename(names map[string]string) Object {
+    sections := make([]Section, 0, len(o.Sections))
+
+    for _, section := range o.Sections {
+        sections = append(sections, Section{section.Name, RenamePatchedFunctions(section.Funcs, names)})
+    }
+
+    o.Sections = sections
+    o.Globals = renameData(o.Globals, names)
+    o.Variables = renameData(o.Variables, names)
+    o.ThreadLocals = renameData(o.ThreadLocals, names)
+    o.Literals = renameData(o.Literals, names)
+    o.Relocated = renameData(o.Relocated, names)
+    o.InitArray = RenameSymbols(o.InitArray, names)
+    o.FiniArray = RenameSymbols(o.FiniArray, names)
+    return o
+}
+
+func RenameSymbols(symbols []string, names map[string]string) []string {
+    output := make([]string, 0, len(symbols))
+
+    for _, symbol := range symbols {
+        if renamed, ok := names[symbol]; ok {
+            symbol = renamed
+        }
+        output = append(output, symbol)
+    }
+
+    return output
+}
+
+// prefix in front of everything the executable defines, for Rename. Imports keep their names, since a library defines those.
+// This should be the executable's whole object, so the objects split from it still agree on the names.
+func (o Object) PrefixNames(prefix string) map[string]string {
+    names := map[string]string{}
+
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            names[fun.Name] = prefix + fun.Name
+        }
+    }
+    for _, datas := range [][]Data{o.Globals, o.Variables, o.ThreadLocals, o.Literals, o.Relocated} {
+        for _, data := range datas {
+            // Copy relocated library data, like stderr@GLIBC_2.2.5
+            if strings.Contains(data.Name, "@") {
+                continue
+            }
+            names[data.Name] = prefix + data.Name
+        }
+    }
+
+    return names
+}
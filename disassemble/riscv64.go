@@ -0,0 +1,193 @@
+package disassemble
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// "-1024(a0)" has a0 as the base register
+func memoryBase(operand string) (string, bool) {
+    if !strings.HasSuffix(operand, ")") || !strings.Contains(operand, "(") {
+        return "", false
+    }
+
+    return operand[strings.Index(operand, "(")+1:len(operand)-1], true
+}
+
+func isStoreRV(mnemonic string) bool {
+    switch mnemonic {
+    case "sb", "sh", "sw", "sd", "fsw", "fsd":
+        return true
+    }
+
+    return false
+}
+
+// "jalr 16(ra)" after "auipc ra" is a call, and "jr 24(t1)" after "auipc t1" a tail call
+func pltJump(mnemonic string, operands []string, base string) (string, bool) {
+    switch {
+    case mnemonic == "jalr" && base == "ra" && (len(operands) == 1 || operands[0] == "ra"):
+        return "call", true
+    case mnemonic == "jr" || (mnemonic == "jalr" && len(operands) == 2 && operands[0] == "zero"):
+        return "tail", true
+    }
+
+    return "", false
+}
+
+// RISC-V addresses anything further than 2KiB away with an auipc followed by an addi, load, store or jalr using the same register.
+// objdump puts the resolved address of the pair in a comment on the second instruction.
+// The pair is turned back into %pcrel_hi/%pcrel_lo (or %got_pcrel_hi for GOT slots), which need a label on the auipc.
+// Compressed instructions are dumped as their full forms, and the assembler compresses them again, which is why
+// branches go to labels rather than to an offset into a function.
+// Calls and tail calls through PLT entries go back to call and tail, since a %pcrel_hi of an import doesn't link.
+// An auipc that can't be paired is left as it is, for checkPageAddresses to refuse.
+func PatchAssemblyRV64(sections []Section, globals []Data, literals []Data, info GotInfo, symbols []string) []Section {
+    output := make([]Section, 0, len(sections))
+    starts := newFunctionStarts(sections)
+
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            code := make([]string, len(fun.Content))
+            // Register -> line of the auipc that last wrote it
+            auipcs := map[string]int{}
+            // Line of an auipc -> its label and relocation, once something uses it
+            paired := map[int][2]string{}
+            labels := 0
+
+            for i, line := range fun.Content {
+                if label, ok := gasLabelDefinition(line, fun); ok {
+                    code[i] = label
+                    continue
+                }
+
+                target := -1
+                targetName := ""
+                if comment := strings.Index(line, " # "); comment != -1 {
+                    hex, name, _ := strings.Cut(strings.TrimSpace(line[comment+3:]), " ")
+                    if addr, err := strconv.ParseUint(strings.TrimPrefix(hex, "0x"), 16, 64); err == nil {
+                        target = int(addr)
+                        targetName = symbolizeTarget(hex + " " + name)
+                    }
+                    line = strings.TrimSpace(line[:comment])
+                }
+
+                mnemonic, operands := splitOperands(line)
+
+                if mnemonic == "auipc" && len(operands) == 2 {
+                    auipcs[operands[0]] = i
+                    code[i] = line
+                    continue
+                }
+
+                base := ""
+                if len(operands) >= 1 {
+                    if reg, ok := memoryBase(operands[len(operands)-1]); ok {
+                        base = reg
+                    } else if mnemonic == "addi" && len(operands) == 3 {
+                        base = operands[1]
+                    }
+                }
+
+                if hi, ok := auipcs[base]; ok && target != -1 {
+                    // A call through a PLT entry needs one again, which only call and tail make
+                    if jump, ok := pltJump(mnemonic, operands, base); ok && slices.Contains(symbols, targetName) {
+                        if _, shared := paired[hi]; !shared {
+                            code[hi] = ""
+                            code[i] = jump + " " + targetName
+                            delete(auipcs, base)
+                            continue
+                        }
+                    }
+
+                    reloc := ""
+                    if symbol, ok := info.GotSymbols[target]; ok {
+                        reloc = "%got_pcrel_hi(" + symbol + ")"
+                    } else if symbol, ok := FindDataAt(target, globals, literals); ok {
+                        reloc = "%pcrel_hi(" + symbol + ")"
+                    } else if targetName != "" {
+                        reloc = "%pcrel_hi(" + targetName + ")"
+                    }
+
+                    if reloc != "" {
+                        // Every use of an auipc shares its label, so they all need the same symbol
+                        pair, ok := paired[hi]
+                        if !ok {
+                            pair = [2]string{fmt.Sprintf(".Lpcrel_%s_%d", fun.Name, labels), reloc}
+                            labels++
+                            paired[hi] = pair
+                            code[hi] = fmt.Sprintf("%s: auipc %s, %s", pair[0], base, reloc)
+                        } else if pair[1] != reloc {
+                            // Its label can only have one symbol, so it goes back to how it was for checkPageAddresses
+                            code[hi] = strings.TrimSpace(fun.Content[hi])
+                        }
+
+                        lo := "%pcrel_lo(" + pair[0] + ")"
+                        if mnemonic == "addi" {
+                            operands[2] = lo
+                        } else {
+                            operands[len(operands)-1] = lo + "(" + base + ")"
+                        }
+                        code[i] = mnemonic + " " + strings.Join(operands, ", ")
+                        if !isStoreRV(mnemonic) {
+                            delete(auipcs, operands[0])
+                        }
+                        if strings.HasPrefix(mnemonic, "j") {
+                            delete(auipcs, base)
+                        }
+                        continue
+                    }
+                }
+
+                // Anything else clobbering the register ends the pair
+                if len(operands) > 0 && !isStoreRV(mnemonic) && !strings.HasPrefix(mnemonic, "b") {
+                    delete(auipcs, operands[0])
+                }
+
+                for j, operand := range operands {
+                    if label, ok := gasBranchTarget(operand, starts); ok && isArchBranch(ArchRISCV64, mnemonic) {
+                        operands[j] = label
+                        continue
+                    }
+                    operands[j] = symbolizeTarget(operand)
+                }
+
+                if mnemonic == "jal" {
+                    callee := operands[len(operands)-1]
+                    for _, symbol := range symbols {
+                        if symbol == callee && (len(operands) == 1 || operands[0] == "ra") {
+                            // jal only reaches 1MiB, which a shared library won't be in
+                            mnemonic = "call"
+                            operands = []string{callee}
+                            break
+                        }
+                    }
+                }
+
+                if len(operands) > 0 {
+                    code[i] = mnemonic + " " + strings.Join(operands, ", ")
+                } else {
+                    code[i] = line
+                }
+            }
+
+            // Without the auipcs that went into a call or tail
+            content := make([]string, 0, len(code))
+            for _, line := range code {
+                if line != "" {
+                    content = append(content, line)
+                }
+            }
+
+            funcs = append(funcs, AssemblyFunction{fun.Name, fun.Address, content, fun.Info})
+        }
+
+        output = append(output, Section{section.Name, funcs})
+    }
+
+    return output
+}
@@ -0,0 +1,390 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The parts of the C runtime (libc, libgcc and the crt objects) that static executables have their own copy of
+type Runtime struct {
+    // Archives and objects the symbols were read from
+    Files []string
+    // Global symbols, which a normal libc provides when relinking
+    Exported map[string]bool
+    // Static functions, which only the runtime itself calls
+    Internal map[string]bool
+    // The other functions in the same section of the object an exported function is from, by how far from it they are
+    Siblings map[string]map[string]int
+}
+
+var runtimeFiles = []string{
+    "libc.a", "libm.a", "libpthread.a", "libdl.a", "librt.a", "libresolv.a",
+    "libgcc.a", "libgcc_eh.a",
+    "crt1.o", "rcrt1.o", "crti.o", "crtn.o", "crtbeginT.o", "crtbeginS.o", "crtend.o", "crtendS.o",
+}
+
+// Static executables don't need any libraries, though static PIE ones still have a dynamic section
+func HasStaticRuntime(file string) (bool, error) {
+    f, err := elf.Open(file)
+    if err != nil {
+        return false, err
+    }
+    defer f.Close()
+
+    needed, _ := f.DynString(elf.DT_NEEDED)
+    return len(needed) == 0, nil
+}
+
+// Reads the symbols of the runtime the executable was most likely linked with, which is whatever the
+// (sysroot's) toolchain has for the same architecture
+func LoadRuntime(file string, sysroot string) (Runtime, error) {
+    runtime := Runtime{[]string{}, map[string]bool{}, map[string]bool{}, map[string]map[string]int{}}
+
+    f, err := elf.Open(file)
+    if err != nil {
+        return runtime, err
+    }
+    defer f.Close()
+
+    dirs := defaultLibraryPaths(f, sysroot)
+    // libgcc and the crtbegin objects live with the compiler
+    for _, pattern := range []string{"/usr/lib/gcc/*/*", "/usr/lib/gcc-cross/*/*"} {
+        matches, _ := filepath.Glob(filepath.Join(sysroot, pattern))
+        dirs = append(dirs, matches...)
+    }
+
+    for _, name := range runtimeFiles {
+        for _, dir := range dirs {
+            path := rerootPath(sysroot, filepath.Join(dir, name))
+            members, err := ReadArchive(path)
+            if err != nil {
+                continue
+            }
+            if !runtime.addMembers(members, f) {
+                // Another architecture
+                continue
+            }
+
+            runtime.Files = append(runtime.Files, path)
+            break
+        }
+    }
+
+    return runtime, nil
+}
+
+// Returns false if the objects are for a different architecture than target
+func (r Runtime) addMembers(members []ArchiveMember, target *elf.File) bool {
+    for _, member := range members {
+        obj, err := member.ELF()
+        if err != nil {
+            continue
+        }
+        if obj.Class != target.Class || obj.Machine != target.Machine {
+            return false
+        }
+
+        symbols, _ := obj.Symbols()
+        r.addSiblings(symbols)
+        for _, sym := range symbols {
+            if sym.Section == elf.SHN_UNDEF || sym.Name == "" {
+                continue
+            }
+
+            // STT_LOOS is STT_GNU_IFUNC
+            kind := elf.ST_TYPE(sym.Info)
+            switch elf.ST_BIND(sym.Info) {
+            case elf.STB_GLOBAL, elf.STB_WEAK:
+                if kind == elf.STT_FUNC || kind == elf.STT_LOOS || kind == elf.STT_OBJECT || kind == elf.STT_TLS {
+                    r.Exported[sym.Name] = true
+                }
+            case elf.STB_LOCAL:
+                if kind == elf.STT_FUNC {
+                    r.Internal[sym.Name] = true
+                }
+            }
+        }
+    }
+
+    return true
+}
+
+func isFunctionSymbol(sym elf.Symbol) bool {
+    // STT_LOOS is STT_GNU_IFUNC
+    kind := elf.ST_TYPE(sym.Info)
+    return sym.Section != elf.SHN_UNDEF && sym.Name != "" && (kind == elf.STT_FUNC || kind == elf.STT_LOOS)
+}
+
+func (r Runtime) addSiblings(symbols []elf.Symbol) {
+    for _, sym := range symbols {
+        bind := elf.ST_BIND(sym.Info)
+        if !isFunctionSymbol(sym) || bind == elf.STB_LOCAL {
+            continue
+        }
+        if _, ok := r.Siblings[sym.Name]; ok {
+            continue
+        }
+
+        siblings := map[string]int{}
+        for _, other := range symbols {
+            if isFunctionSymbol(other) && other.Section == sym.Section && other.Name != sym.Name {
+                siblings[other.Name] = int(other.Value) - int(sym.Value)
+            }
+        }
+        r.Siblings[sym.Name] = siblings
+    }
+}
+
+// Exported runtime functions the executable has its own definition of, going by whether the functions the
+// runtime defines next to them are where they would be. A program's own error() doesn't have error_at_line
+// right after it. Functions that are alone in their object can't be told apart, and count as the runtime's.
+func (r Runtime) UserDefinitions(file string) (map[string]bool, error) {
+    user := map[string]bool{}
+
+    f, err := elf.Open(file)
+    if err != nil {
+        return user, err
+    }
+    defer f.Close()
+
+    symbols, err := f.Symbols()
+    if err == elf.ErrNoSymbols {
+        // Stripped, so the names come from signatures, which matched the runtime's code
+        return user, nil
+    }
+    if err != nil {
+        return user, err
+    }
+
+    addresses := map[string][]int{}
+    for _, sym := range symbols {
+        if isFunctionSymbol(sym) {
+            addresses[sym.Name] = append(addresses[sym.Name], int(sym.Value))
+        }
+    }
+
+    for _, sym := range symbols {
+        siblings := r.Siblings[sym.Name]
+        if !isFunctionSymbol(sym) || elf.ST_BIND(sym.Info) == elf.STB_LOCAL || len(siblings) == 0 {
+            continue
+        }
+
+        found := false
+        siblingLoop: for name, offset := range siblings {
+            for _, addr := range addresses[name] {
+                if addr == int(sym.Value) + offset {
+                    found = true
+                    break siblingLoop
+                }
+            }
+        }
+        if !found {
+            user[sym.Name] = true
+        }
+    }
+
+    return user, nil
+}
+
+// GCC splits functions into name.cold, name.part.0 and so on, which belong to wherever name does.
+// The archives have some of those too.
+// user has the functions the executable defines itself, which are never the runtime's.
+func (r Runtime) classify(name string, user map[string]bool) (exported bool, internal bool) {
+    base := name
+    if i := strings.Index(name, "."); i > 0 {
+        base = name[:i]
+    }
+    if user[name] || user[base] {
+        return
+    }
+
+    exported = r.Exported[name] || r.Exported[base]
+    internal = r.Internal[name] || r.Internal[base]
+    return
+}
+
+// Picks the name a normal libc exports out of all the aliases at an address, like puts over _IO_puts
+func preferredAlias(names []string) string {
+    best := names[0]
+    for _, name := range names[1:] {
+        underscores := len(name) - len(strings.TrimLeft(name, "_"))
+        bestUnderscores := len(best) - len(strings.TrimLeft(best, "_"))
+        if underscores < bestUnderscores || (underscores == bestUnderscores && name < best) {
+            best = name
+        }
+    }
+
+    return best
+}
+
+// GOT slot -> IFUNC resolver, from the IRELATIVE relocations static executables have instead of a dynamic linker.
+// The resolver has the same symbol as the function.
+func ifuncSlots(f *elf.File) map[int]int {
+    slots := map[int]int{}
+    if f.Machine != elf.EM_X86_64 {
+        return slots
+    }
+
+    for _, section := range f.Sections {
+        if section.Type != elf.SHT_RELA {
+            continue
+        }
+        relocations, err := section.Data()
+        if err != nil {
+            continue
+        }
+        for i := 0; i+24 <= len(relocations); i += 24 {
+            info := binary.LittleEndian.Uint64(relocations[i+8:])
+            if elf.R_X86_64(elf.R_TYPE64(info)) != elf.R_X86_64_IRELATIVE {
+                continue
+            }
+            slots[int(binary.LittleEndian.Uint64(relocations[i:]))] = int(binary.LittleEndian.Uint64(relocations[i+16:]))
+        }
+    }
+
+    return slots
+}
+
+// Names for the addresses of every runtime function and variable in the executable.
+// Static executables call IFUNCs (memcpy and friends) through their own little PLT, so those entries get named too.
+// known has names for addresses that aren't in the symbol table, like the ones signatures found.
+func (r Runtime) NameAddresses(file string, known map[int]string) (map[int]string, error) {
+    names := map[int]string{}
+    for addr, name := range known {
+        names[addr] = name
+    }
+
+    f, err := elf.Open(file)
+    if err != nil {
+        return names, err
+    }
+    defer f.Close()
+
+    symbols, err := f.Symbols()
+    if err != nil && err != elf.ErrNoSymbols {
+        return names, err
+    }
+
+    aliases := map[int][]string{}
+    for _, sym := range symbols {
+        if sym.Section == elf.SHN_UNDEF || !r.Exported[sym.Name] {
+            continue
+        }
+        aliases[int(sym.Value)] = append(aliases[int(sym.Value)], sym.Name)
+    }
+    for addr, alias := range aliases {
+        names[addr] = preferredAlias(alias)
+    }
+
+    slots := ifuncSlots(f)
+    if len(slots) == 0 {
+        return names, nil
+    }
+
+    for _, section := range []string{".plt", ".iplt", ".plt.sec"} {
+        plt := f.Section(section)
+        if plt == nil {
+            continue
+        }
+        code, err := plt.Data()
+        if err != nil {
+            continue
+        }
+
+        // Every entry is a jmp [rip+disp32], maybe with endbr64 and bnd in front of it
+        for i := 0; i+6 <= len(code); i++ {
+            if code[i] != 0xff || code[i+1] != 0x25 {
+                continue
+            }
+            next := int(plt.Addr) + i + 6
+            slot := next + int(int32(binary.LittleEndian.Uint32(code[i+2:])))
+            resolver, ok := slots[slot]
+            if !ok {
+                continue
+            }
+            name, ok := names[resolver]
+            if !ok {
+                continue
+            }
+
+            start := i
+            if start > 0 && code[start-1] == 0xf2 {
+                start--
+            }
+            if start >= 4 && code[start-4] == 0xf3 && code[start-3] == 0x0f && code[start-2] == 0x1e && code[start-1] == 0xfa {
+                start -= 4
+            }
+            names[int(plt.Addr) + start] = name
+        }
+    }
+
+    return names, nil
+}
+
+// Takes the runtime functions out, and returns the exported ones the rest of the code still calls, which become externs.
+// Static functions with a runtime name are kept if anything else calls them, since then they must be the user's.
+// user has the exported names the executable defines itself, from UserDefinitions.
+// This must run after the addresses are named and before MonkeyPatchAssembly.
+func (r Runtime) Separate(sections []Section, user map[string]bool) ([]Section, []string) {
+    referenced := map[string]bool{}
+    for _, section := range sections {
+        for _, fun := range section.Funcs {
+            if exported, internal := r.classify(fun.Name, user); exported || internal {
+                continue
+            }
+
+            for _, line := range fun.Content {
+                start := strings.LastIndex(line, "<")
+                end := strings.LastIndex(line, ">")
+                if start == -1 || end < start {
+                    continue
+                }
+                target, _, _ := strings.Cut(line[start+1:end], "+")
+                referenced[target] = true
+            }
+        }
+    }
+
+    kept := make([]Section, 0, len(sections))
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            if exported, internal := r.classify(fun.Name, user); exported || (internal && !referenced[fun.Name]) {
+                continue
+            }
+
+            funcs = append(funcs, fun)
+        }
+
+        kept = append(kept, Section{section.Name, funcs})
+    }
+
+    externs := []string{}
+    for name := range referenced {
+        if r.Exported[name] && !user[name] {
+            externs = append(externs, name)
+        }
+    }
+    sort.Strings(externs)
+
+    return kept, externs
+}
+
+// Takes the runtime's variables out, the code that is left refers to them as externs
+func (r Runtime) SeparateData(datas []Data) []Data {
+    kept := make([]Data, 0, len(datas))
+
+    for _, data := range datas {
+        if r.Exported[data.Name] {
+            continue
+        }
+
+        kept = append(kept, data)
+    }
+
+    return kept
+}
@@ -0,0 +1,190 @@
+package disassemble
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Shell-style matching with * and ?, which is all symbol names need. * matches :: too, so net::* is all of net
+func globMatch(pattern string, name string) bool {
+    if pattern == "" {
+        return name == ""
+    }
+
+    switch pattern[0] {
+    case '*':
+        for i := 0; i <= len(name); i++ {
+            if globMatch(pattern[1:], name[i:]) {
+                return true
+            }
+        }
+        return false
+    case '?':
+        return name != "" && globMatch(pattern[1:], name[1:])
+    }
+
+    return name != "" && name[0] == pattern[0] && globMatch(pattern[1:], name[1:])
+}
+
+func isGlob(pattern string) bool {
+    return strings.ContainsAny(pattern, "*?")
+}
+
+// A symbol matches by its own name, its demangled name with or without the parameters, or a glob of any of them
+func matchesSymbol(pattern string, name string) bool {
+    if name == pattern {
+        return true
+    }
+
+    encoding, _ := demangle(name)
+    if encoding.qualified == pattern || encoding.full == pattern || withoutTemplateArgs(encoding.qualified) == pattern {
+        return true
+    }
+
+    if isGlob(pattern) {
+        return globMatch(pattern, name) || globMatch(pattern, encoding.qualified) || globMatch(pattern, encoding.full)
+    }
+
+    return false
+}
+
+// net::twice<int> -> net::twice, so a template selects all of its instances
+func withoutTemplateArgs(name string) string {
+    if !strings.HasSuffix(name, ">") {
+        return name
+    }
+
+    depth := 0
+    for i := len(name) - 1; i >= 0; i-- {
+        switch name[i] {
+        case '>':
+            depth++
+        case '<':
+            depth--
+            if depth == 0 {
+                return name[:i]
+            }
+        }
+    }
+
+    return name
+}
+
+// The class or namespace something is in, like net::Socket for net::Socket::send(char const*, int).
+// Thunks are in the class of the function they call.
+func cxxScope(name string) string {
+    encoding, _ := demangle(name)
+    return encoding.scope
+}
+
+// "0x1130-0x1200" -> 0x1130, 0x1200, or just "0x1139" for the function there
+func parseAddressRange(pattern string) (int, int, bool) {
+    start, end, isRange := strings.Cut(pattern, "-")
+    if !strings.HasPrefix(start, "0x") || isRange && !strings.HasPrefix(end, "0x") {
+        return 0, 0, false
+    }
+
+    from, err := strconv.ParseUint(start[2:], 16, 64)
+    if err != nil {
+        return 0, 0, false
+    }
+    if !isRange {
+        return int(from), int(from)+1, true
+    }
+    to, err := strconv.ParseUint(end[2:], 16, 64)
+    if err != nil {
+        return 0, 0, false
+    }
+
+    return int(from), int(to), true
+}
+
+func (o Object) matchFunctions(section string, match func(AssemblyFunction) bool) []string {
+    matched := []string{}
+
+    for _, sec := range o.Sections {
+        if sec.Name != section {
+            continue
+        }
+        for _, fun := range sec.Funcs {
+            if match(fun) {
+                matched = append(matched, fun.Name)
+            }
+        }
+    }
+
+    return matched
+}
+
+// Every function in the section the pattern selects, by their mangled names.
+// Patterns can be globs, address ranges like 0x1130-0x1200 (the end isn't included) for functions starting in them,
+// or name@address for one of the functions that share a name.
+// C++ functions can also be given demangled (all overloads), as a glob like net::Socket::*, or by their class
+func (o Object) MatchFunctions(pattern string, section string) []string {
+    if start, end, ok := parseAddressRange(pattern); ok {
+        return o.matchFunctions(section, func(fun AssemblyFunction) bool {
+            return fun.Address >= start && fun.Address < end
+        })
+    }
+
+    return o.matchFunctions(section, func(fun AssemblyFunction) bool {
+        if matchesAddressSelector(pattern, fun.Name, fun.Address) {
+            return true
+        }
+        // Duplicates match by the name they had too, so -a helper takes all of them
+        name := originalName(fun.Name, fun.Address)
+        return matchesSymbol(pattern, fun.Name) || matchesSymbol(pattern, name) || cxxScope(name) == pattern
+    })
+}
+
+// Same as MatchFunctions, with a regular expression for the name, mangled or not
+func (o Object) MatchFunctionsRegex(pattern *regexp.Regexp, section string) []string {
+    return o.matchFunctions(section, func(fun AssemblyFunction) bool {
+        if pattern.MatchString(fun.Name) {
+            return true
+        }
+        encoding, ok := demangle(fun.Name)
+        return ok && (pattern.MatchString(encoding.qualified) || pattern.MatchString(encoding.full))
+    })
+}
+
+var cxxClassData = []string{"vtable for ", "VTT for ", "typeinfo for ", "typeinfo name for "}
+
+// The vtables and typeinfo of the classes the pattern names, which go along with their functions
+func (o Object) MatchClassData(pattern string) []string {
+    matched := []string{}
+
+    for _, datas := range [][]Data{o.Relocated, o.Variables, o.Literals} {
+        for _, data := range datas {
+            if !strings.HasPrefix(data.Name, "_Z") {
+                continue
+            }
+            demangled := Demangle(data.Name)
+            for _, prefix := range cxxClassData {
+                class, ok := strings.CutPrefix(demangled, prefix)
+                if ok && (class == pattern || isGlob(pattern) && globMatch(pattern, class)) {
+                    matched = append(matched, data.Name)
+                }
+            }
+        }
+    }
+
+    return matched
+}
+
+// Every global, variable or thread-local the pattern selects, by name, demangled name or glob
+func (o Object) MatchData(pattern string) []string {
+    matched := []string{}
+
+    for _, datas := range [][]Data{o.Globals, o.Variables, o.ThreadLocals, o.Relocated} {
+        for _, data := range datas {
+            name := originalName(data.Name, data.Location)
+            if matchesSymbol(pattern, data.Name) || matchesSymbol(pattern, name) || matchesAddressSelector(pattern, data.Name, data.Location) {
+                matched = append(matched, data.Name)
+            }
+        }
+    }
+
+    return matched
+}
@@ -0,0 +1,618 @@
+package disassemble
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A library function recognized by its code, like IDA's FLIRT does.
+// Bytes the linker fills in (anything with a relocation) are wildcards.
+type Signature struct {
+    Name string
+    Pattern []byte
+    // false where Pattern is a wildcard
+    Mask []bool
+    // What it calls, which tells apart the functions with the same code, like printf and scanf
+    References []Reference
+}
+
+// A call to (or, on x86, a RIP-relative use of) Name, whose 32-bit displacement is at Offset
+type Reference struct {
+    Offset int
+    Name string
+}
+
+// Signatures shorter than this match all kinds of unrelated code
+const minimumSignatureBytes = 12
+
+func (s Signature) fixedBytes() int {
+    count := 0
+    for _, fixed := range s.Mask {
+        if fixed {
+            count++
+        }
+    }
+
+    return count
+}
+
+func (s Signature) Matches(code []byte) bool {
+    if len(code) < len(s.Pattern) {
+        return false
+    }
+
+    for i, b := range s.Pattern {
+        if s.Mask[i] && code[i] != b {
+            return false
+        }
+    }
+
+    return true
+}
+
+// "55 48 89 e5 e8 .. .. .. .." style, without the spaces
+func (s Signature) String() string {
+    var pattern strings.Builder
+    for i, b := range s.Pattern {
+        if s.Mask[i] {
+            pattern.WriteString(hex.EncodeToString([]byte{b}))
+        } else {
+            pattern.WriteString("..")
+        }
+    }
+
+    pattern.WriteString(" " + s.Name)
+    for _, ref := range s.References {
+        fmt.Fprintf(&pattern, " %x:%s", ref.Offset, ref.Name)
+    }
+
+    return pattern.String()
+}
+
+// Which bytes of the section a relocation changes, relative to its offset.
+// Some of them let the linker rewrite the instruction in front too (mov from the GOT into lea, for example).
+func relocatedBytes(machine elf.Machine, kind uint32) (int, int) {
+    switch machine {
+    case elf.EM_X86_64:
+        switch elf.R_X86_64(kind) {
+        case elf.R_X86_64_64, elf.R_X86_64_PC64, elf.R_X86_64_GOTOFF64, elf.R_X86_64_GOTPC64, elf.R_X86_64_GOT64, elf.R_X86_64_GOTPCREL64, elf.R_X86_64_PLTOFF64, elf.R_X86_64_SIZE64, elf.R_X86_64_DTPOFF64, elf.R_X86_64_TPOFF64, elf.R_X86_64_DTPMOD64:
+            return 0, 8
+        case elf.R_X86_64_GOTPCRELX:
+            return -2, 6
+        case elf.R_X86_64_REX_GOTPCRELX, elf.R_X86_64_GOTTPOFF:
+            return -3, 7
+        }
+    case elf.EM_386:
+        if elf.R_386(kind) == elf.R_386_GOT32X {
+            return -2, 6
+        }
+    case elf.EM_AARCH64:
+        if elf.R_AARCH64(kind) == elf.R_AARCH64_ABS64 || elf.R_AARCH64(kind) == elf.R_AARCH64_PREL64 {
+            return 0, 8
+        }
+    case elf.EM_RISCV:
+        switch elf.R_RISCV(kind) {
+        case elf.R_RISCV_RELAX, elf.R_RISCV_ALIGN:
+            return 0, 0
+        case elf.R_RISCV_RVC_BRANCH, elf.R_RISCV_RVC_JUMP:
+            return 0, 2
+        case elf.R_RISCV_64, elf.R_RISCV_CALL, elf.R_RISCV_CALL_PLT:
+            return 0, 8
+        }
+    }
+
+    return 0, 4
+}
+
+type relocation struct {
+    kind uint32
+    symbol uint32
+}
+
+// Whether the relocation is a rel32 that the linker points straight at the symbol, so the target can be worked out
+func isRelativeCall(machine elf.Machine, kind uint32) bool {
+    switch machine {
+    case elf.EM_X86_64:
+        return elf.R_X86_64(kind) == elf.R_X86_64_PLT32 || elf.R_X86_64(kind) == elf.R_X86_64_PC32
+    case elf.EM_386:
+        return elf.R_386(kind) == elf.R_386_PLT32 || elf.R_386(kind) == elf.R_386_PC32
+    }
+
+    return false
+}
+
+// Offsets, types and symbols of the relocations that apply to section
+func sectionRelocations(f *elf.File, section int) map[int]relocation {
+    relocations := map[int]relocation{}
+
+    for _, rel := range f.Sections {
+        if (rel.Type != elf.SHT_RELA && rel.Type != elf.SHT_REL) || int(rel.Info) != section {
+            continue
+        }
+        data, err := rel.Data()
+        if err != nil {
+            continue
+        }
+
+        size := 0
+        switch {
+        case f.Class == elf.ELFCLASS64 && rel.Type == elf.SHT_RELA:
+            size = 24
+        case f.Class == elf.ELFCLASS64:
+            size = 16
+        case rel.Type == elf.SHT_RELA:
+            size = 12
+        default:
+            size = 8
+        }
+
+        for i := 0; i+size <= len(data); i += size {
+            if f.Class == elf.ELFCLASS64 {
+                offset := f.ByteOrder.Uint64(data[i:])
+                info := f.ByteOrder.Uint64(data[i+8:])
+                relocations[int(offset)] = relocation{elf.R_TYPE64(info), elf.R_SYM64(info)}
+            } else {
+                offset := f.ByteOrder.Uint32(data[i:])
+                info := f.ByteOrder.Uint32(data[i+4:])
+                relocations[int(offset)] = relocation{elf.R_TYPE32(info), elf.R_SYM32(info)}
+            }
+        }
+    }
+
+    return relocations
+}
+
+// Makes a signature for every function in the object
+func objectSignatures(f *elf.File) []Signature {
+    signatures := []Signature{}
+
+    symbols, err := f.Symbols()
+    if err != nil {
+        return signatures
+    }
+
+    type function struct {
+        section int
+        value uint64
+    }
+    globals := map[function][]string{}
+    locals := map[function][]string{}
+    sizes := map[function]uint64{}
+    for _, sym := range symbols {
+        kind := elf.ST_TYPE(sym.Info)
+        // STT_LOOS is STT_GNU_IFUNC, whose code is the resolver
+        if (kind != elf.STT_FUNC && kind != elf.STT_LOOS) || sym.Size == 0 || sym.Name == "" {
+            continue
+        }
+        if sym.Section == elf.SHN_UNDEF || int(sym.Section) >= len(f.Sections) {
+            continue
+        }
+
+        fun := function{int(sym.Section), sym.Value}
+        if elf.ST_BIND(sym.Info) == elf.STB_LOCAL {
+            locals[fun] = append(locals[fun], sym.Name)
+        } else {
+            globals[fun] = append(globals[fun], sym.Name)
+        }
+        if sym.Size > sizes[fun] {
+            sizes[fun] = sym.Size
+        }
+    }
+
+    relocations := map[int]map[int]relocation{}
+    for fun, size := range sizes {
+        section := f.Sections[fun.section]
+        if section.Flags & elf.SHF_EXECINSTR == 0 || section.Type == elf.SHT_NOBITS {
+            continue
+        }
+        code, err := section.Data()
+        if err != nil || fun.value+size > uint64(len(code)) {
+            continue
+        }
+        if _, ok := relocations[fun.section]; !ok {
+            relocations[fun.section] = sectionRelocations(f, fun.section)
+        }
+
+        pattern := append([]byte{}, code[fun.value:fun.value+size]...)
+        mask := make([]bool, len(pattern))
+        for i := range mask {
+            mask[i] = true
+        }
+        references := []Reference{}
+        for offset, rel := range relocations[fun.section] {
+            start, length := relocatedBytes(f.Machine, rel.kind)
+            for i := offset + start; i < offset + start + length; i++ {
+                if i >= int(fun.value) && i < int(fun.value+size) {
+                    mask[i - int(fun.value)] = false
+                }
+            }
+
+            // Symbol 0 is the null one, which Symbols leaves out
+            if offset < int(fun.value) || offset+4 > int(fun.value+size) || !isRelativeCall(f.Machine, rel.kind) || rel.symbol == 0 || int(rel.symbol) > len(symbols) {
+                continue
+            }
+            target := symbols[rel.symbol-1]
+            if kind := elf.ST_TYPE(target.Info); target.Name != "" && (kind == elf.STT_FUNC || kind == elf.STT_LOOS || kind == elf.STT_NOTYPE) {
+                references = append(references, Reference{offset - int(fun.value), target.Name})
+            }
+        }
+        sort.Slice(references, func(i, j int) bool {
+            return references[i].Offset < references[j].Offset
+        })
+
+        names := globals[fun]
+        if len(names) == 0 {
+            names = locals[fun]
+        }
+        signature := Signature{preferredAlias(names), pattern, mask, references}
+        if signature.fixedBytes() < minimumSignatureBytes {
+            continue
+        }
+        signatures = append(signatures, signature)
+    }
+
+    return signatures
+}
+
+// Makes signatures for every function in the archives (or object files), which all have to be for the same architecture
+func GenerateSignatures(archives []string) (Arch, []Signature, error) {
+    signatures := []Signature{}
+    var arch Arch
+    found := false
+
+    for _, archive := range archives {
+        members, err := ReadArchive(archive)
+        if err != nil {
+            return arch, nil, err
+        }
+
+        for _, member := range members {
+            f, err := member.ELF()
+            if err != nil {
+                continue
+            }
+
+            memberArch, err := archFromELF(f)
+            if err != nil {
+                return arch, nil, fmt.Errorf("%s(%s): %s", archive, member.Name, err)
+            }
+            if found && memberArch != arch {
+                return arch, nil, fmt.Errorf("%s(%s) is %s, not %s", archive, member.Name, memberArch, arch)
+            }
+            arch = memberArch
+            found = true
+
+            signatures = append(signatures, objectSignatures(f)...)
+        }
+    }
+
+    if !found {
+        return arch, nil, errors.New("No object files to make signatures from")
+    }
+
+    // The same function is often in more than one archive (libc.a and libc_nonshared.a, for example)
+    sort.Slice(signatures, func(i, j int) bool {
+        return signatures[i].String() < signatures[j].String()
+    })
+    unique := make([]Signature, 0, len(signatures))
+    for i, signature := range signatures {
+        if i > 0 && signature.String() == signatures[i-1].String() {
+            continue
+        }
+        unique = append(unique, signature)
+    }
+
+    return arch, unique, nil
+}
+
+// The format is a header saying which architecture the signatures are for, then one signature per line.
+// Lines starting with # are comments.
+func WriteSignatures(file string, arch Arch, signatures []Signature) error {
+    f, err := os.Create(file)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    w := bufio.NewWriter(f)
+    fmt.Fprintln(w, "# unld signatures, pattern (.. is a wildcard) then name")
+    fmt.Fprintf(w, "arch %s\n", arch)
+    for _, signature := range signatures {
+        fmt.Fprintln(w, signature)
+    }
+
+    return w.Flush()
+}
+
+func ReadSignatures(file string) (Arch, []Signature, error) {
+    var arch Arch
+    signatures := []Signature{}
+
+    f, err := os.Open(file)
+    if err != nil {
+        return arch, nil, err
+    }
+    defer f.Close()
+
+    hasArch := false
+    scanner := bufio.NewScanner(f)
+    // Some functions are pretty big
+    scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+    for line := 1; scanner.Scan(); line++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" || strings.HasPrefix(text, "#") {
+            continue
+        }
+
+        fields := strings.Fields(text)
+        if len(fields) < 2 {
+            return arch, nil, errors.New(file + ":" + strconv.Itoa(line) + ": expected a pattern and a name")
+        }
+
+        if fields[0] == "arch" {
+            found := false
+            for _, a := range []Arch{ArchX86_64, ArchI386, ArchAArch64, ArchRISCV64} {
+                if a.String() == fields[1] {
+                    arch = a
+                    found = true
+                }
+            }
+            if !found {
+                return arch, nil, errors.New(file + ":" + strconv.Itoa(line) + ": unknown architecture " + fields[1])
+            }
+            hasArch = true
+            continue
+        }
+
+        pattern := fields[0]
+        if len(pattern) % 2 != 0 {
+            return arch, nil, errors.New(file + ":" + strconv.Itoa(line) + ": odd pattern length")
+        }
+        signature := Signature{fields[1], make([]byte, len(pattern)/2), make([]bool, len(pattern)/2), []Reference{}}
+        for i := 0; i < len(pattern); i += 2 {
+            if pattern[i:i+2] == ".." {
+                continue
+            }
+            b, err := strconv.ParseUint(pattern[i:i+2], 16, 8)
+            if err != nil {
+                return arch, nil, errors.New(file + ":" + strconv.Itoa(line) + ": bad pattern byte " + pattern[i:i+2])
+            }
+            signature.Pattern[i/2] = byte(b)
+            signature.Mask[i/2] = true
+        }
+        for _, field := range fields[2:] {
+            offset, name, ok := strings.Cut(field, ":")
+            parsed, err := strconv.ParseUint(offset, 16, 32)
+            if !ok || err != nil {
+                return arch, nil, errors.New(file + ":" + strconv.Itoa(line) + ": bad reference " + field)
+            }
+            signature.References = append(signature.References, Reference{int(parsed), name})
+        }
+        signatures = append(signatures, signature)
+    }
+    if err := scanner.Err(); err != nil {
+        return arch, nil, err
+    }
+
+    if !hasArch {
+        return arch, nil, errors.New(file + ": missing arch line")
+    }
+
+    return arch, signatures, nil
+}
+
+// Finds which of the functions disassembly couldn't name (sub_<address>) are known library functions.
+// The longest signature that matches wins. When different functions match equally well, the one whose calls go to
+// functions that were already recognized (or named to begin with, like puts@plt) as what it calls wins, and if that
+// doesn't settle it the function is left alone.
+func MatchSignatures(file string, sections []Section, signatures []Signature) (map[int]string, error) {
+    names := map[int]string{}
+    known := knownFunctions(sections)
+
+    f, err := elf.Open(file)
+    if err != nil {
+        return names, err
+    }
+    defer f.Close()
+
+    type candidates struct {
+        code []byte
+        signatures []Signature
+    }
+    ambiguous := map[int]candidates{}
+
+    for _, section := range sections {
+        for _, fun := range section.Funcs {
+            hex, ok := strings.CutPrefix(fun.Name, "sub_")
+            if !ok {
+                continue
+            }
+            addr, ok := parseHexAddress(hex)
+            if !ok {
+                continue
+            }
+
+            code := []byte{}
+            for _, s := range f.Sections {
+                if s.Flags & elf.SHF_EXECINSTR == 0 || s.Type == elf.SHT_NOBITS || uint64(addr) < s.Addr || uint64(addr) >= s.Addr+s.Size {
+                    continue
+                }
+                data, err := s.Data()
+                if err != nil {
+                    return names, err
+                }
+                code = data[uint64(addr)-s.Addr:]
+            }
+
+            best := []Signature{}
+            for _, signature := range signatures {
+                if (len(best) > 0 && len(signature.Pattern) < len(best[0].Pattern)) || !signature.Matches(code) {
+                    continue
+                }
+                if len(best) > 0 && len(signature.Pattern) > len(best[0].Pattern) {
+                    best = best[:0]
+                }
+                best = append(best, signature)
+            }
+
+            if len(best) == 0 {
+                continue
+            }
+            unique := true
+            for _, signature := range best {
+                unique = unique && signature.Name == best[0].Name
+            }
+            if unique {
+                names[addr] = best[0].Name
+            } else {
+                ambiguous[addr] = candidates{code, best}
+            }
+        }
+    }
+
+    // Every function that gets recognized can settle some more
+    for progress := true; progress; {
+        progress = false
+
+        for addr, candidates := range ambiguous {
+            bestScore := 0
+            winners := map[string]bool{}
+            for _, signature := range candidates.signatures {
+                score := 0
+                for _, ref := range signature.References {
+                    if ref.Offset+4 > len(candidates.code) {
+                        continue
+                    }
+                    target := addr + ref.Offset + 4 + int(int32(f.ByteOrder.Uint32(candidates.code[ref.Offset:])))
+                    name, ok := names[target]
+                    if !ok {
+                        name, ok = known[target]
+                    }
+                    if ok {
+                        if name != ref.Name {
+                            score = -1
+                            break
+                        }
+                        score++
+                    }
+                }
+
+                if score > bestScore {
+                    bestScore = score
+                    winners = map[string]bool{}
+                }
+                if score == bestScore && score > 0 {
+                    winners[signature.Name] = true
+                }
+            }
+
+            if len(winners) == 1 {
+                for name := range winners {
+                    names[addr] = name
+                }
+                delete(ambiguous, addr)
+                progress = true
+            }
+        }
+    }
+
+    return names, nil
+}
+
+// Addresses of the functions that have a name other than sub_<address>, and of whatever objdump named in calls,
+// like "call 1040 <puts@plt>". Imports go by their own name, since that is what signatures call them.
+func knownFunctions(sections []Section) map[int]string {
+    known := map[int]string{}
+    for _, section := range sections {
+        for _, fun := range section.Funcs {
+            if !strings.HasPrefix(fun.Name, "sub_") {
+                known[fun.Address] = fun.Name
+            }
+
+            for _, line := range fun.Content {
+                start := strings.LastIndex(line, " <")
+                if start == -1 || !strings.HasSuffix(line, ">") {
+                    continue
+                }
+                name := line[start+2:len(line)-1]
+                fields := strings.Fields(line[:start])
+                if len(fields) == 0 || strings.Contains(name, "+") || strings.HasPrefix(name, "sub_") {
+                    continue
+                }
+                if addr, ok := parseHexAddress(fields[len(fields)-1]); ok {
+                    known[addr] = strings.TrimSuffix(name, "@plt")
+                }
+            }
+        }
+    }
+
+    return known
+}
+
+// Renames functions, and every <name> and <name+0x...> that refers to them.
+// This must run before MonkeyPatchAssembly.
+func RenameFunctions(sections []Section, names map[string]string) []Section {
+    output := make([]Section, 0, len(sections))
+
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            code := make([]string, 0, len(fun.Content))
+
+            for _, line := range fun.Content {
+                start := strings.LastIndex(line, "<")
+                if start != -1 && strings.HasSuffix(line, ">") {
+                    target := line[start+1:len(line)-1]
+                    name, offset, _ := strings.Cut(target, "+")
+                    if renamed, ok := names[name]; ok {
+                        if offset != "" {
+                            renamed += "+" + offset
+                        }
+                        line = line[:start+1] + renamed + ">"
+                    }
+                }
+
+                code = append(code, line)
+            }
+
+            name := fun.Name
+            if renamed, ok := names[name]; ok {
+                name = renamed
+            }
+            funcs = append(funcs, AssemblyFunction{name, fun.Address, code, fun.Info})
+        }
+
+        output = append(output, Section{section.Name, funcs})
+    }
+
+    return output
+}
+
+// sub_<address> -> name, for RenameFunctions. If more than one function matches the same name, only the first one gets it.
+func signatureRenames(matched map[int]string) map[string]string {
+    addrs := make([]int, 0, len(matched))
+    for addr := range matched {
+        addrs = append(addrs, addr)
+    }
+    sort.Ints(addrs)
+
+    renames := map[string]string{}
+    taken := map[string]bool{}
+    for _, addr := range addrs {
+        if taken[matched[addr]] {
+            continue
+        }
+        taken[matched[addr]] = true
+        renames[fmt.Sprintf("sub_%x", addr)] = matched[addr]
+    }
+
+    return renames
+}
@@ -0,0 +1,240 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type codeRange struct {
+    start int
+    end int
+    // Function starts in the range, sorted
+    starts []int
+}
+
+func parseHexAddress(s string) (int, bool) {
+    addr, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+    return int(addr), err == nil
+}
+
+// "401010:\tcall 0x401119" -> 0x401010, "call 0x401119"
+func splitDumpLine(line string) (int, string, bool) {
+    tab := strings.IndexRune(line, rune(9))
+    if tab == -1 || !strings.HasSuffix(line[:tab], ":") {
+        return 0, "", false
+    }
+
+    addr, ok := parseHexAddress(strings.TrimSuffix(line[:tab], ":"))
+    return addr, line[tab+1:], ok
+}
+
+func isBranch(mnemonic string) bool {
+    return strings.HasPrefix(mnemonic, "j") || strings.HasPrefix(mnemonic, "call") || strings.HasPrefix(mnemonic, "loop")
+}
+
+// Where an instruction points, and whether objdump put an address there at all.
+// That is either the branch target or the address in the # comment.
+func dumpLineTarget(code string) (int, bool) {
+    if comment := strings.LastIndex(code, "# "); comment != -1 {
+        fields := strings.Fields(code[comment+2:])
+        if len(fields) == 0 {
+            return 0, false
+        }
+        return parseHexAddress(fields[0])
+    }
+
+    fields := strings.Fields(code)
+    if len(fields) < 2 || !isBranch(fields[0]) {
+        return 0, false
+    }
+    return parseHexAddress(fields[1])
+}
+
+// Stripped executables have a single <.text> label for all of the code, with branch targets named after whatever
+// symbol objdump found before them (or nothing at all). This splits that code into functions at the entry point and
+// everything that is called or has its address loaded, named sub_<address> like most disassemblers do,
+// and points every reference into it at those functions, so the dump looks like it came from an executable with symbols.
+// Anything in known is a function too. Only x86 is understood.
+func splitAnonymousCode(lines []string, entry int, known []int) []string {
+    // Sections that have nothing but a label named after themselves
+    ranges := map[string]*codeRange{}
+    labels := map[string]int{}
+    section := ""
+    for _, line := range lines {
+        if name, ok := strings.CutPrefix(line, "Disassembly of section "); ok {
+            section = strings.TrimSuffix(name, ":")
+            continue
+        }
+        if name, ok := strings.CutSuffix(line, ":"); ok && !strings.ContainsRune(line, rune(9)) {
+            labels[section]++
+            if strings.HasSuffix(name, "<" + section + ">") {
+                ranges[section] = &codeRange{-1, -1, []int{}}
+            }
+            continue
+        }
+        if r, ok := ranges[section]; ok {
+            if addr, _, ok := splitDumpLine(line); ok {
+                if r.start == -1 {
+                    r.start = addr
+                }
+                r.end = addr + 1
+            }
+        }
+    }
+    for name := range ranges {
+        if labels[name] != 1 || ranges[name].start == -1 {
+            delete(ranges, name)
+        }
+    }
+    if len(ranges) == 0 {
+        return lines
+    }
+
+    find := func(addr int) *codeRange {
+        for _, r := range ranges {
+            if addr >= r.start && addr < r.end {
+                return r
+            }
+        }
+        return nil
+    }
+
+    // Function starts have to be instructions, not the middle of one
+    instructions := map[int]bool{}
+    starts := map[int]bool{entry: true}
+    for _, addr := range known {
+        starts[addr] = true
+    }
+    for _, r := range ranges {
+        starts[r.start] = true
+    }
+    for _, line := range lines {
+        addr, code, ok := splitDumpLine(line)
+        if !ok || find(addr) == nil {
+            continue
+        }
+        instructions[addr] = true
+
+        fields := strings.Fields(code)
+        target, ok := dumpLineTarget(code)
+        if !ok && len(fields) > 0 && strings.HasPrefix(fields[0], "mov") {
+            // Non-PIE code loads function pointers as immediates, like main for __libc_start_main
+            target, ok = parseHexAddress(code[strings.LastIndex(code, ",")+1:])
+        }
+        if !ok || len(fields) == 0 {
+            continue
+        }
+        // Jumps stay inside the function, but calls and function pointers don't
+        if strings.HasPrefix(fields[0], "call") || strings.HasPrefix(fields[0], "lea") || strings.HasPrefix(fields[0], "mov") {
+            starts[target] = true
+        }
+    }
+    for addr := range starts {
+        if r := find(addr); r != nil && instructions[addr] {
+            r.starts = append(r.starts, addr)
+        }
+    }
+    for _, r := range ranges {
+        sort.Ints(r.starts)
+    }
+
+    name := func(addr int) string {
+        if addr == entry {
+            return "_start"
+        }
+        return fmt.Sprintf("sub_%x", addr)
+    }
+    describe := func(addr int) (string, bool) {
+        r := find(addr)
+        if r == nil {
+            return "", false
+        }
+        i := sort.SearchInts(r.starts, addr+1) - 1
+        if i < 0 {
+            return "", false
+        }
+        if r.starts[i] == addr {
+            return name(addr), true
+        }
+        return fmt.Sprintf("%s+0x%x", name(r.starts[i]), addr - r.starts[i]), true
+    }
+
+    output := make([]string, 0, len(lines))
+    section = ""
+    for _, line := range lines {
+        if name, ok := strings.CutPrefix(line, "Disassembly of section "); ok {
+            section = strings.TrimSuffix(name, ":")
+            output = append(output, line)
+            continue
+        }
+        r, ok := ranges[section]
+        if !ok {
+            output = append(output, line)
+            continue
+        }
+        addr, code, ok := splitDumpLine(line)
+        if !ok {
+            // The <.text> label, which the first function replaces
+            if !strings.HasSuffix(line, "<" + section + ">:") {
+                output = append(output, line)
+            }
+            continue
+        }
+
+        if i := sort.SearchInts(r.starts, addr); i < len(r.starts) && r.starts[i] == addr {
+            output = append(output, fmt.Sprintf("%016x <%s>:", addr, name(addr)))
+        }
+
+        if target, ok := dumpLineTarget(code); ok {
+            if description, ok := describe(target); ok {
+                if open := strings.LastIndex(code, " <"); open != -1 && strings.HasSuffix(code, ">") {
+                    code = code[:open]
+                }
+                code = fmt.Sprintf("%s <%s>", code, description)
+            }
+        }
+        output = append(output, fmt.Sprintf("%x:%c%s", addr, rune(9), code))
+    }
+
+    return output
+}
+
+// The entry point, for naming _start in stripped executables, and every other function the executable itself
+// points to: IFUNC resolvers, constructors and destructors
+func getEntryPoints(file string) (int, []int, bool) {
+    f, err := elf.Open(file)
+    if err != nil {
+        return 0, nil, false
+    }
+    defer f.Close()
+
+    known := []int{}
+    for _, resolver := range ifuncSlots(f) {
+        known = append(known, resolver)
+    }
+    addends := relativeAddends(f)
+    for _, name := range []string{".init_array", ".fini_array", ".preinit_array"} {
+        known = append(known, readPointerArray(f, name, addends)...)
+    }
+
+    return int(f.Entry), known, true
+}
+
+// Without a symbol table, objdump has nothing to name functions or data after
+func IsStripped(file string) (bool, error) {
+    f, err := elf.Open(file)
+    if err != nil {
+        return false, err
+    }
+    defer f.Close()
+
+    _, err = f.Symbols()
+    if err == elf.ErrNoSymbols {
+        return true, nil
+    }
+
+    return false, err
+}
@@ -0,0 +1,361 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Thread-local variables, from .tdata and .tbss. Their Location is the offset in the TLS block instead of an address,
+// which is also what the symbol table has for them.
+// The second value is where the block starts relative to the thread pointer. On x86 the block is right below it.
+func GetThreadLocals(file string) ([]Data, int, error) {
+    f, err := elf.Open(file)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer f.Close()
+
+    var tls *elf.Prog
+    for _, prog := range f.Progs {
+        if prog.Type == elf.PT_TLS {
+            tls = prog
+        }
+    }
+    if tls == nil {
+        return []Data{}, 0, nil
+    }
+
+    template := make([]byte, tls.Memsz)
+    if _, err := tls.ReadAt(template[:tls.Filesz], 0); err != nil {
+        return nil, 0, err
+    }
+
+    align := int(tls.Align)
+    if align == 0 {
+        align = 1
+    }
+    size := int(tls.Memsz)
+    start := -((size + align - 1) / align * align)
+
+    symbols, err := f.Symbols()
+    if err != nil && err != elf.ErrNoSymbols {
+        return nil, 0, err
+    }
+
+    type variable struct {
+        name string
+        offset int
+        size int
+    }
+    variables := []variable{}
+    for _, sym := range symbols {
+        if elf.ST_TYPE(sym.Info) != elf.STT_TLS || sym.Section == elf.SHN_UNDEF || int(sym.Value) >= size {
+            continue
+        }
+        variables = append(variables, variable{sym.Name, int(sym.Value), int(sym.Size)})
+    }
+    if len(variables) == 0 {
+        // Stripped, so each section is one blob
+        if tls.Filesz > 0 {
+            variables = append(variables, variable{fmt.Sprintf("tdata_%x", tls.Vaddr), 0, int(tls.Filesz)})
+        }
+        if tls.Memsz > tls.Filesz {
+            variables = append(variables, variable{fmt.Sprintf("tbss_%x", tls.Vaddr+tls.Filesz), int(tls.Filesz), int(tls.Memsz-tls.Filesz)})
+        }
+    }
+    sort.Slice(variables, func(i, j int) bool {
+        return variables[i].offset < variables[j].offset
+    })
+
+    data := make([]Data, 0, len(variables))
+    for i, v := range variables {
+        end := v.offset + v.size
+        if v.size == 0 {
+            end = size
+            if i+1 < len(variables) {
+                end = variables[i+1].offset
+            }
+        }
+        if end > size {
+            end = size
+        }
+
+        // Extern by default, like the other globals
+        data = append(data, Data{v.name, v.offset, template[v.offset:end], true, nil, SymbolInfo{}})
+    }
+
+    return data, start, nil
+}
+
+// Initialized thread-locals go in .tdata, and ones that are all zeroes in .tbss
+func isZero(data []byte) bool {
+    for _, b := range data {
+        if b != 0 {
+            return false
+        }
+    }
+
+    return true
+}
+
+var x86Scratch = []string{"rax", "rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11"}
+
+// eax, ax, al and ah are all rax
+func x86Register64(name string) (string, bool) {
+    name = strings.ToLower(name)
+    switch name {
+    case "rax", "eax", "ax", "al", "ah":
+        return "rax", true
+    case "rbx", "ebx", "bx", "bl", "bh":
+        return "rbx", true
+    case "rcx", "ecx", "cx", "cl", "ch":
+        return "rcx", true
+    case "rdx", "edx", "dx", "dl", "dh":
+        return "rdx", true
+    case "rsi", "esi", "si", "sil":
+        return "rsi", true
+    case "rdi", "edi", "di", "dil":
+        return "rdi", true
+    case "rbp", "ebp", "bp", "bpl":
+        return "rbp", true
+    case "rsp", "esp", "sp", "spl":
+        return "rsp", true
+    }
+
+    if rest, ok := strings.CutPrefix(name, "r"); ok {
+        number := strings.TrimRight(rest, "dwb")
+        if n, err := strconv.Atoi(number); err == nil && n >= 8 && n <= 15 {
+            return "r" + number, true
+        }
+    }
+
+    return "", false
+}
+
+func usesX86Register(line string, register string) bool {
+    words := strings.FieldsFunc(line, func(c rune) bool {
+        return !isSymbolChar(byte(c))
+    })
+    for _, word := range words {
+        if reg, ok := x86Register64(word); ok && reg == register {
+            return true
+        }
+    }
+
+    return false
+}
+
+// "DWORD fs:0xffffffffffffffc0" -> "DWORD", -0x40
+func parseSegmentOperand(operand string, segment string) (string, int, bool) {
+    before, after, ok := strings.Cut(operand, segment + ":")
+    if !ok || strings.HasPrefix(after, "[") {
+        return "", 0, false
+    }
+
+    offset, err := strconv.ParseUint(strings.TrimPrefix(after, "0x"), 16, 64)
+    if err != nil {
+        return "", 0, false
+    }
+
+    return strings.TrimSpace(before), int(int64(offset)), true
+}
+
+// "big+0x10" -> "big", "+0x10"
+func splitSymbolOffset(symbol string) (string, string) {
+    name, offset, ok := strings.Cut(symbol, "+")
+    if !ok {
+        return name, ""
+    }
+
+    return name, "+" + offset
+}
+
+// Rewrites local-exec TLS accesses (fs: with a negative offset, or the thread pointer plus one) into initial-exec ones
+// through the GOT, which the linker relaxes back for executables. nasm can't do local-exec relocations.
+// Offsets to anything in the TCB (like the stack protector at fs:0x28) only get the brackets nasm wants.
+// This must run after MonkeyPatchAssembly.
+func PatchTLS64(sections []Section, tls []Data, start int) []Section {
+    output := make([]Section, 0, len(sections))
+
+    variableAt := func(tpoff int) (string, bool) {
+        if len(tls) == 0 || tpoff >= 0 {
+            return "", false
+        }
+        return FindDataAt(tpoff - start, tls)
+    }
+
+    for _, section := range sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            code := make([]string, 0, len(fun.Content))
+
+            for i := 0; i < len(fun.Content); i++ {
+                line := fun.Content[i]
+                if !strings.Contains(line, "fs:") {
+                    code = append(code, line)
+                    continue
+                }
+
+                mnemonic, operands := splitOperands(line)
+                segment := -1
+                size, tpoff := "", 0
+                for j, operand := range operands {
+                    if s, offset, ok := parseSegmentOperand(operand, "fs"); ok {
+                        segment, size, tpoff = j, s, offset
+                    } else if before, after, ok := strings.Cut(operand, "fs:["); ok {
+                        // Initial-exec, already relative to a register
+                        operands[j] = before + "[fs:" + after
+                    }
+                }
+                if segment == -1 {
+                    code = append(code, mnemonic + " " + strings.Join(operands, ","))
+                    continue
+                }
+
+                // mov rax, fs:0x0 gets the thread pointer, which the next instruction adds the offset to
+                if tpoff == 0 && mnemonic == "mov" && i+1 < len(fun.Content) {
+                    tp, _ := x86Register64(operands[0])
+                    nextMnemonic, next := splitOperands(fun.Content[i+1])
+                    dest, _ := x86Register64(strings.Join(next[:min(len(next), 1)], ""))
+                    var variable string
+                    found := false
+                    if nextMnemonic == "add" && len(next) == 2 && dest == tp {
+                        if offset, err := strconv.ParseUint(strings.TrimPrefix(next[1], "0x"), 16, 64); err == nil {
+                            variable, found = variableAt(int(int64(offset)))
+                        }
+                    } else if nextMnemonic == "lea" && len(next) == 2 && strings.HasPrefix(next[1], "[" + tp) {
+                        inner := strings.TrimSuffix(strings.TrimPrefix(next[1], "[" + tp), "]")
+                        if offset, err := strconv.ParseInt(strings.Replace(inner, "0x", "", 1), 16, 64); err == nil && inner != "" {
+                            variable, found = variableAt(int(offset))
+                        }
+                    }
+
+                    if found {
+                        name, offset := splitSymbolOffset(variable)
+                        code = append(code, fmt.Sprintf("mov %s,%s [fs:0x0]", operands[0], size))
+                        if dest == tp {
+                            code = append(code, fmt.Sprintf("add %s,[rel %s wrt ..gottpoff]", tp, name))
+                        } else {
+                            code = append(code, fmt.Sprintf("mov %s,[rel %s wrt ..gottpoff]", dest, name))
+                            code = append(code, fmt.Sprintf("add %s,%s", dest, tp))
+                        }
+                        if offset != "" {
+                            code = append(code, fmt.Sprintf("lea %s,[%s%s]", dest, dest, offset))
+                        }
+                        i++
+                        continue
+                    }
+                }
+
+                variable, ok := variableAt(tpoff)
+                if !ok {
+                    operands[segment] = strings.TrimSpace(fmt.Sprintf("%s [fs:0x%x]", size, uint64(int64(tpoff))))
+                    code = append(code, mnemonic + " " + strings.Join(operands, ","))
+                    continue
+                }
+                name, offset := splitSymbolOffset(variable)
+
+                // Loads can use the destination to hold the offset, everything else needs a register saved.
+                // The stack pointer is moved past the red zone first, since leaf functions keep their locals there.
+                dest, isRegister := x86Register64(operands[0])
+                loads := mnemonic == "mov" || mnemonic == "movzx" || mnemonic == "movsx" || mnemonic == "movsxd"
+                if loads && isRegister && segment == 1 && dest != "rsp" {
+                    operands[segment] = strings.TrimSpace(fmt.Sprintf("%s [fs:%s%s]", size, dest, offset))
+                    code = append(code, fmt.Sprintf("mov %s,[rel %s wrt ..gottpoff]", dest, name))
+                    code = append(code, mnemonic + " " + strings.Join(operands, ","))
+                    continue
+                }
+
+                scratch := ""
+                for _, register := range x86Scratch {
+                    if !usesX86Register(line, register) {
+                        scratch = register
+                        break
+                    }
+                }
+                operands[segment] = strings.TrimSpace(fmt.Sprintf("%s [fs:%s%s]", size, scratch, offset))
+                code = append(code, "lea rsp,[rsp-128]", "push " + scratch)
+                code = append(code, fmt.Sprintf("mov %s,[rel %s wrt ..gottpoff]", scratch, name))
+                code = append(code, mnemonic + " " + strings.Join(operands, ","))
+                code = append(code, "pop " + scratch, "lea rsp,[rsp+128]")
+            }
+
+            funcs = append(funcs, AssemblyFunction{fun.Name, fun.Address, code, fun.Info})
+        }
+
+        output = append(output, Section{section.Name, funcs})
+    }
+
+    return output
+}
+
+// What a line does with thread-locals that can't be turned back into symbols, if anything.
+// Only local-exec and initial-exec on x86-64 are rewritten, everything else would still have the executable's
+// offsets in it, which point at some other variable (or nothing) once it's linked again.
+func unsupportedTLS(arch Arch, line string, previous string) (string, bool) {
+    mnemonic, operands := splitOperands(line)
+    words := strings.FieldsFunc(line, func(c rune) bool {
+        return !isSymbolChar(byte(c))
+    })
+    for _, word := range words {
+        if word == "__tls_get_addr" || word == "___tls_get_addr" {
+            return word, true
+        }
+    }
+
+    switch arch {
+    case ArchX86_64:
+        // lea rax,[rel x@tlsdesc] then call [rax]
+        prevMnemonic, prevOperands := splitOperands(previous)
+        if mnemonic == "call" && len(operands) == 1 && strings.HasSuffix(operands[0], "[rax]") && prevMnemonic == "lea" && len(prevOperands) == 2 && prevOperands[0] == "rax" {
+            return "a TLS descriptor", true
+        }
+    case ArchI386:
+        for _, operand := range operands {
+            if strings.Contains(operand, "gs:[") || strings.Contains(operand, "[gs:") {
+                return "gs", true
+            }
+            // Anything above the thread pointer, like the stack protector at gs:0x14, is the TCB
+            if _, offset, ok := parseSegmentOperand(operand, "gs"); ok && (offset == 0 || int32(offset) < 0) {
+                return "gs", true
+            }
+        }
+    case ArchAArch64:
+        if mnemonic == "mrs" && len(operands) == 2 && operands[1] == "tpidr_el0" {
+            return "tpidr_el0", true
+        }
+    case ArchRISCV64:
+        for _, operand := range operands {
+            if operand == "tp" || strings.HasSuffix(operand, "(tp)") {
+                return "tp", true
+            }
+        }
+    }
+
+    return "", false
+}
+
+func (o Object) checkThreadLocals() error {
+    // Mach-O has thread-local variable descriptors instead, which are only ever accessed through dyld
+    if o.Format != FormatELF {
+        return nil
+    }
+
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            previous := ""
+            for _, line := range fun.Content {
+                if how, ok := unsupportedTLS(o.Arch, line, previous); ok {
+                    return fmt.Errorf("%s accesses thread-locals through %s, but only x86-64 local-exec and initial-exec accesses can be extracted", fun.Name, how)
+                }
+                previous = line
+            }
+        }
+    }
+
+    return nil
+}
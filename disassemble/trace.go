@@ -0,0 +1,217 @@
+package disassemble
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The hooks every traced function calls. Without definitions of them, the weak ones that log to stderr are used:
+//     void unld_trace_enter(const char *name, int count, const long *args);
+//     void unld_trace_exit(const char *name, long result);
+const (
+    traceEnter = "unld_trace_enter"
+    traceExit = "unld_trace_exit"
+)
+
+// What the stderr logger needs, for the symbols passed to Output
+var TraceImports = []string{"dprintf"}
+
+// Where the code of a traced function goes, so its own name can be the trampoline
+func tracedBody(name string) string {
+    return name + ".traced"
+}
+
+func cString(s string) []byte {
+    return append([]byte(s), 0)
+}
+
+var xmmArguments = []string{"xmm0", "xmm1", "xmm2", "xmm3", "xmm4", "xmm5", "xmm6", "xmm7"}
+
+// Calls unld_trace_enter with the first count integer arguments, and then jumps to the function.
+// The exit hook needs the trampoline to call the function instead, which shifts everything on the stack,
+// so it only gets called when the function doesn't seem to take more arguments than the registers hold.
+func traceTrampoline(fun AssemblyFunction, name string, count int) []string {
+    code := []string{}
+    // The arguments, in order, and al, which says how many vector registers a variadic function gets
+    for _, reg := range []string{"rax", "r9", "r8", "rcx", "rdx", "rsi", "rdi"} {
+        code = append(code, "push   " + reg)
+    }
+    code = append(code, "sub    rsp,0x80")
+    for i, reg := range xmmArguments {
+        code = append(code, fmt.Sprintf("movdqu [rsp+0x%x],%s", i*16, reg))
+    }
+    code = append(code,
+        fmt.Sprintf("lea    rdi,[rel %s]", name),
+        fmt.Sprintf("mov    esi,%d", count),
+        "lea    rdx,[rsp+0x80]",
+        "call   " + traceEnter + " wrt ..plt",
+    )
+    for i, reg := range xmmArguments {
+        code = append(code, fmt.Sprintf("movdqu %s,[rsp+0x%x]", reg, i*16))
+    }
+    code = append(code, "add    rsp,0x80")
+    for _, reg := range []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9", "rax"} {
+        code = append(code, "pop    " + reg)
+    }
+
+    if GuessArguments(ArchX86_64, fun) >= len(x86Arguments) {
+        return append(code, "jmp    " + tracedBody(fun.Name))
+    }
+
+    // Keeping the stack aligned for the call, and the return value for the caller
+    return append(code,
+        "sub    rsp,0x8",
+        "call   " + tracedBody(fun.Name),
+        "push   rax",
+        "push   rdx",
+        "sub    rsp,0x10",
+        "movdqu [rsp],xmm0",
+        fmt.Sprintf("lea    rdi,[rel %s]", name),
+        "mov    rsi,rax",
+        "call   " + traceExit + " wrt ..plt",
+        "movdqu xmm0,[rsp]",
+        "add    rsp,0x10",
+        "pop    rdx",
+        "pop    rax",
+        "add    rsp,0x8",
+        "ret",
+    )
+}
+
+// The weak stderr logger, printing name(0x1, 0x2) and name returned 0x3
+func traceLogger() ([]AssemblyFunction, []Data) {
+    weak := SymbolInfo{BindingWeak, VisibilityDefault, SymbolFunction, 0}
+    enter := AssemblyFunction{traceEnter, 0, []string{
+        "push   rbx",
+        "push   r12",
+        "push   r13",
+        "mov    rbx,rdx",
+        "mov    r12d,esi",
+        "mov    rdx,rdi",
+        "mov    edi,0x2",
+        "lea    rsi,[rel unld_trace_call]",
+        "xor    eax,eax",
+        "call   dprintf wrt ..plt",
+        "xor    r13d,r13d",
+        ".next:",
+        "cmp    r13d,r12d",
+        "jge    .done",
+        "mov    edi,0x2",
+        "lea    rsi,[rel unld_trace_argument]",
+        "lea    rax,[rel unld_trace_first_argument]",
+        "test   r13d,r13d",
+        "cmove  rsi,rax",
+        "mov    rdx,QWORD [rbx+r13*8]",
+        "xor    eax,eax",
+        "call   dprintf wrt ..plt",
+        "inc    r13d",
+        "jmp    .next",
+        ".done:",
+        "mov    edi,0x2",
+        "lea    rsi,[rel unld_trace_call_end]",
+        "xor    eax,eax",
+        "call   dprintf wrt ..plt",
+        "pop    r13",
+        "pop    r12",
+        "pop    rbx",
+        "ret",
+    }, weak}
+    exit := AssemblyFunction{traceExit, 0, []string{
+        "sub    rsp,0x8",
+        "mov    rcx,rsi",
+        "mov    rdx,rdi",
+        "mov    edi,0x2",
+        "lea    rsi,[rel unld_trace_return]",
+        "xor    eax,eax",
+        "call   dprintf wrt ..plt",
+        "add    rsp,0x8",
+        "ret",
+    }, weak}
+
+    formats := []Data{
+        {"unld_trace_call", 0, cString("%s("), false, nil, SymbolInfo{}},
+        {"unld_trace_first_argument", 0, cString("%#lx"), false, nil, SymbolInfo{}},
+        {"unld_trace_argument", 0, cString(", %#lx"), false, nil, SymbolInfo{}},
+        {"unld_trace_call_end", 0, cString(")\n"), false, nil, SymbolInfo{}},
+        {"unld_trace_return", 0, cString("%s returned %#lx\n"), false, nil, SymbolInfo{}},
+    }
+
+    return []AssemblyFunction{enter, exit}, formats
+}
+
+// Puts a trampoline in front of each of the functions, which calls the trace hooks with its name and first arguments.
+// count is how many arguments get logged, or -1 to go by GuessArguments. This should run last, once the names are final.
+// Only x86-64 ELF objects can be traced for now.
+func (o Object) Trace(names []string, count int) (Object, error) {
+    if o.Arch != ArchX86_64 || o.Format != FormatELF {
+        return o, errors.New("Only x86-64 ELF object files can be traced")
+    }
+
+    traced := map[string]bool{}
+    for _, name := range names {
+        traced[name] = true
+    }
+    // Jumps into the middle of a traced function still go to its code, rather than the trampoline
+    bodies := map[string]string{}
+    for name := range traced {
+        bodies[name] = tracedBody(name)
+    }
+
+    sections := make([]Section, 0, len(o.Sections)+1)
+    literals := append([]Data{}, o.Literals...)
+    hasText := false
+    for _, section := range o.Sections {
+        funcs := make([]AssemblyFunction, 0, len(section.Funcs))
+
+        for _, fun := range section.Funcs {
+            if !traced[fun.Name] {
+                code := make([]string, 0, len(fun.Content))
+                for _, line := range fun.Content {
+                    code = append(code, renameOperands(line, bodies, true))
+                }
+                fun.Content = code
+                funcs = append(funcs, fun)
+                continue
+            }
+
+            arguments := count
+            if arguments == -1 {
+                arguments = GuessArguments(o.Arch, fun)
+            }
+            if arguments > len(x86Arguments) {
+                arguments = len(x86Arguments)
+            }
+
+            name := fun.Name + ".trace_name"
+            literals = append(literals, Data{name, 0, cString(fun.Name), false, nil, SymbolInfo{}})
+            funcs = append(funcs, AssemblyFunction{fun.Name, fun.Address, traceTrampoline(fun, name, arguments), fun.Info})
+
+            // Calls to itself aren't traced again, and its jumps stay inside of it
+            code := make([]string, 0, len(fun.Content))
+            for _, line := range fun.Content {
+                code = append(code, renameInLine(line, bodies))
+            }
+            funcs = append(funcs, AssemblyFunction{tracedBody(fun.Name), fun.Address, code, SymbolInfo{BindingLocal, VisibilityDefault, SymbolFunction, 0}})
+        }
+
+        if section.Name == ".text" {
+            hasText = true
+        }
+        sections = append(sections, Section{section.Name, funcs})
+    }
+
+    logger, formats := traceLogger()
+    if hasText {
+        for i, section := range sections {
+            if section.Name == ".text" {
+                sections[i] = Section{section.Name, append(section.Funcs, logger...)}
+            }
+        }
+    } else {
+        sections = append(sections, Section{".text", logger})
+    }
+
+    o.Sections = sections
+    o.Literals = append(literals, formats...)
+    return o, nil
+}
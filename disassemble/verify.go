@@ -0,0 +1,300 @@
+package disassemble
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How a function of the original executable compares to the rebuilt one
+type FunctionCheck struct {
+    Name string
+    Found bool
+    // The first instruction that differs, counting from 1, or 0 if they are the same
+    Line int
+    Original string
+    Rebuilt string
+}
+
+func (c FunctionCheck) Matches() bool {
+    return c.Found && c.Line == 0
+}
+
+// How a piece of data compares, by its bytes and what its pointers point at
+type DataCheck struct {
+    Name string
+    Found bool
+    Matches bool
+}
+
+// Duplicate names get their address after them, which is different in every executable
+var disambiguationSuffix = regexp.MustCompile(`([A-Za-z_$][A-Za-z0-9_$]*)\.[0-9a-f]+\b`)
+
+func isPadding(line string) bool {
+    mnemonic, _ := splitOperands(line)
+    return strings.Contains(line, "nop") || mnemonic == "int3" || line == "xchg   ax,ax"
+}
+
+// The instructions of a function without what depends on where it ended up: the padding after it,
+// spaces at the end and the addresses in the names of duplicates
+func normalizedCode(fun AssemblyFunction) []string {
+    code := make([]string, 0, len(fun.Content))
+    for _, line := range fun.Content {
+        code = append(code, disambiguationSuffix.ReplaceAllString(strings.TrimSpace(line), "$1"))
+    }
+    for len(code) > 0 && isPadding(code[len(code)-1]) {
+        code = code[:len(code)-1]
+    }
+
+    return code
+}
+
+// normalizedCode, but keeping the addresses of duplicates so they can be told apart.
+// renames gives the duplicates of the other executable the names they have in this one.
+func pairedCode(fun AssemblyFunction, renames map[string]string) []string {
+    code := make([]string, 0, len(fun.Content))
+    for _, line := range fun.Content {
+        code = append(code, renameInLine(strings.TrimSpace(line), renames))
+    }
+    for len(code) > 0 && isPadding(code[len(code)-1]) {
+        code = code[:len(code)-1]
+    }
+
+    return code
+}
+
+func normalizedName(name string) string {
+    return disambiguationSuffix.ReplaceAllString(name, "$1")
+}
+
+// Name -> address of the functions and data of the object
+func namedAddresses(o Object) map[string]int {
+    addresses := map[string]int{}
+    for _, section := range o.Sections {
+        for _, fun := range section.Funcs {
+            addresses[fun.Name] = fun.Address
+        }
+    }
+    for _, datas := range [][]Data{o.Globals, o.Variables, o.ThreadLocals, o.Literals, o.Relocated} {
+        for _, data := range datas {
+            addresses[data.Name] = data.Location
+        }
+    }
+
+    return addresses
+}
+
+// Rebuilt name -> original name. Names both have go together, and the duplicates left,
+// whose addresses are different, are paired up in the order of their addresses.
+func pairNames(original Object, rebuilt Object) map[string]string {
+    originals, rebuilts := namedAddresses(original), namedAddresses(rebuilt)
+    pairs := map[string]string{}
+    left := map[string][]string{}
+    leftRebuilt := map[string][]string{}
+
+    for name := range originals {
+        if _, ok := rebuilts[name]; ok {
+            pairs[name] = name
+        } else {
+            left[normalizedName(name)] = append(left[normalizedName(name)], name)
+        }
+    }
+    for name := range rebuilts {
+        if _, ok := pairs[name]; !ok {
+            leftRebuilt[normalizedName(name)] = append(leftRebuilt[normalizedName(name)], name)
+        }
+    }
+
+    for normalized, names := range left {
+        others := leftRebuilt[normalized]
+        sort.Slice(names, func(i, j int) bool {
+            return originals[names[i]] < originals[names[j]]
+        })
+        sort.Slice(others, func(i, j int) bool {
+            return rebuilts[others[i]] < rebuilts[others[j]]
+        })
+        for i := 0; i < len(names) && i < len(others); i++ {
+            pairs[others[i]] = names[i]
+        }
+    }
+
+    return pairs
+}
+
+// The pairs that have different names
+func renamedPairs(pairs map[string]string) map[string]string {
+    renames := map[string]string{}
+    for rebuilt, original := range pairs {
+        if rebuilt != original {
+            renames[rebuilt] = original
+        }
+    }
+
+    return renames
+}
+
+// Compares every function of original with the same one in rebuilt, instruction by instruction.
+// Both should have their addresses named already, like LoadExecutable does, so moving code around doesn't count as a change.
+func CompareFunctions(original Object, rebuilt Object) []FunctionCheck {
+    pairs := pairNames(original, rebuilt)
+    renames := renamedPairs(pairs)
+    functions := map[string]AssemblyFunction{}
+    for _, section := range rebuilt.Sections {
+        for _, fun := range section.Funcs {
+            if name, ok := pairs[fun.Name]; ok {
+                functions[name] = fun
+            }
+        }
+    }
+
+    checks := []FunctionCheck{}
+    for _, section := range original.Sections {
+        for _, fun := range section.Funcs {
+            check := FunctionCheck{fun.Name, false, 0, "", ""}
+            other, ok := functions[fun.Name]
+            if !ok {
+                checks = append(checks, check)
+                continue
+            }
+            check.Found = true
+
+            code, otherCode := pairedCode(fun, nil), pairedCode(other, renames)
+            for i := 0; i < len(code) || i < len(otherCode); i++ {
+                line, otherLine := "", ""
+                if i < len(code) {
+                    line = code[i]
+                }
+                if i < len(otherCode) {
+                    otherLine = otherCode[i]
+                }
+                if line != otherLine {
+                    check.Line, check.Original, check.Rebuilt = i+1, line, otherLine
+                    break
+                }
+            }
+            checks = append(checks, check)
+        }
+    }
+
+    return checks
+}
+
+// samePointer tells if the pointers in data and other point at the same thing
+func dataMatches(data Data, other Data, pointerSize int, samePointer func(string, string) bool) bool {
+    if len(data.Data) != len(other.Data) || len(data.Pointers) != len(other.Pointers) {
+        return false
+    }
+    for offset, pointer := range data.Pointers {
+        if !samePointer(pointer, other.Pointers[offset]) {
+            return false
+        }
+    }
+    for i := 0; i < len(data.Data); i++ {
+        if _, ok := data.Pointers[i]; ok {
+            // The address is different, but it points to the same thing
+            i += pointerSize-1
+            continue
+        }
+        if data.Data[i] != other.Data[i] {
+            return false
+        }
+    }
+
+    return true
+}
+
+// Compares the initialized data and the literals of original with the same ones in rebuilt
+func CompareData(original Object, rebuilt Object) []DataCheck {
+    pairs := pairNames(original, rebuilt)
+    datas := map[string]Data{}
+    for _, data := range [][]Data{rebuilt.Variables, rebuilt.Literals, rebuilt.Relocated} {
+        for _, d := range data {
+            if name, ok := pairs[d.Name]; ok {
+                datas[name] = d
+            }
+        }
+    }
+
+    pointerSize := 8
+    if original.Arch == ArchI386 {
+        pointerSize = 4
+    }
+
+    samePointer := func(pointer string, other string) bool {
+        name, addend := splitSymbolOffset(other)
+        if renamed, ok := pairs[name]; ok {
+            name = renamed
+        }
+        return pointer == name + addend
+    }
+
+    checks := []DataCheck{}
+    for _, data := range [][]Data{original.Variables, original.Literals, original.Relocated} {
+        for _, d := range data {
+            other, ok := datas[d.Name]
+            checks = append(checks, DataCheck{d.Name, ok, ok && dataMatches(d, other, pointerSize, samePointer)})
+        }
+    }
+    sort.Slice(checks, func(i, j int) bool {
+        return checks[i].Name < checks[j].Name
+    })
+
+    return checks
+}
+
+// What an executable printed and how it exited
+type RunResult struct {
+    Stdout []byte
+    ExitCode int
+}
+
+// Runs the executable without arguments or input, for at most timeout
+func Run(file string, timeout time.Duration) (RunResult, error) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    cmd := exec.CommandContext(ctx, file)
+    var stdout bytes.Buffer
+    cmd.Stdout = &stdout
+    err := cmd.Run()
+    if ctx.Err() != nil {
+        return RunResult{}, errors.New(file + " didn't finish in time")
+    }
+
+    var exit *exec.ExitError
+    if err != nil && !errors.As(err, &exit) {
+        return RunResult{}, err
+    }
+
+    return RunResult{stdout.Bytes(), cmd.ProcessState.ExitCode()}, nil
+}
+
+// Links object files split from exe (read from input) back into an executable with the C compiler for its architecture,
+// against the libraries it needed. sysroot is where the compiler finds the C runtime and libc, if it isn't empty.
+func Relink(input string, exe Executable, objects []string, output string, sysroot string) error {
+    compiler, args := exe.Object.Arch.Compiler()
+    args = append(args, "-no-pie", "-o", output)
+    if sysroot != "" {
+        args = append(args, "--sysroot=" + sysroot)
+    }
+    args = append(args, objects...)
+    for _, library := range exe.Libraries {
+        // The C runtime comes anyways
+        if library.Status != LibraryFound || library.NeededBy != input || strings.HasPrefix(library.Name, "libc.so") || strings.HasPrefix(library.Name, "ld-linux") {
+            continue
+        }
+        args = append(args, library.Path)
+    }
+
+    out, err := exec.Command(compiler, args...).CombinedOutput()
+    if err != nil {
+        return errors.New(string(out))
+    }
+
+    return nil
+}
@@ -0,0 +1,123 @@
+package disassemble
+
+import (
+	"debug/elf"
+	"sort"
+)
+
+// Where an imported symbol comes from
+type ImportedSymbol struct {
+    Name string
+    // Symbol version from .gnu.version_r, like GLIBC_2.2.5
+    Version string
+    // The library (DT_NEEDED entry, DLL or dylib) that provides it, if known
+    Library string
+}
+
+func (s ImportedSymbol) String() string {
+    if s.Version == "" {
+        return s.Name
+    }
+
+    return s.Name + "@" + s.Version
+}
+
+// Every undefined symbol in .dynsym, with the version and library .gnu.version_r says it needs
+func GetImportedSymbols(file string) ([]ImportedSymbol, error) {
+    f, err := elf.Open(file)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    symbols, err := f.DynamicSymbols()
+    if err == elf.ErrNoSymbols {
+        return []ImportedSymbol{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    imports := make([]ImportedSymbol, 0, len(symbols))
+    for _, sym := range symbols {
+        if sym.Section != elf.SHN_UNDEF || sym.Name == "" {
+            continue
+        }
+
+        imports = append(imports, ImportedSymbol{sym.Name, sym.Version, sym.Library})
+    }
+
+    return imports, nil
+}
+
+// Unversioned imports don't say where they come from, so they are looked up in the .dynsym of the libraries,
+// in the order ld.so would search them
+func AttributeImports(imports []ImportedSymbol, libraries []Library) []ImportedSymbol {
+    defined := map[string]string{}
+    for _, library := range libraries {
+        if library.Status != LibraryFound {
+            continue
+        }
+        f, err := elf.Open(library.Path)
+        if err != nil {
+            continue
+        }
+        symbols, _ := f.DynamicSymbols()
+        f.Close()
+
+        for _, sym := range symbols {
+            if sym.Section == elf.SHN_UNDEF || sym.Name == "" {
+                continue
+            }
+            if _, ok := defined[sym.Name]; !ok {
+                defined[sym.Name] = library.Name
+            }
+        }
+    }
+
+    attributed := make([]ImportedSymbol, 0, len(imports))
+    for _, imp := range imports {
+        if imp.Library == "" {
+            imp.Library = defined[imp.Name]
+        }
+        attributed = append(attributed, imp)
+    }
+
+    return attributed
+}
+
+func FindImport(imports []ImportedSymbol, name string) (ImportedSymbol, bool) {
+    for _, imp := range imports {
+        if imp.Name == name {
+            return imp, true
+        }
+    }
+
+    return ImportedSymbol{}, false
+}
+
+// Which libraries whatever Output would write needs to be linked against, and for which symbols.
+// Symbols that can't be attributed to a library are under "".
+func (o Object) NeededLibraries(symbols []string, imports []ImportedSymbol) map[string][]ImportedSymbol {
+    o = o.Trim()
+    symbols = o.TrimSymbols(symbols)
+    symbols = o.AddUnusedSymbols(symbols)
+    symbols = append(symbols, o.undefinedPointers(symbols)...)
+
+    needed := map[string][]ImportedSymbol{}
+    for _, symbol := range symbols {
+        imp, ok := FindImport(imports, symbol)
+        if !ok {
+            imp = ImportedSymbol{symbol, "", ""}
+        }
+        needed[imp.Library] = append(needed[imp.Library], imp)
+    }
+
+    for _, imps := range needed {
+        sort.Slice(imps, func(i, j int) bool {
+            return imps[i].Name < imps[j].Name
+        })
+    }
+
+    return needed
+}
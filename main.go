@@ -3,25 +3,530 @@ package main
 import (
     "os"
     "fmt"
+    "path/filepath"
+    "regexp"
+    "bytes"
+    "time"
+    "strconv"
+    "sort"
+    "strings"
     "github.com/IonutParau/unld/disassemble"
 )
 
+func printNeededLibraries(file string, needed map[string][]disassemble.ImportedSymbol) {
+    libraries := make([]string, 0, len(needed))
+    for library := range needed {
+        libraries = append(libraries, library)
+    }
+    if len(libraries) == 0 {
+        return
+    }
+    sort.Strings(libraries)
+
+    fmt.Printf("%s needs:\n", file)
+    for _, library := range libraries {
+        names := make([]string, 0, len(needed[library]))
+        for _, imp := range needed[library] {
+            names = append(names, imp.String())
+        }
+
+        if library == "" {
+            // Defined by another extracted object, or by none of the libraries that were found
+            library = "(unknown)"
+        }
+        fmt.Printf("\t%s: %s\n", library, strings.Join(names, ", "))
+    }
+}
+
+func compileRegex(pattern string) *regexp.Regexp {
+    re, err := regexp.Compile(pattern)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+
+    return re
+}
+
+// Says what a pattern selected, unless it was just the symbol it named
+func reportMatches(flag string, pattern string, matched []string) {
+    if len(matched) == 0 {
+        fmt.Printf("%s %s: nothing matched\n", flag, pattern)
+        return
+    }
+    if len(matched) == 1 && matched[0] == pattern {
+        return
+    }
+
+    fmt.Printf("%s %s: %s\n", flag, pattern, strings.Join(matched, ", "))
+}
+
+// Mangled names are what -a and the object files use, the demangled ones are only for reading
+func printFunctions(o disassemble.Object, section string) {
+    for _, name := range o.MatchFunctions("*", section) {
+        if demangled := disassemble.Demangle(name); demangled != name {
+            fmt.Printf("%s\t%s\n", name, demangled)
+        } else {
+            fmt.Println(name)
+        }
+    }
+}
+
+// The stubs go next to the object, like app_hooks.c for app.o
+func writeHookStubs(o disassemble.Object, file string, hooked []disassemble.AssemblyFunction) {
+    stubs := strings.TrimSuffix(file, filepath.Ext(file)) + "_hooks.c"
+    if err := o.WriteHookStubs(stubs, file, hooked); err != nil {
+        fmt.Println(err)
+        return
+    }
+
+    names := make([]string, 0, len(hooked))
+    for _, fun := range hooked {
+        names = append(names, fun.Name)
+    }
+    fmt.Printf("%s has stubs for %s\n", stubs, strings.Join(names, ", "))
+}
+
+func printLibraries(exe disassemble.Executable) {
+    if exe.Libraries == nil {
+        for _, file := range exe.Files {
+            fmt.Println(file)
+        }
+        return
+    }
+
+    if exe.Interpreter != "" {
+        fmt.Printf("interpreter => %s\n", exe.Interpreter)
+    }
+    for _, runtime := range exe.Runtime {
+        fmt.Printf("statically linked => %s\n", runtime)
+    }
+    for _, library := range exe.Libraries {
+        switch library.Status {
+        case disassemble.LibraryFound:
+            fmt.Printf("%s => %s\n", library.Name, library.Path)
+        case disassemble.LibraryIncompatible:
+            fmt.Printf("%s => %s (incompatible, needed by %s)\n", library.Name, library.Path, library.NeededBy)
+        default:
+            fmt.Printf("%s => not found (needed by %s)\n", library.Name, library.NeededBy)
+        }
+    }
+}
+
+// unld signatures [output] [archives...]
+func makeSignatures(args []string) {
+    if len(args) < 2 {
+        fmt.Printf("Usage: %s signatures [output] [archives or objects...]\n", os.Args[0])
+        os.Exit(1)
+    }
+
+    arch, signatures, err := disassemble.GenerateSignatures(args[1:])
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    err = disassemble.WriteSignatures(args[0], arch, signatures)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    fmt.Printf("%d %s signatures written to %s\n", len(signatures), arch, args[0])
+}
+
+// unld patch [executable] [function] [object] [output]
+func patch(args []string) {
+    if len(args) != 4 {
+        fmt.Printf("Usage: %s patch [executable] [function] [object] [output]\n", os.Args[0])
+        os.Exit(1)
+    }
+
+    result, err := disassemble.Patch(args[0], args[1], args[2], args[3])
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    if result.InPlace {
+        fmt.Printf("%s: %d of its %d bytes at 0x%x replaced\n", result.Function, result.Length, result.Size, result.Address)
+    } else {
+        fmt.Printf("%s: %d bytes at 0x%x in a new segment, jumped to from 0x%x\n", result.Function, result.Length, result.Location, result.Address)
+    }
+}
+
+// Like -o, but with everything the object needs defined by some other object
+func writeVerifyObject(o disassemble.Object, base disassemble.Object, exe disassemble.Executable, file string) error {
+    necessary := o.AddNecessarySymbols(base, exe.Symbols)
+    return o.ExportReferencedLocals(base).Output(file, exe.Imports, necessary)
+}
+
+// unld verify [executable] [--run] [--sysroot dir] [--keep-runtime] [symbols...]
+func verify(args []string) {
+    if len(args) == 0 {
+        fmt.Printf("Usage: %s verify [executable] [--run] [--sysroot dir] [--keep-runtime] [symbols...]\n", os.Args[0])
+        os.Exit(1)
+    }
+    run := false
+    options := disassemble.LoadOptions{}
+    patterns := []string{}
+    for i := 1; i < len(args); i++ {
+        if args[i] == "--run" {
+            run = true
+        } else if args[i] == "--sysroot" && i+1 < len(args) {
+            options.Sysroot = args[i+1]
+            i++
+        } else if args[i] == "--keep-runtime" {
+            options.KeepRuntime = true
+        } else {
+            patterns = append(patterns, args[i])
+        }
+    }
+
+    ok, err := verifyExecutable(args[0], patterns, run, options)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    if !ok {
+        os.Exit(1)
+    }
+}
+
+// Splits the executable, relinks it and prints how each function came out. False if anything differs
+// Both executables are read with options, so the runtime is split off the same way and libraries come from the same sysroot.
+func verifyExecutable(input string, patterns []string, run bool, options disassemble.LoadOptions) (bool, error) {
+    exe, err := disassemble.LoadExecutable(input, options)
+    if err != nil {
+        return false, err
+    }
+    base := exe.Object
+
+    dir, err := os.MkdirTemp("", "unld_verify_")
+    if err != nil {
+        return false, err
+    }
+    defer os.RemoveAll(dir)
+
+    // The selection goes into its own object, and everything else, data included, into another one
+    checked := base
+    rest := base
+    for _, name := range base.MatchData("*") {
+        rest = rest.IncludeGlobal(name)
+    }
+    objects := []string{}
+    if len(patterns) > 0 {
+        checked = base.Empty()
+        for _, pattern := range patterns {
+            matched := base.MatchFunctions(pattern, ".text")
+            for _, name := range matched {
+                checked = checked.TakeSymbolFrom(name, ".text", base)
+                rest = rest.RemoveSymbol(name, ".text")
+            }
+            reportMatches("verify", pattern, matched)
+        }
+        selected := filepath.Join(dir, "selected.o")
+        if err := writeVerifyObject(checked, base, exe, selected); err != nil {
+            return false, err
+        }
+        objects = append(objects, selected)
+    }
+    others := filepath.Join(dir, "rest.o")
+    if err := writeVerifyObject(rest, base, exe, others); err != nil {
+        return false, err
+    }
+    objects = append(objects, others)
+
+    rebuilt := filepath.Join(dir, "rebuilt")
+    if err := disassemble.Relink(input, exe, objects, rebuilt, options.Sysroot); err != nil {
+        return false, fmt.Errorf("Relinking failed:\n%s", err)
+    }
+    rebuiltExe, err := disassemble.LoadExecutable(rebuilt, options)
+    if err != nil {
+        return false, err
+    }
+
+    matching := 0
+    checks := disassemble.CompareFunctions(checked, rebuiltExe.Object)
+    for _, check := range checks {
+        switch {
+        case check.Matches():
+            matching++
+            fmt.Printf("ok\t%s\n", check.Name)
+        case !check.Found:
+            fmt.Printf("missing\t%s\n", check.Name)
+        default:
+            fmt.Printf("differs\t%s: instruction %d is `%s`, but `%s` when rebuilt\n", check.Name, check.Line, check.Original, check.Rebuilt)
+        }
+    }
+    fmt.Printf("%d of %d functions match\n", matching, len(checks))
+    ok := matching == len(checks)
+
+    for _, check := range disassemble.CompareData(checked, rebuiltExe.Object) {
+        if !check.Found {
+            fmt.Printf("missing\t%s (data)\n", check.Name)
+            ok = false
+        } else if !check.Matches {
+            fmt.Printf("differs\t%s (data)\n", check.Name)
+            ok = false
+        }
+    }
+
+    if !run {
+        return ok, nil
+    }
+    original, err := disassemble.Run(input, 10 * time.Second)
+    if err != nil {
+        return false, err
+    }
+    again, err := disassemble.Run(rebuilt, 10 * time.Second)
+    if err != nil {
+        return false, err
+    }
+    same := true
+    if !bytes.Equal(original.Stdout, again.Stdout) {
+        fmt.Println("The rebuilt executable printed something else")
+        same = false
+    }
+    if original.ExitCode != again.ExitCode {
+        fmt.Printf("The rebuilt executable exited with %d instead of %d\n", again.ExitCode, original.ExitCode)
+        same = false
+    }
+    if same {
+        fmt.Println("The rebuilt executable printed the same and exited the same way")
+    }
+
+    return ok && same, nil
+}
+
+// The one function the pattern selects in the executable
+func findFunction(o disassemble.Object, pattern string) (disassemble.AssemblyFunction, error) {
+    for _, section := range o.Sections {
+        matched := o.MatchFunctions(pattern, section.Name)
+        if len(matched) > 1 {
+            return disassemble.AssemblyFunction{}, fmt.Errorf("%s matches %s, which one should it be?", pattern, strings.Join(matched, ", "))
+        }
+        if len(matched) == 1 {
+            fun, _ := o.FindFunction(matched[0], section.Name)
+            return fun, nil
+        }
+    }
+
+    return disassemble.AssemblyFunction{}, fmt.Errorf("%s isn't a function of the executable", pattern)
+}
+
+// unld compare [executable] [function] [object or executable] [--sysroot dir] [--keep-runtime]
+func compare(args []string) {
+    options := disassemble.LoadOptions{}
+    files := []string{}
+    for i := 0; i < len(args); i++ {
+        if args[i] == "--sysroot" && i+1 < len(args) {
+            options.Sysroot = args[i+1]
+            i++
+        } else if args[i] == "--keep-runtime" {
+            options.KeepRuntime = true
+        } else {
+            files = append(files, args[i])
+        }
+    }
+    if len(files) != 3 {
+        fmt.Printf("Usage: %s compare [executable] [function] [object or executable] [--sysroot dir] [--keep-runtime]\n", os.Args[0])
+        os.Exit(1)
+    }
+    args = files
+
+    exe, err := disassemble.LoadExecutable(args[0], options)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    fun, err := findFunction(exe.Object, args[1])
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+
+    var other disassemble.Object
+    if disassemble.IsObjectFile(args[2]) {
+        other.Sections, err = disassemble.GetDumpedObject(args[2])
+    } else {
+        var otherExe disassemble.Executable
+        otherExe, err = disassemble.LoadExecutable(args[2], options)
+        other = otherExe.Object
+    }
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    otherFun, renames, ok := disassemble.FindPairedFunction(exe.Object, other, fun.Name)
+    if !ok {
+        fmt.Printf("%s doesn't have %s\n", args[2], fun.Name)
+        os.Exit(1)
+    }
+
+    diff := disassemble.DiffPairedFunctions(fun, otherFun, renames)
+    width := len(args[0]) + len(fun.Name) + 3
+    for _, line := range diff {
+        width = max(width, len(line.Left))
+    }
+    fmt.Printf("%-*s   %s\n", width, fun.Name + " (" + args[0] + ")", otherFun.Name + " (" + args[2] + ")")
+    changed := 0
+    for _, line := range diff {
+        if line.Kind != ' ' {
+            changed++
+        }
+        fmt.Printf("%-*s %c %s\n", width, line.Left, line.Kind, line.Right)
+    }
+
+    if changed > 0 {
+        fmt.Printf("%d instructions differ\n", changed)
+        os.Exit(1)
+    }
+    fmt.Println("identical")
+}
+
+// unld diff [old executable] [new executable] [--sysroot dir] [--keep-runtime]
+func diff(args []string) {
+    options := disassemble.LoadOptions{}
+    files := []string{}
+    for i := 0; i < len(args); i++ {
+        if args[i] == "--sysroot" && i+1 < len(args) {
+            options.Sysroot = args[i+1]
+            i++
+        } else if args[i] == "--keep-runtime" {
+            options.KeepRuntime = true
+        } else {
+            files = append(files, args[i])
+        }
+    }
+    if len(files) != 2 {
+        fmt.Printf("Usage: %s diff [old executable] [new executable] [--sysroot dir] [--keep-runtime]\n", os.Args[0])
+        os.Exit(1)
+    }
+    args = files
+
+    old, err := disassemble.LoadExecutable(args[0], options)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    new, err := disassemble.LoadExecutable(args[1], options)
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+
+    diff := disassemble.DiffObjects(old.Object, old.Symbols, new.Object, new.Symbols)
+    for _, name := range diff.RemovedFunctions {
+        fmt.Printf("removed\t%s\n", name)
+    }
+    for _, name := range diff.AddedFunctions {
+        fmt.Printf("added\t%s\n", name)
+    }
+    for _, change := range diff.ChangedFunctions {
+        name := change.Old
+        if change.Renamed() {
+            name += " -> " + change.New
+        }
+        if change.Changed == 0 {
+            fmt.Printf("renamed\t%s\n", name)
+            continue
+        }
+        fmt.Printf("changed\t%s: %d instructions", name, change.Changed)
+        if len(change.AddedCalls) > 0 {
+            fmt.Printf(", calls %s now", strings.Join(change.AddedCalls, ", "))
+        }
+        if len(change.RemovedCalls) > 0 {
+            fmt.Printf(", doesn't call %s anymore", strings.Join(change.RemovedCalls, ", "))
+        }
+        fmt.Println()
+    }
+
+    for _, name := range diff.RemovedData {
+        fmt.Printf("removed\t%s (data)\n", name)
+    }
+    for _, name := range diff.AddedData {
+        fmt.Printf("added\t%s (data)\n", name)
+    }
+    for _, change := range diff.ChangedData {
+        name := change.Old
+        if change.Renamed() {
+            name += " -> " + change.New
+        }
+        if change.Changed {
+            fmt.Printf("changed\t%s (data)\n", name)
+        } else {
+            fmt.Printf("renamed\t%s (data)\n", name)
+        }
+    }
+
+    if !diff.Empty() {
+        os.Exit(1)
+    }
+    fmt.Println("identical")
+}
+
 func main() {
+    if len(os.Args) > 1 && os.Args[1] == "signatures" {
+        makeSignatures(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "verify" {
+        verify(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "diff" {
+        diff(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "compare" {
+        compare(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "patch" {
+        patch(os.Args[2:])
+        return
+    }
+
     if len(os.Args) == 1 {
-        fmt.Printf("Usage: %s [options]\n", os.Args[0])
+        fmt.Printf("Usage: %s [executable] [options]\n", os.Args[0])
+        fmt.Printf("       %s signatures [output] [archives...] - Makes signatures for --signatures out of static libraries\n", os.Args[0])
+        fmt.Printf("       %s verify [executable] [--run] [--sysroot dir] [--keep-runtime] [symbols...] - Splits the executable (into the functions matching [symbols] and the rest) and relinks it, to check the code comes out the same\n", os.Args[0])
+        fmt.Printf("       %s diff [old executable] [new executable] [--sysroot dir] [--keep-runtime] - Shows which functions and data were added, removed or changed\n", os.Args[0])
+        fmt.Printf("       %s compare [executable] [function] [object or executable] [--sysroot dir] [--keep-runtime] - Shows the function next to the same one from an object file or relinked executable\n", os.Args[0])
+        fmt.Printf("       %s patch [executable] [function] [object] [output] - Writes the function from the object file over the executable's\n", os.Args[0])
         options := []string{
             "--empty - Empties the current object context",
             "--section [section] - Switches the current section to section. By default, the current section is .text",
             "-s - Alias for --section",
             "--add [symbol] - Adds [symbol] from the current section to the current object context",
+            "\t[symbol] can be a glob (log_*), or an address range (0x1130-0x1200) for the functions starting in it. What a pattern matched is printed",
+            "\tStatic functions that share their name are called name.address, and name@address selects one of them",
+            "\tC++ symbols can be demangled (net::Socket::send), globs (net::Socket::*) or a class, which also adds its vtable and typeinfo",
             "-a - Alias for --add",
-            "--remove [symbol] - Removes [symbol] from the current section fom the current object context",
+            "--add-regex [regex] - Adds every function from the current section whose name matches [regex]",
+            "--remove [symbol] - Removes [symbol] from the current section fom the current object context. Takes the same names as --add",
             "-r - Alias for --remove",
+            "--remove-regex [regex] - Removes every function from the current section whose name matches [regex]",
             "--global [global] - Makes the object file define the global instead of defining it as an external symbol",
             "-g - Alias for --global",
             "--output [file] - Outputs an object file generated from the current object context and puts it in [file].",
             "\tThis also resets the current object context to contain all sections and symbols from the executable (except the insignificant ones)",
             "-o - Alias for --output",
+            "--local [symbol] - Makes [symbol] local to the object file, like a static function. Takes the same patterns as --add, and works on functions and globals in the current object context",
+            "--weak [symbol] - Makes [symbol] weak, so another object file can define it instead",
+            "--hidden [symbol] - Gives [symbol] hidden visibility, so it isn't exported from the shared library or executable it ends up in",
+            "--export-referenced - Makes everything that nothing else in the executable uses local in the object files written after this. main stays global",
+            "--rename [old]=[new] - Renames the symbol [old] to [new] in every object file written after this, along with every reference to it",
+            "--prefix [prefix] - Puts [prefix] in front of every symbol from the executable in the object files written after this, so objects from different executables don't collide",
+            "\tSymbols from libraries keep their names, and --rename takes precedence",
+            "--hook [symbol] - Takes [symbol] out of the current object context so another object file can replace it, and writes a C file with a stub for each one next to the next output",
+            "\tFor -o app.o, that's app_hooks.c. Takes the same patterns as --add",
+            "--trace [symbol] - Logs every call to [symbol] in the next object file, through unld_trace_enter and unld_trace_exit, or to stderr if nothing defines them",
+            "\tTakes the same patterns as --add, and works on the functions in the current object context. Only for x86-64 ELF",
+            "--trace-args [n] - Logs the first [n] integer arguments of traced functions, instead of as many as they seem to take",
+            "--list - Prints the functions in the current section of the current object context, and their demangled names",
+            "--libraries - Prints the libraries the executable needs and where they were found",
+            "--sysroot [dir] - Looks for libraries and the interpreter in [dir] instead of the host system. This applies to the whole command, wherever it is",
+            "--signatures [file] - Names the functions of stripped executables that match the signatures in [file]. Can be given more than once",
+            "--keep-runtime - Treats the libc and libgcc code in static executables like any other code, instead of turning it into external symbols",
         }
         for _, option := range options {
             fmt.Printf("\t%s\n", option)
@@ -30,40 +535,40 @@ func main() {
     }
 
     input := os.Args[1]
-    files, err := disassemble.GetLinkedFiles(input)
-    if err != nil {
-        fmt.Println(err)
-        os.Exit(1)
-    }
-    sections, err := disassemble.GetDumpedAssembly(input)
-    if err != nil {
-        fmt.Println(err)
-        os.Exit(1)
-    }
-    symbols := disassemble.FindExternSymbols(sections)
-    sections = disassemble.RemoveJunk(sections)
 
-    rodata, err := disassemble.GetDumpedReadonlyData(input)
-    if err != nil {
-        fmt.Println(err)
-        os.Exit(1)
+    // These change how the executable is read, so they have to be known before loading
+    options := disassemble.LoadOptions{}
+    for i := 2; i < len(os.Args); i++ {
+        if os.Args[i] == "--sysroot" && i+1 < len(os.Args) {
+            options.Sysroot = os.Args[i+1]
+            i++
+        } else if os.Args[i] == "--signatures" && i+1 < len(os.Args) {
+            options.Signatures = append(options.Signatures, os.Args[i+1])
+            i++
+        } else if os.Args[i] == "--keep-runtime" {
+            options.KeepRuntime = true
+        }
     }
-    
-    globaldata, err := disassemble.GetDumpedGlobalData(input)
+
+    exe, err := disassemble.LoadExecutable(input, options)
     if err != nil {
         fmt.Println(err)
         os.Exit(1)
     }
-    
-    sections = disassemble.MonkeyPatchAssembly(sections, globaldata, rodata, symbols)
+    symbols := exe.Symbols
 
-    objectContext := disassemble.Object{
-        Globals: globaldata,
-        Literals: rodata,
-        Sections: sections,
-    }
+    objectContext := exe.Object
     baseContext := objectContext
     currentSection := ".text"
+    // Applied when writing, since everything is selected by its original name
+    renames := map[string]string{}
+    prefix := ""
+    exportReferenced := false
+    // Functions the next object file leaves for a replacement to define
+    hooked := []disassemble.AssemblyFunction{}
+    // Functions the next object file logs calls to, and how many arguments (-1 for however many they seem to take)
+    traced := []string{}
+    traceArguments := -1
 
     for i := 2; i < len(os.Args); i++ {
         arg := os.Args[i]
@@ -73,42 +578,197 @@ func main() {
             continue
         }
 
+        if arg == "--sysroot" || arg == "--signatures" {
+            i++
+            continue
+        }
+
+        if arg == "--keep-runtime" {
+            continue
+        }
+
+        if arg == "--libraries" {
+            printLibraries(exe)
+            continue
+        }
+
         if arg == "--section" || arg == "-s" {
             currentSection = os.Args[i+1]
             i++
             continue
         }
 
-        if arg == "--add" || arg == "-a" {
+        if arg == "--list" {
+            printFunctions(objectContext, currentSection)
+            continue
+        }
+
+        if arg == "--add" || arg == "-a" || arg == "--add-regex" {
             symbol := os.Args[i+1]
             i++
-            objectContext = objectContext.TakeSymbolFrom(symbol, currentSection, baseContext)
+            var matched []string
+            if arg == "--add-regex" {
+                matched = baseContext.MatchFunctionsRegex(compileRegex(symbol), currentSection)
+            } else {
+                matched = baseContext.MatchFunctions(symbol, currentSection)
+            }
+            for _, name := range matched {
+                objectContext = objectContext.TakeSymbolFrom(name, currentSection, baseContext)
+            }
+            if arg != "--add-regex" {
+                // A whole class comes with its vtable and typeinfo
+                for _, name := range baseContext.MatchClassData(symbol) {
+                    objectContext = objectContext.IncludeGlobal(name)
+                    matched = append(matched, name)
+                }
+            }
+            reportMatches(arg, symbol, matched)
             continue
         }
         
-        if arg == "--remove" || arg == "-r" {
+        if arg == "--remove" || arg == "-r" || arg == "--remove-regex" {
             symbol := os.Args[i+1]
             i++
-            objectContext = objectContext.RemoveSymbol(symbol, currentSection)
+            var matched []string
+            if arg == "--remove-regex" {
+                matched = objectContext.MatchFunctionsRegex(compileRegex(symbol), currentSection)
+            } else {
+                matched = objectContext.MatchFunctions(symbol, currentSection)
+            }
+            for _, name := range matched {
+                objectContext = objectContext.RemoveSymbol(name, currentSection)
+            }
+            reportMatches(arg, symbol, matched)
             continue
         }
 
         if arg == "--global" || arg == "-g" {
             symbol := os.Args[i+1]
             i++
-            objectContext = objectContext.IncludeGlobal(symbol)
+            matched := objectContext.MatchData(symbol)
+            for _, name := range matched {
+                objectContext = objectContext.IncludeGlobal(name)
+            }
+            reportMatches(arg, symbol, matched)
         }
         
         if arg == "--output" || arg == "-o" {
             file := os.Args[i+1]
             i++
-            // Output needs the linked files (obviously)
-            err := objectContext.Output(file, files, objectContext.AddNecessarySymbols(baseContext, symbols))
+            // Output needs to know where the imports come from (obviously)
+            necessary := objectContext.AddNecessarySymbols(baseContext, symbols)
+            // Static functions other objects call can't stay local
+            output := objectContext.ExportReferencedLocals(baseContext)
+            if exportReferenced {
+                output = output.LocalizeUnreferenced(baseContext)
+            }
+            if prefix != "" || len(renames) > 0 {
+                names := map[string]string{}
+                if prefix != "" {
+                    names = baseContext.PrefixNames(prefix)
+                }
+                for old, renamed := range renames {
+                    names[old] = renamed
+                }
+                output = output.Rename(names)
+                necessary = disassemble.RenameSymbols(necessary, names)
+                traced = disassemble.RenameSymbols(traced, names)
+                // The stubs have to define what the object calls now
+                hooked = disassemble.RenamePatchedFunctions(hooked, names)
+            }
+            if len(traced) > 0 {
+                output, err = output.Trace(traced, traceArguments)
+                if err != nil {
+                    fmt.Println(err)
+                    os.Exit(1)
+                }
+                necessary = append(necessary, disassemble.TraceImports...)
+            }
+            err := output.Output(file, exe.Imports, necessary)
             if err != nil {
                 fmt.Println(err)
                 os.Exit(1)
             }
+            printNeededLibraries(file, output.NeededLibraries(necessary, exe.Imports))
+            if len(hooked) > 0 {
+                writeHookStubs(output, file, hooked)
+            }
             objectContext = baseContext
+            hooked = hooked[:0]
+            traced = traced[:0]
+            continue
+        }
+
+        if arg == "--local" || arg == "--weak" || arg == "--hidden" {
+            symbol := os.Args[i+1]
+            i++
+            matched := append(objectContext.MatchFunctions(symbol, currentSection), objectContext.MatchData(symbol)...)
+            objectContext = objectContext.SetSymbolInfo(matched, func(info *disassemble.SymbolInfo) {
+                switch arg {
+                case "--local":
+                    info.Binding = disassemble.BindingLocal
+                case "--weak":
+                    info.Binding = disassemble.BindingWeak
+                case "--hidden":
+                    info.Visibility = disassemble.VisibilityHidden
+                }
+            })
+            reportMatches(arg, symbol, matched)
+            continue
+        }
+
+        if arg == "--hook" {
+            symbol := os.Args[i+1]
+            i++
+            matched := objectContext.MatchFunctions(symbol, currentSection)
+            for _, name := range matched {
+                fun, _ := objectContext.FindFunction(name, currentSection)
+                hooked = append(hooked, fun)
+                objectContext = objectContext.RemoveSymbol(name, currentSection)
+            }
+            reportMatches(arg, symbol, matched)
+            continue
+        }
+
+        if arg == "--trace" {
+            symbol := os.Args[i+1]
+            i++
+            matched := objectContext.MatchFunctions(symbol, currentSection)
+            traced = append(traced, matched...)
+            reportMatches(arg, symbol, matched)
+            continue
+        }
+
+        if arg == "--trace-args" {
+            n, err := strconv.Atoi(os.Args[i+1])
+            if err != nil || n < 0 {
+                fmt.Printf("--trace-args takes a number of arguments, not %s\n", os.Args[i+1])
+                os.Exit(1)
+            }
+            i++
+            traceArguments = n
+            continue
+        }
+
+        if arg == "--export-referenced" {
+            exportReferenced = true
+            continue
+        }
+
+        if arg == "--rename" {
+            old, renamed, ok := strings.Cut(os.Args[i+1], "=")
+            if !ok || old == "" || renamed == "" {
+                fmt.Printf("--rename takes OLD=NEW, not %s\n", os.Args[i+1])
+                os.Exit(1)
+            }
+            i++
+            renames[old] = renamed
+            continue
+        }
+
+        if arg == "--prefix" {
+            prefix = os.Args[i+1]
+            i++
             continue
         }
     }
@@ -1,8 +1,11 @@
 #!/usr/bin/env python3
+import filecmp
 import os
+import shutil
 
 exe = "unld"
 cc = "gcc"
+cxx = "g++"
 
 print("Testing basic extraction")
 if os.system(f"{cc} -o test testfiles/test.c"):
@@ -28,3 +31,737 @@ os.remove("libadd.o")
 os.remove("test")
 os.remove("rebuilt")
 print("Basic extration works")
+
+print("Testing 32-bit x86 extraction")
+if os.system(f"{cc} -m32 -o test testfiles/test.c"):
+    print("Failed to generate 32-bit test executable")
+    exit(1)
+if os.system(f"./{exe} test --empty -a add -a main -g x -o libmain32.o") or os.system(f"./{exe} test --empty -a add -a main -g x -o again32.o"):
+    os.remove("test")
+    print("Failed to unlink 32-bit executable")
+    exit(1)
+# The externs come out of maps, which must not change the object from one run to the next
+if not filecmp.cmp("libmain32.o", "again32.o", shallow=False):
+    os.remove("libmain32.o")
+    os.remove("again32.o")
+    os.remove("test")
+    print("Unlinking the same executable twice gave different objects")
+    exit(1)
+if os.system(f"{cc} -m32 -o rebuilt libmain32.o"):
+    os.remove("libmain32.o")
+    os.remove("again32.o")
+    os.remove("test")
+    print("Failed to rebuild 32-bit executable")
+    exit(1)
+if os.popen("./rebuilt").read() != os.popen("./test").read():
+    os.remove("libmain32.o")
+    os.remove("again32.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt 32-bit binary does not work")
+    exit(1)
+
+os.remove("libmain32.o")
+os.remove("again32.o")
+os.remove("test")
+os.remove("rebuilt")
+print("32-bit x86 extraction works")
+
+print("Testing pattern selection")
+if os.system(f"{cc} -o test testfiles/logging.c testfiles/liblogging.c"):
+    print("Failed to generate logging test executable")
+    exit(1)
+if os.system(f"./{exe} test --empty -a 'log_*' -g logState -o liblogging.o"):
+    os.remove("test")
+    print("Failed to unlink logging executable")
+    exit(1)
+if os.system(f"{cc} -o rebuilt testfiles/logging.c liblogging.o"):
+    os.remove("liblogging.o")
+    os.remove("test")
+    print("Failed to rebuild executable with the log_* functions")
+    exit(1)
+if os.system(f"./rebuilt"):
+    os.remove("liblogging.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary does not work")
+    exit(1)
+
+os.remove("liblogging.o")
+os.remove("test")
+os.remove("rebuilt")
+print("Pattern selection works")
+
+print("Testing hooking")
+if os.system(f"{cc} -o test testfiles/test.c"):
+    print("Failed to generate test executable")
+    exit(1)
+if os.system(f"./{exe} test --empty -a main -a add --hook add -o libmain.o"):
+    os.remove("test")
+    print("Failed to unlink executable")
+    exit(1)
+# Fill in the stub
+with open("libmain_hooks.c") as f:
+    stubs = f.read()
+with open("libmain_hooks.c", "w") as f:
+    f.write(stubs.replace("return 0;", "return a0 * a1;"))
+if os.system(f"{cc} -o rebuilt libmain.o libmain_hooks.c"):
+    os.remove("libmain.o")
+    os.remove("libmain_hooks.c")
+    os.remove("test")
+    print("Failed to rebuild executable with the replacement")
+    exit(1)
+if os.popen("./rebuilt").read().find("5 + 3 = 15") == -1:
+    os.remove("libmain.o")
+    os.remove("libmain_hooks.c")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary does not use the replacement")
+    exit(1)
+
+os.remove("libmain.o")
+os.remove("libmain_hooks.c")
+os.remove("rebuilt")
+# The stubs have to define the prefixed names the object calls
+if os.system(f"./{exe} test --empty -a main -a add --hook add --prefix p_ --rename main=main -o libprefixed.o"):
+    os.remove("test")
+    print("Failed to unlink executable with a prefix")
+    exit(1)
+with open("libprefixed_hooks.c") as f:
+    stubs = f.read()
+with open("libprefixed_hooks.c", "w") as f:
+    f.write(stubs.replace("return 0;", "return a0 * a1;"))
+if os.system(f"{cc} -o rebuilt libprefixed.o libprefixed_hooks.c"):
+    os.remove("libprefixed.o")
+    os.remove("libprefixed_hooks.c")
+    os.remove("test")
+    print("Failed to rebuild executable with the prefixed replacement")
+    exit(1)
+if os.popen("./rebuilt").read().find("5 + 3 = 15") == -1:
+    os.remove("libprefixed.o")
+    os.remove("libprefixed_hooks.c")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary does not use the prefixed replacement")
+    exit(1)
+
+os.remove("libprefixed.o")
+os.remove("libprefixed_hooks.c")
+os.remove("test")
+os.remove("rebuilt")
+print("Hooking works")
+
+print("Testing symbol binding")
+if os.system(f"{cc} -o test testfiles/test.c"):
+    print("Failed to generate test executable")
+    exit(1)
+if os.system(f"./{exe} test --empty -a add -a main -g x --weak add --hidden x -o libbind.o") or os.system(f"./{exe} test --empty -a add -a main --local add -o liblocal.o"):
+    os.remove("test")
+    print("Failed to unlink executable")
+    exit(1)
+# Name -> type, binding and visibility
+symbols = {fields[7]: fields[3:6] for fields in (line.split() for line in os.popen("readelf -sW libbind.o").read().splitlines()) if len(fields) == 8}
+local = {fields[7]: fields[3:6] for fields in (line.split() for line in os.popen("readelf -sW liblocal.o").read().splitlines()) if len(fields) == 8}
+if symbols.get("add", [])[1:2] != ["WEAK"] or symbols.get("x", [])[2:] != ["HIDDEN"] or symbols.get("main", [])[1:2] != ["GLOBAL"] or local.get("add", [])[1:2] != ["LOCAL"]:
+    os.remove("libbind.o")
+    os.remove("liblocal.o")
+    os.remove("test")
+    print("--local, --weak and --hidden didn't end up in the symbol table")
+    exit(1)
+# The weak add gives way to another one
+if os.system(f"{cc} -o rebuilt libbind.o testfiles/strong_add.c") or os.popen("./rebuilt").read().find("5 + 3 = 15") == -1:
+    os.remove("libbind.o")
+    os.remove("liblocal.o")
+    os.remove("test")
+    print("The weak add wasn't overridden")
+    exit(1)
+os.remove("rebuilt")
+if os.system(f"{cc} -o rebuilt liblocal.o") or os.system("./rebuilt"):
+    os.remove("libbind.o")
+    os.remove("liblocal.o")
+    os.remove("test")
+    print("Rebuilt binary with a local add does not work")
+    exit(1)
+
+os.remove("libbind.o")
+os.remove("liblocal.o")
+os.remove("test")
+os.remove("rebuilt")
+print("Symbol binding works")
+
+print("Testing symbol shapes")
+helpers = "testfiles/helper_a.c testfiles/helper_b.c testfiles/helpers.c"
+if os.system(f"{cc} -o test {helpers}") or os.system(f"{cc} -o data testfiles/test.c"):
+    print("Failed to generate test executables")
+    exit(1)
+if os.system(f"./{exe} test --empty -a 'helper*' -a twice -a hundred -a main -o libshapes.o") or os.system(f"./{exe} data --empty -a add -a main -g x -o libdata.o"):
+    os.remove("test")
+    os.remove("data")
+    print("Failed to unlink executables")
+    exit(1)
+# Name -> size, type, binding and visibility
+original = {fields[7]: fields[2:6] for fields in (line.split() for line in os.popen("readelf -sW test").read().splitlines()) if len(fields) == 8}
+symbols = {fields[7]: fields[2:6] for fields in (line.split() for line in os.popen("readelf -sW libshapes.o").read().splitlines()) if len(fields) == 8}
+data = {fields[7]: fields[2:6] for fields in (line.split() for line in os.popen("readelf -sW libdata.o").read().splitlines()) if len(fields) == 8}
+# The static helpers stay local, and the rest keep their sizes
+helpers = [shape for name, shape in symbols.items() if name.startswith("helper.")]
+if len(helpers) != 2 or any(shape[2] != "LOCAL" for shape in helpers) or symbols.get("twice") != original["twice"] or symbols.get("hundred") != original["hundred"] or data.get("x", [])[:3] != ["4", "OBJECT", "GLOBAL"]:
+    os.remove("libshapes.o")
+    os.remove("libdata.o")
+    os.remove("test")
+    os.remove("data")
+    print("The symbols don't have the shapes they had in the executable")
+    exit(1)
+
+os.remove("libshapes.o")
+os.remove("libdata.o")
+os.remove("test")
+os.remove("data")
+print("Symbol shapes work")
+
+print("Testing duplicate names")
+helpers = "testfiles/helper_a.c testfiles/helper_b.c testfiles/helpers.c"
+if os.system(f"{cc} -o test {helpers}"):
+    print("Failed to generate duplicate names test executable")
+    exit(1)
+# helper_a.c is linked first, so its helper has the lower address
+addresses = sorted(int(line.split()[0], 16) for line in os.popen("nm test").read().splitlines() if line.endswith(" t helper"))
+if len(addresses) != 2:
+    os.remove("test")
+    print("The test executable doesn't have two static helpers")
+    exit(1)
+listed = os.popen(f"./{exe} test --list").read().split()
+if f"helper.{addresses[0]:x}" not in listed or f"helper.{addresses[1]:x}" not in listed:
+    os.remove("test")
+    print("The static helpers weren't given their addresses as suffixes")
+    exit(1)
+if os.system(f"./{exe} test --empty -a helper@{addresses[0]:#x} -a twice -o libtwice.o"):
+    os.remove("test")
+    print("Failed to unlink executable with a name@address selector")
+    exit(1)
+names = [line.split()[-1] for line in os.popen("nm libtwice.o").read().splitlines()]
+if f"helper.{addresses[0]:x}" not in names or f"helper.{addresses[1]:x}" in names:
+    os.remove("libtwice.o")
+    os.remove("test")
+    print(f"helper@{addresses[0]:#x} selected the wrong helper")
+    exit(1)
+# The other helper comes from helper_b.c again, which only works if twice kept its own
+if os.system(f"{cc} -o rebuilt libtwice.o testfiles/helper_b.c testfiles/helpers.c"):
+    os.remove("libtwice.o")
+    os.remove("test")
+    print("Failed to rebuild executable with the selected helper")
+    exit(1)
+if os.popen("./rebuilt").read() != os.popen("./test").read():
+    os.remove("libtwice.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary calls the wrong helper")
+    exit(1)
+
+os.remove("libtwice.o")
+os.remove("test")
+os.remove("rebuilt")
+print("Duplicate names work")
+
+print("Testing tracing")
+if os.system(f"{cc} -o test testfiles/test.c"):
+    print("Failed to generate test executable")
+    exit(1)
+if os.system(f"./{exe} test --empty -a add --trace add -o libadd.o"):
+    os.remove("test")
+    print("Failed to unlink executable")
+    exit(1)
+if os.system(f"{cc} -o rebuilt libadd.o testfiles/reconstructed.c"):
+    os.remove("libadd.o")
+    os.remove("test")
+    print("Failed to rebuild executable with the traced function")
+    exit(1)
+# The calls are logged to stderr
+if os.popen("./rebuilt 2>&1").read().find("add(0x5, 0x3)") == -1:
+    os.remove("libadd.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary does not trace its calls")
+    exit(1)
+
+os.remove("libadd.o")
+os.remove("test")
+os.remove("rebuilt")
+print("Tracing works")
+
+print("Testing patching")
+if os.system(f"{cc} -o test testfiles/test.c"):
+    print("Failed to generate test executable")
+    exit(1)
+if os.system(f"{cc} -c -o sub.o testfiles/sub.c"):
+    os.remove("test")
+    print("Failed to compile the replacement")
+    exit(1)
+if os.system(f"./{exe} patch test add sub.o patched"):
+    os.remove("sub.o")
+    os.remove("test")
+    print("Failed to patch executable")
+    exit(1)
+if os.popen("./patched").read().find("5 + 3 = 2") == -1:
+    os.remove("sub.o")
+    os.remove("test")
+    os.remove("patched")
+    print("Patched binary does not use the replacement")
+    exit(1)
+
+os.remove("sub.o")
+os.remove("test")
+os.remove("patched")
+print("Patching works")
+
+print("Testing verification")
+if os.system(f"{cc} -o test testfiles/logging.c testfiles/liblogging.c"):
+    print("Failed to generate logging test executable")
+    exit(1)
+if os.system(f"./{exe} verify test --run 'log_*'"):
+    os.remove("test")
+    print("Rebuilt executable does not match the original")
+    exit(1)
+
+os.remove("test")
+print("Verification works")
+
+print("Testing verification of duplicate names")
+helpers = "testfiles/helper_a.c testfiles/helper_b.c testfiles/helpers.c"
+if os.system(f"{cc} -o test {helpers}") or os.system(f"{cc} -falign-functions=64 -o moved {helpers}"):
+    print("Failed to generate duplicate names test executables")
+    exit(1)
+# Each static helper has to be checked against its own copy
+if os.system(f"./{exe} verify test --run"):
+    os.remove("test")
+    os.remove("moved")
+    print("Rebuilt executable with duplicate names does not match the original")
+    exit(1)
+# Moved, both helpers have other addresses in their names, but the same order
+addresses = [line.split()[0] for line in os.popen("nm test").read().splitlines() if line.endswith(" t helper")]
+for address in addresses:
+    if os.system(f"./{exe} compare test helper@0x{address} moved"):
+        os.remove("test")
+        os.remove("moved")
+        print(f"helper@0x{address} was compared against the wrong helper")
+        exit(1)
+
+os.remove("test")
+os.remove("moved")
+print("Verification of duplicate names works")
+
+print("Testing library attribution")
+if os.system(f"{cc} -shared -fPIC -o liblogging.so testfiles/liblogging.c") or os.system(f"{cc} -o test testfiles/logging.c -L. -llogging -Wl,-rpath,'$ORIGIN'"):
+    print("Failed to generate library test executable")
+    exit(1)
+# The logging functions aren't versioned, so only the library's .dynsym says where they are from
+if os.popen(f"./{exe} test --empty -a main -o libmain.o").read().find("liblogging.so: log_debug") == -1:
+    os.remove("libmain.o")
+    os.remove("liblogging.so")
+    os.remove("test")
+    print("Unversioned imports aren't attributed to their library")
+    exit(1)
+
+os.remove("libmain.o")
+os.remove("liblogging.so")
+os.remove("test")
+print("Library attribution works")
+
+print("Testing thread-locals")
+if os.system(f"{cc} -shared -fPIC -o libtlsshared.so testfiles/tls_shared.c") or os.system(f"{cc} -o test testfiles/tls_main.c testfiles/tls.c -L. -ltlsshared -Wl,-rpath,'$ORIGIN'"):
+    print("Failed to generate thread-local test executable")
+    exit(1)
+if os.system(f"./{exe} test --empty -a main -a bump -a bump_shared -g counter -o libtls.o"):
+    os.remove("libtlsshared.so")
+    os.remove("test")
+    print("Failed to unlink thread-local test executable")
+    exit(1)
+if os.system(f"{cc} -o rebuilt libtls.o -L. -ltlsshared -Wl,-rpath,'$ORIGIN'"):
+    os.remove("libtls.o")
+    os.remove("libtlsshared.so")
+    os.remove("test")
+    print("Failed to rebuild thread-local test executable")
+    exit(1)
+if os.popen("./rebuilt").read() != os.popen("./test").read():
+    os.remove("libtls.o")
+    os.remove("libtlsshared.so")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary does not use the same thread-locals")
+    exit(1)
+os.remove("libtls.o")
+os.remove("test")
+os.remove("rebuilt")
+
+# Accesses that can't be turned back into symbols have to be refused, not copied with the old offsets
+unsupported = [
+    f"{cc} -shared -fPIC -o test testfiles/tls.c -L. -ltlsshared",
+    f"{cc} -shared -fPIC -mtls-dialect=gnu2 -o test testfiles/tls.c -L. -ltlsshared",
+    f"{cc} -m32 -o test testfiles/tls_main.c testfiles/tls.c testfiles/tls_shared.c",
+]
+for build in unsupported:
+    if os.system(build):
+        os.remove("libtlsshared.so")
+        print("Failed to generate thread-local test executable")
+        exit(1)
+    if os.system(f"./{exe} test --empty -a bump -o libtls.o") == 0:
+        os.remove("libtls.o")
+        os.remove("libtlsshared.so")
+        os.remove("test")
+        print(f"Unsupported thread-local accesses were extracted ({build})")
+        exit(1)
+    os.remove("test")
+
+os.remove("libtlsshared.so")
+print("Thread-locals work")
+
+print("Testing constructors")
+if os.system(f"{cc} -o test testfiles/ctors.c"):
+    print("Failed to generate constructor test executable")
+    exit(1)
+# Like lld leaves them, the slots are zero on disk and only the RELATIVE relocations have the pointers
+os.system("objcopy --dump-section .init_array=init_array.bin --dump-section .fini_array=fini_array.bin test")
+for dumped in ["init_array.bin", "fini_array.bin"]:
+    with open(dumped, "r+b") as f:
+        f.write(bytes(len(f.read())))
+if os.system("objcopy --update-section .init_array=init_array.bin --update-section .fini_array=fini_array.bin test"):
+    os.remove("init_array.bin")
+    os.remove("fini_array.bin")
+    os.remove("test")
+    print("Failed to zero the constructor slots")
+    exit(1)
+os.remove("init_array.bin")
+os.remove("fini_array.bin")
+if os.system(f"./{exe} test --empty -a main -a setup -a teardown -o libctors.o"):
+    os.remove("test")
+    print("Failed to unlink constructor test executable")
+    exit(1)
+if os.system(f"{cc} -o rebuilt libctors.o"):
+    os.remove("libctors.o")
+    os.remove("test")
+    print("Failed to rebuild constructor test executable")
+    exit(1)
+if os.popen("./rebuilt").read() != "setup\nmain\nteardown\n":
+    os.remove("libctors.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary doesn't run the constructors")
+    exit(1)
+
+os.remove("libctors.o")
+os.remove("test")
+os.remove("rebuilt")
+print("Constructors work")
+
+print("Testing jumps into other functions")
+if os.system(f"{cc} -o test testfiles/jumps.s"):
+    print("Failed to generate jumps test executable")
+    exit(1)
+if os.system(f"./{exe} test --empty -a bar -o libjumps.o") == 0:
+    os.remove("libjumps.o")
+    os.remove("test")
+    print("A jump into a function in another object file was allowed")
+    exit(1)
+if os.system(f"./{exe} test --empty -a foo -a bar -a main -o libjumps.o"):
+    os.remove("test")
+    print("Failed to unlink jumps test executable")
+    exit(1)
+if os.system(f"{cc} -no-pie -o rebuilt libjumps.o"):
+    os.remove("libjumps.o")
+    os.remove("test")
+    print("Failed to rebuild jumps test executable")
+    exit(1)
+if os.system("./rebuilt") != os.system("./test"):
+    os.remove("libjumps.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary does not jump to the same place")
+    exit(1)
+# The labels the jumps go to must not be left in the rebuilt executable, where they would split foo up
+if os.system(f"./{exe} compare test foo rebuilt") or os.system(f"./{exe} compare test bar rebuilt"):
+    os.remove("libjumps.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("The rebuilt functions don't compare the same")
+    exit(1)
+
+os.remove("libjumps.o")
+os.remove("test")
+os.remove("rebuilt")
+print("Jumps into other functions work")
+
+print("Testing diffing")
+if os.system(f"{cc} -o old testfiles/test.c") or os.system(f"{cc} -O1 -o new testfiles/test.c"):
+    print("Failed to generate test executables")
+    exit(1)
+if os.system(f"./{exe} diff old old"):
+    os.remove("old")
+    os.remove("new")
+    print("The same executable is reported as different")
+    exit(1)
+if os.popen(f"./{exe} diff old new").read().find("doesn't call add anymore") == -1:
+    os.remove("old")
+    os.remove("new")
+    print("The inlined call isn't reported")
+    exit(1)
+
+os.remove("old")
+os.remove("new")
+print("Diffing works")
+
+print("Testing comparing")
+if os.system(f"{cc} -o test testfiles/test.c") or os.system(f"{cc} -O1 -o new testfiles/test.c"):
+    print("Failed to generate test executables")
+    exit(1)
+if os.system(f"./{exe} test --empty -a add -a main -g x -o libmain.o"):
+    os.remove("test")
+    os.remove("new")
+    print("Failed to unlink executable")
+    exit(1)
+# The object's relocations have to come out as the same symbols as the executable's addresses
+if os.system(f"./{exe} compare test main libmain.o") or os.system(f"./{exe} compare test add libmain.o"):
+    os.remove("libmain.o")
+    os.remove("test")
+    os.remove("new")
+    print("The extracted functions don't match the original")
+    exit(1)
+if os.system(f"{cc} -o rebuilt libmain.o"):
+    os.remove("libmain.o")
+    os.remove("test")
+    os.remove("new")
+    print("Failed to rebuild executable")
+    exit(1)
+if os.system(f"./{exe} compare test main rebuilt") or os.system(f"./{exe} compare test add rebuilt"):
+    os.remove("libmain.o")
+    os.remove("test")
+    os.remove("new")
+    os.remove("rebuilt")
+    print("The relinked functions don't match the original")
+    exit(1)
+# add is inlined into main with -O1
+if os.popen(f"./{exe} compare test main new").read().find("instructions differ") == -1:
+    os.remove("libmain.o")
+    os.remove("test")
+    os.remove("new")
+    os.remove("rebuilt")
+    print("A changed function is reported as identical")
+    exit(1)
+
+os.remove("libmain.o")
+os.remove("test")
+os.remove("new")
+os.remove("rebuilt")
+print("Comparing works")
+
+print("Testing PE extraction")
+if os.system(f"./{exe} testfiles/pe/test.exe --empty -a main -o pe_main.obj"):
+    print("Failed to unlink PE executable")
+    exit(1)
+if os.popen("objdump -f pe_main.obj").read().find("pe-x86-64") == -1:
+    os.remove("pe_main.obj")
+    print("PE extraction did not produce a COFF object")
+    exit(1)
+
+os.remove("pe_main.obj")
+print("PE extraction works")
+
+# Only assembled, since running it would need qemu
+print("Testing AArch64 extraction")
+if not shutil.which("aarch64-linux-gnu-objdump") or not shutil.which("aarch64-linux-gnu-as"):
+    print("Skipping AArch64 extraction, the aarch64-linux-gnu binutils aren't installed")
+else:
+    if os.system(f"./{exe} testfiles/aarch64/test --empty -a main -a add -a sum -g x -g y -g table -o a64_main.o"):
+        print("Failed to unlink AArch64 executable")
+        exit(1)
+    relocations = os.popen("aarch64-linux-gnu-objdump -r a64_main.o").read()
+    # x and y share an adrp in the executable, but needn't share a page once linked again
+    if relocations.count("R_AARCH64_ADR_PREL_PG_HI21") != 3 or [line.split()[1:] for line in relocations.splitlines()].count(["R_AARCH64_LDST32_ABS_LO12_NC", "y"]) != 1:
+        os.remove("a64_main.o")
+        print("AArch64 adrp pages were not turned back into symbols")
+        exit(1)
+    # The branches inside sum and main go to local labels, which don't need relocations
+    if relocations.find("R_AARCH64_CONDBR19") != -1 or os.popen("nm a64_main.o").read().find("_at_") != -1:
+        os.remove("a64_main.o")
+        print("AArch64 branch labels were not local")
+        exit(1)
+    os.remove("a64_main.o")
+
+    # puts and fflush are called through the PLT, stdout read through the GOT
+    if os.system(f"./{exe} testfiles/aarch64/imports --empty -a main -o a64_imports.o"):
+        print("Failed to unlink AArch64 executable with imports")
+        exit(1)
+    relocations = [line.split()[1:] for line in os.popen("aarch64-linux-gnu-objdump -r a64_imports.o").read().splitlines()]
+    os.remove("a64_imports.o")
+    if relocations.count(["R_AARCH64_CALL26", "puts"]) != 1 or relocations.count(["R_AARCH64_CALL26", "fflush"]) != 1 or ["R_AARCH64_ADR_GOT_PAGE", "stdout"] not in relocations:
+        print("AArch64 imports were not turned back into calls and GOT loads")
+        exit(1)
+
+    print("AArch64 extraction works")
+
+print("Testing RISC-V extraction")
+if not shutil.which("riscv64-linux-gnu-objdump") or not shutil.which("riscv64-linux-gnu-as"):
+    print("Skipping RISC-V extraction, the riscv64-linux-gnu binutils aren't installed")
+else:
+    if os.system(f"./{exe} testfiles/riscv64/test --empty -a main -a add -a sum -g x -g table -o rv_main.o"):
+        print("Failed to unlink RISC-V executable")
+        exit(1)
+    relocations = [line.split()[1:] for line in os.popen("riscv64-linux-gnu-objdump -r rv_main.o").read().splitlines()]
+    # Both loads of x share the auipc's label
+    if relocations.count(["R_RISCV_PCREL_HI20", "x"]) != 1 or relocations.count(["R_RISCV_PCREL_LO12_I", ".Lpcrel_main_0"]) != 2:
+        os.remove("rv_main.o")
+        print("RISC-V auipc pairs were not turned back into symbols")
+        exit(1)
+    os.remove("rv_main.o")
+    # pc's auipc has nothing to pair it with
+    if not os.system(f"./{exe} testfiles/riscv64/test --empty -a pc -o rv_pc.o"):
+        os.remove("rv_pc.o")
+        print("An auipc without a pair was extracted")
+        exit(1)
+
+    # puts and fflush are called through the PLT, stdout read through the GOT
+    if os.system(f"./{exe} testfiles/riscv64/imports --empty -a main -o rv_imports.o"):
+        print("Failed to unlink RISC-V executable with imports")
+        exit(1)
+    relocations = [line.split()[1:] for line in os.popen("riscv64-linux-gnu-objdump -r rv_imports.o").read().splitlines()]
+    os.remove("rv_imports.o")
+    calls = [symbol for kind, symbol in (r for r in relocations if len(r) == 2) if kind in ("R_RISCV_CALL", "R_RISCV_CALL_PLT")]
+    if sorted(calls) != ["fflush", "puts"] or ["R_RISCV_GOT_HI20", "stdout"] not in relocations:
+        print("RISC-V imports were not turned back into calls and GOT loads")
+        exit(1)
+
+    print("RISC-V extraction works")
+
+# Only assembled too, since there's no ld64 off a Mac
+print("Testing Mach-O extraction")
+if not shutil.which("llvm-objdump") or not shutil.which("llvm-mc") or not shutil.which("llvm-nm"):
+    print("Skipping Mach-O extraction, llvm-objdump, llvm-mc and llvm-nm aren't all installed")
+else:
+    if os.system(f"./{exe} testfiles/macho/test --empty -a _main -a _add -g _x -g _y -o macho_main.o"):
+        print("Failed to unlink Mach-O executable")
+        exit(1)
+    # The code has _x@PAGE and _x@PAGEOFF, which are still _x
+    symbols = [line.split()[1:] for line in os.popen("llvm-nm macho_main.o").read().splitlines()]
+    os.remove("macho_main.o")
+    if ["D", "_x"] not in symbols or ["D", "_y"] not in symbols:
+        print("Mach-O globals used through @PAGE and @PAGEOFF were not included")
+        exit(1)
+    if os.system(f"./{exe} testfiles/macho/test --prefix p --empty -a _main -g _x -g _y -o macho_prefixed.o"):
+        print("Failed to unlink Mach-O executable with a prefix")
+        exit(1)
+    symbols = [line.split()[1:] for line in os.popen("llvm-nm macho_prefixed.o").read().splitlines()]
+    relocations = [line.split()[1:] for line in os.popen("llvm-objdump -r macho_prefixed.o").read().splitlines()]
+    os.remove("macho_prefixed.o")
+    if ["D", "p_x"] not in symbols or ["ARM64_RELOC_PAGEOFF12", "p_x"] not in relocations or ["U", "_x"] in symbols:
+        print("Mach-O @PAGE and @PAGEOFF references were not prefixed")
+        exit(1)
+
+    # _puts and _fflush are called through __stubs, ___stdoutp read through __got, on both architectures
+    for arch, call, got in [("arm64", "ARM64_RELOC_BRANCH26", "ARM64_RELOC_GOT_LOAD_PAGE21"), ("x86_64", "X86_64_RELOC_BRANCH", "X86_64_RELOC_GOT")]:
+        if os.system(f"./{exe} testfiles/macho/imports_{arch} --empty -a _main -o macho_imports.o"):
+            print(f"Failed to unlink {arch} Mach-O executable with imports")
+            exit(1)
+        relocations = [line.split()[1:] for line in os.popen("llvm-objdump -r macho_imports.o").read().splitlines()]
+        os.remove("macho_imports.o")
+        if [call, "_puts"] not in relocations or [call, "_fflush"] not in relocations or not any(r[0].startswith(got) and r[1:] == ["___stdoutp"] for r in relocations if r):
+            print(f"{arch} Mach-O imports were not turned back into calls and GOT loads")
+            exit(1)
+
+    print("Mach-O extraction works")
+
+print("Testing static extraction")
+if os.system(f"{cc} -static -o test testfiles/test.c"):
+    print("Failed to generate static test executable")
+    exit(1)
+if os.system(f"./{exe} test --empty -a add -a main -g x -o libstatic.o"):
+    os.remove("test")
+    print("Failed to unlink static executable")
+    exit(1)
+# printf and puts must come from the shared libc now, not from the object
+if os.system(f"{cc} -o rebuilt libstatic.o"):
+    os.remove("libstatic.o")
+    os.remove("test")
+    print("Failed to rebuild executable against the shared libc")
+    exit(1)
+if os.system(f"./rebuilt"):
+    os.remove("libstatic.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary does not work")
+    exit(1)
+
+os.remove("libstatic.o")
+os.remove("test")
+os.remove("rebuilt")
+print("Static extraction works")
+
+print("Testing functions named like the runtime's")
+if os.system(f"{cc} -static -o test testfiles/runtime_names.c"):
+    print("Failed to generate static test executable")
+    exit(1)
+# error and send are the program's own, so they must not turn into libc's
+if os.system(f"./{exe} test --empty -a main -a error -a send -o libnames.o"):
+    os.remove("test")
+    print("Failed to unlink static executable")
+    exit(1)
+if os.system(f"{cc} -o rebuilt libnames.o"):
+    os.remove("libnames.o")
+    os.remove("test")
+    print("Failed to rebuild executable against the shared libc")
+    exit(1)
+if os.popen("./rebuilt").read() != os.popen("./test").read():
+    os.remove("libnames.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("The program's own functions were replaced by the runtime's")
+    exit(1)
+
+os.remove("libnames.o")
+os.remove("test")
+os.remove("rebuilt")
+print("Functions named like the runtime's work")
+
+print("Testing signatures")
+if os.system(f"{cc} -c -o shout.o testfiles/shout.c") or os.system("ar rcs libshout.a shout.o"):
+    print("Failed to generate signature test library")
+    exit(1)
+os.remove("shout.o")
+if os.system(f"./{exe} signatures shout.sig libshout.a") or os.system(f"{cc} -static -s -o test testfiles/shouting.c libshout.a"):
+    os.remove("libshout.a")
+    print("Failed to generate stripped static test executable")
+    exit(1)
+# shout and whisper only differ by whether they call puts or remove
+functions = os.popen(f"./{exe} test --signatures shout.sig --list").read().split()
+os.remove("libshout.a")
+os.remove("shout.sig")
+os.remove("test")
+if "shout" not in functions or "whisper" not in functions or "repeat" not in functions:
+    print("Signatures didn't name the library's functions")
+    exit(1)
+
+print("Signatures work")
+
+print("Testing C++ extraction")
+if os.system(f"{cxx} -o test testfiles/shapes.cpp"):
+    print("Failed to generate C++ test executable")
+    exit(1)
+# The classes come with their vtables and typeinfo
+if os.system(f"./{exe} test --empty -a 'geo::Shape' -a 'geo::Square' -a 'geo::total' -a main -o libshapes.o"):
+    os.remove("test")
+    print("Failed to unlink C++ executable")
+    exit(1)
+if os.system(f"{cxx} -o rebuilt libshapes.o"):
+    os.remove("libshapes.o")
+    os.remove("test")
+    print("Failed to rebuild C++ executable")
+    exit(1)
+if os.system(f"./rebuilt"):
+    os.remove("libshapes.o")
+    os.remove("test")
+    os.remove("rebuilt")
+    print("Rebuilt binary does not work")
+    exit(1)
+
+os.remove("libshapes.o")
+os.remove("test")
+os.remove("rebuilt")
+print("C++ extraction works")
@@ -0,0 +1,31 @@
+// AArch64 fixture that imports from libc.so.6: puts and fflush through the PLT and stdout through the GOT.
+// Rebuild with:
+//   aarch64-linux-gnu-as imports.s -o imports.o
+//   aarch64-linux-gnu-ld -dynamic-linker /lib/ld-linux-aarch64.so.1 imports.o -lc -o imports
+// or, without the cross binutils:
+//   llvm-mc -triple=aarch64-linux-gnu -filetype=obj imports.s -o imports.o
+//   python3 ../link.py imports.o imports
+    .text
+    .globl _start
+_start:
+    bl main
+    b exit
+
+    .globl main
+main:
+    stp x29, x30, [sp, #-16]!
+    mov x29, sp
+    adrp x0, message
+    add x0, x0, :lo12:message
+    bl puts
+    adrp x0, :got:stdout
+    ldr x0, [x0, :got_lo12:stdout]
+    ldr x0, [x0]
+    bl fflush
+    mov w0, #0
+    ldp x29, x30, [sp], #16
+    ret
+
+    .section .rodata
+message:
+    .string "Hello from AArch64"
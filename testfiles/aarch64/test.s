@@ -0,0 +1,59 @@
+// AArch64 fixture, since cross compilers aren't in most Linux CI. It has no libc, so _start makes the exit syscall.
+// Rebuild with:
+//   aarch64-linux-gnu-as test.s -o test.o
+//   aarch64-linux-gnu-ld test.o -o test
+// or, without the cross binutils:
+//   llvm-mc -triple=aarch64-linux-gnu -filetype=obj test.s -o test.o
+//   python3 ../link.py test.o test
+    .text
+    .globl _start
+_start:
+    bl main
+    mov x8, #93
+    svc #0
+
+    .globl add
+add:
+    add w0, w0, w1
+    ret
+
+    // Sums the table, with a loop that branches back into the function
+    .globl sum
+sum:
+    adrp x1, table
+    add x1, x1, :lo12:table
+    mov w0, #0
+    mov x2, #0
+1:
+    ldr w3, [x1, x2, lsl #2]
+    add w0, w0, w3
+    add x2, x2, #1
+    cmp x2, #4
+    b.ne 1b
+    ret
+
+    // One adrp for x and y, like GCC's section anchors
+    .globl main
+main:
+    stp x29, x30, [sp, #-16]!
+    adrp x9, x
+    ldr w0, [x9, :lo12:x]
+    ldr w1, [x9, :lo12:y]
+    bl add
+    cbz w0, 2f
+    bl sum
+2:
+    ldp x29, x30, [sp], #16
+    ret
+
+    .data
+    .globl x
+    .p2align 2
+x:
+    .word 5
+    .globl y
+y:
+    .word 3
+    .globl table
+table:
+    .word 1, 2, 3, 4
@@ -0,0 +1,14 @@
+#include <stdio.h>
+
+__attribute__((constructor)) void setup(void) {
+    puts("setup");
+}
+
+__attribute__((destructor)) void teardown(void) {
+    puts("teardown");
+}
+
+int main() {
+    puts("main");
+    return 0;
+}
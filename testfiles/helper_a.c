@@ -0,0 +1,8 @@
+// helper_b.c has a static helper too, so the executable has two of them
+static int helper(int x) {
+    return x * 2;
+}
+
+int twice(int x) {
+    return helper(x);
+}
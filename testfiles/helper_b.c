@@ -0,0 +1,7 @@
+static int helper(int x) {
+    return x + 100;
+}
+
+int hundred(int x) {
+    return helper(x);
+}
@@ -0,0 +1,9 @@
+#include <stdio.h>
+
+int twice(int x);
+int hundred(int x);
+
+int main() {
+    printf("%d %d\n", twice(3), hundred(3));
+    return 0;
+}
@@ -0,0 +1,28 @@
+# bar jumps into the middle of foo, like hand-written assembly sometimes does
+.intel_syntax noprefix
+.section .note.GNU-stack,"",@progbits
+.text
+.globl foo
+.type foo,@function
+foo:
+    mov eax, edi
+    add eax, 1
+.Lmid:
+    imul eax, eax, 3
+    ret
+.size foo,.-foo
+.globl bar
+.type bar,@function
+bar:
+    mov eax, edi
+    test edi, edi
+    jne .Lmid
+    jmp foo
+.size bar,.-bar
+.globl main
+.type main,@function
+main:
+    mov edi, 2
+    call bar
+    ret
+.size main,.-main
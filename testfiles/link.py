@@ -0,0 +1,634 @@
+#!/usr/bin/env python3
+# Just enough of a linker to turn one AArch64 or RISC-V object file into an executable, so the fixtures can be
+# made again without a cross toolchain (or lld):
+#   python3 testfiles/link.py input.o output
+# With --macho, an AArch64 or x86-64 ELF object becomes a Mach-O executable instead, since there's no ld64 off a Mac.
+# Every allocated section gets its own page, after one for the headers, and _start is the entry point.
+# Symbols the object doesn't define are imported from libc.so.6, like ld would: calls go through PLT entries and
+# GOT relocations through GOT slots, laid out the way GNU ld does, so objdump names the entries sym@plt.
+# Mach-O executables import from libSystem instead, through __stubs and __la_symbol_ptr, and __got.
+# Only the relocations the fixtures use are understood.
+import struct
+import sys
+
+BASE = 0x400000
+PAGE = 0x1000
+
+EM_X86_64 = 62
+EM_AARCH64 = 183
+EM_RISCV = 243
+
+SHT_PROGBITS = 1
+SHT_SYMTAB = 2
+SHT_STRTAB = 3
+SHT_RELA = 4
+SHT_DYNAMIC = 6
+SHT_NOBITS = 8
+SHT_DYNSYM = 11
+SHF_WRITE = 1
+SHF_ALLOC = 2
+SHF_EXECINSTR = 4
+SHF_INFO_LINK = 0x40
+STT_OBJECT = 1
+STT_FUNC = 2
+STT_SECTION = 3
+STB_GLOBAL = 1
+
+# Relocations that go through a PLT entry or a GOT slot when their symbol is imported
+CALL_RELOCATIONS = {EM_X86_64: (4,), EM_AARCH64: (282, 283), EM_RISCV: (18, 19)}
+GOT_RELOCATIONS = {EM_X86_64: (9, 41, 42), EM_AARCH64: (311, 312), EM_RISCV: (20,)}
+
+
+def read_string(table, offset):
+    return table[offset:table.index(b"\0", offset)].decode()
+
+
+def align(value, alignment):
+    return (value + alignment - 1) // alignment * alignment
+
+
+def sign_check(value, bits, what):
+    if value < -(1 << (bits - 1)) or value >= 1 << (bits - 1):
+        sys.exit(f"{what} out of range")
+
+
+class Object:
+    def __init__(self, data):
+        self.data = data
+        if data[:4] != b"\x7fELF" or data[4] != 2 or data[5] != 1:
+            sys.exit("Only little-endian 64-bit ELF objects are supported")
+        (self.type, self.machine, _, _, _, shoff, self.flags, _, _, _, shentsize, shnum, shstrndx) = struct.unpack_from("<HHIQQQIHHHHHH", data, 16)
+        self.sections = []
+        for i in range(shnum):
+            name, kind, flags, addr, offset, size, link, info, alignment, entsize = struct.unpack_from("<IIQQQQIIQQ", data, shoff + i * shentsize)
+            self.sections.append({"name": name, "type": kind, "flags": flags, "offset": offset, "size": size, "link": link, "info": info, "align": max(alignment, 1), "entsize": entsize})
+        names = self.contents(self.sections[shstrndx])
+        for section in self.sections:
+            section["name"] = read_string(names, section["name"])
+
+        self.symbols = []
+        for section in self.sections:
+            if section["type"] != SHT_SYMTAB:
+                continue
+            strings = self.contents(self.sections[section["link"]])
+            table = self.contents(section)
+            for i in range(0, len(table), 24):
+                name, info, other, shndx, value, size = struct.unpack_from("<IBBHQQ", table, i)
+                self.symbols.append({"name": read_string(strings, name), "info": info, "other": other, "shndx": shndx, "value": value, "size": size})
+
+    def contents(self, section):
+        if "data" in section:
+            return section["data"]
+        if section["type"] == SHT_NOBITS:
+            return bytes(section["size"])
+        return self.data[section["offset"]:section["offset"] + section["size"]]
+
+
+def patch32(code, offset, clear, value):
+    insn = struct.unpack_from("<I", code, offset)[0]
+    struct.pack_into("<I", code, offset, (insn & ~clear & 0xffffffff) | value)
+
+
+def relocate_aarch64(code, offset, kind, s, a, p):
+    if kind == 257:  # ABS64
+        struct.pack_into("<Q", code, offset, (s + a) & (1 << 64) - 1)
+    elif kind == 261:  # PREL32
+        struct.pack_into("<i", code, offset, s + a - p)
+    elif kind in (282, 283):  # JUMP26, CALL26
+        sign_check((s + a - p) >> 2, 26, "Branch")
+        patch32(code, offset, 0x3ffffff, ((s + a - p) >> 2) & 0x3ffffff)
+    elif kind == 280:  # CONDBR19
+        sign_check((s + a - p) >> 2, 19, "Branch")
+        patch32(code, offset, 0x7ffff << 5, (((s + a - p) >> 2) & 0x7ffff) << 5)
+    elif kind == 279:  # TSTBR14
+        sign_check((s + a - p) >> 2, 14, "Branch")
+        patch32(code, offset, 0x3fff << 5, (((s + a - p) >> 2) & 0x3fff) << 5)
+    elif kind in (274, 275):  # ADR_PREL_LO21, ADR_PREL_PG_HI21
+        imm = s + a - p if kind == 274 else ((s + a) & ~0xfff) - (p & ~0xfff) >> 12
+        patch32(code, offset, (3 << 29) | (0x7ffff << 5), ((imm & 3) << 29) | (((imm >> 2) & 0x7ffff) << 5))
+    elif kind == 311:  # ADR_GOT_PAGE, with s already the GOT slot
+        imm = ((s + a) & ~0xfff) - (p & ~0xfff) >> 12
+        patch32(code, offset, (3 << 29) | (0x7ffff << 5), ((imm & 3) << 29) | (((imm >> 2) & 0x7ffff) << 5))
+    elif kind == 312:  # LD64_GOT_LO12_NC
+        patch32(code, offset, 0xfff << 10, (((s + a) & 0xfff) >> 3) << 10)
+    elif kind in (277, 278, 284, 285, 286, 299):  # ADD_ABS_LO12_NC, LDST8/16/32/64/128_ABS_LO12_NC
+        shift = {277: 0, 278: 0, 284: 1, 285: 2, 286: 3, 299: 4}[kind]
+        patch32(code, offset, 0xfff << 10, (((s + a) & 0xfff) >> shift) << 10)
+    else:
+        sys.exit(f"Unsupported AArch64 relocation {kind}")
+
+
+def relocate_x86_64(code, offset, kind, s, a, p):
+    if kind == 1:  # 64
+        struct.pack_into("<Q", code, offset, (s + a) & (1 << 64) - 1)
+    elif kind in (2, 4, 9, 41, 42):  # PC32, PLT32, and GOTPCREL(X) with s already the GOT slot
+        sign_check(s + a - p, 32, "Displacement")
+        struct.pack_into("<i", code, offset, s + a - p)
+    else:
+        sys.exit(f"Unsupported x86-64 relocation {kind}")
+
+
+def hi20(value):
+    return (value + 0x800) >> 12 & 0xfffff
+
+
+def lo12(value):
+    return value - ((value + 0x800) >> 12 << 12)
+
+
+def relocate_riscv(code, offset, kind, s, a, p, pcrel_hi):
+    if kind == 1:  # 32
+        struct.pack_into("<I", code, offset, (s + a) & 0xffffffff)
+    elif kind == 2:  # 64
+        struct.pack_into("<Q", code, offset, (s + a) & (1 << 64) - 1)
+    elif kind == 16:  # BRANCH
+        imm = s + a - p
+        sign_check(imm, 13, "Branch")
+        patch32(code, offset, 0xfe000f80, ((imm >> 12 & 1) << 31) | ((imm >> 5 & 0x3f) << 25) | ((imm >> 1 & 0xf) << 8) | ((imm >> 11 & 1) << 7))
+    elif kind == 17:  # JAL
+        imm = s + a - p
+        sign_check(imm, 21, "Jump")
+        patch32(code, offset, 0xfffff000, ((imm >> 20 & 1) << 31) | ((imm >> 1 & 0x3ff) << 21) | ((imm >> 11 & 1) << 20) | ((imm >> 12 & 0xff) << 12))
+    elif kind in (18, 19):  # CALL, CALL_PLT: auipc then jalr
+        imm = s + a - p
+        patch32(code, offset, 0xfffff000, hi20(imm) << 12)
+        patch32(code, offset + 4, 0xfff00000, (lo12(imm) & 0xfff) << 20)
+    elif kind in (20, 23):  # GOT_HI20 (with s already the GOT slot), PCREL_HI20
+        patch32(code, offset, 0xfffff000, hi20(s + a - p) << 12)
+    elif kind in (24, 25):  # PCREL_LO12_I, PCREL_LO12_S, whose symbol is the auipc
+        imm = lo12(pcrel_hi[s])
+        if kind == 24:
+            patch32(code, offset, 0xfff00000, (imm & 0xfff) << 20)
+        else:
+            patch32(code, offset, 0xfe000f80, ((imm >> 5 & 0x7f) << 25) | ((imm & 0x1f) << 7))
+    elif kind == 26:  # HI20
+        patch32(code, offset, 0xfffff000, hi20(s + a) << 12)
+    elif kind == 27:  # LO12_I
+        patch32(code, offset, 0xfff00000, (lo12(s + a) & 0xfff) << 20)
+    elif kind == 28:  # LO12_S
+        imm = lo12(s + a)
+        patch32(code, offset, 0xfe000f80, ((imm >> 5 & 0x7f) << 25) | ((imm & 0x1f) << 7))
+    elif kind not in (43, 51):  # ALIGN and RELAX are hints
+        sys.exit(f"Unsupported RISC-V relocation {kind}")
+
+
+def a64_adrp(rd, p, target):
+    imm = (target & ~0xfff) - (p & ~0xfff) >> 12
+    return 0x90000000 | ((imm & 3) << 29) | (((imm >> 2) & 0x7ffff) << 5) | rd
+
+
+def a64_ldr(rt, rn, offset):
+    return 0xf9400000 | ((offset & 0xfff) >> 3 << 10) | (rn << 5) | rt
+
+
+def a64_add(rd, rn, imm):
+    return 0x91000000 | ((imm & 0xfff) << 10) | (rn << 5) | rd
+
+
+def rv_auipc(rd, p, target):
+    return (hi20(target - p) << 12) | (rd << 7) | 0x17
+
+
+def rv_i(opcode, funct3, rd, rs1, imm):
+    return ((imm & 0xfff) << 20) | (rs1 << 15) | (funct3 << 12) | (rd << 7) | opcode
+
+
+T0, T1, T2, T3 = 5, 6, 7, 28
+X16, X17 = 16, 17
+
+
+class DynamicImports:
+    """The PLT, GOT and dynamic section of an executable that imports calls and data from libc.so.6"""
+    INTERPRETERS = {EM_AARCH64: "/lib/ld-linux-aarch64.so.1", EM_RISCV: "/lib/ld-linux-riscv64-lp64d.so.1"}
+    # Entries .got.plt has for the dynamic linker before the ones for the PLT
+    RESERVED = {EM_AARCH64: 3, EM_RISCV: 2}
+    JUMP_SLOT = {EM_AARCH64: 1026, EM_RISCV: 5}
+    # RISC-V fills its GOT slots with plain 64-bit relocations
+    GLOB_DAT = {EM_AARCH64: 1025, EM_RISCV: 2}
+
+    def __init__(self, obj, calls, data):
+        self.obj = obj
+        self.calls = calls
+        self.data = data
+        self.symbols = calls + [name for name in data if name not in calls]
+        self.dynstr = bytearray(b"\0")
+        self.needed = add_name(self.dynstr, "libc.so.6")
+        dynsym = bytearray(24)
+        for name in self.symbols:
+            kind = STT_FUNC if name in calls else STT_OBJECT
+            dynsym += struct.pack("<IBBHQQ", add_name(self.dynstr, name), STB_GLOBAL << 4 | kind, 0, 0, 0, 0)
+
+        # The sections are added to the object's, so they get laid out with the rest of them, and filled in after
+        def add(name, kind, flags, size, entsize=0, link=None, info=None, alignment=8, data=None):
+            # Everything that isn't code or writable is for the dynamic linker, and goes before the rest
+            rank = 1 if flags & (SHF_EXECINSTR | SHF_WRITE) else 0
+            section = {"name": name, "type": kind, "flags": SHF_ALLOC | flags, "offset": 0, "size": size, "link": link, "info": info, "align": alignment, "entsize": entsize, "data": data if data is not None else bytearray(size), "rank": rank}
+            obj.sections.append(section)
+            return len(obj.sections) - 1
+
+        self.interp = add(".interp", SHT_PROGBITS, 0, 0, alignment=1, data=bytearray(self.INTERPRETERS[obj.machine].encode() + b"\0"))
+        obj.sections[self.interp]["size"] = len(obj.sections[self.interp]["data"])
+        self.dynsym = add(".dynsym", SHT_DYNSYM, 0, len(dynsym), 24, ".dynstr", 1, data=dynsym)
+        self.dynstr_index = add(".dynstr", SHT_STRTAB, 0, len(self.dynstr), alignment=1, data=self.dynstr)
+        self.rela_dyn = add(".rela.dyn", SHT_RELA, 0, 24 * len(data), 24, ".dynsym") if data else None
+        self.rela_plt = add(".rela.plt", SHT_RELA, SHF_INFO_LINK, 24 * len(calls), 24, ".dynsym", ".got.plt") if calls else None
+        self.plt = add(".plt", SHT_PROGBITS, SHF_EXECINSTR, 32 + 16 * len(calls), 16, alignment=16) if calls else None
+        self.dynamic_entries = 7 + 4 * bool(calls) + 3 * bool(data)
+        self.dynamic = add(".dynamic", SHT_DYNAMIC, SHF_WRITE, 16 * self.dynamic_entries, 16, ".dynstr")
+        # The first GOT slot is for _DYNAMIC
+        self.got = add(".got", SHT_PROGBITS, SHF_WRITE, 8 * (1 + len(data)), 8)
+        self.got_plt = add(".got.plt", SHT_PROGBITS, SHF_WRITE, 8 * (self.RESERVED[obj.machine] + len(calls)), 8) if calls else None
+
+    def plt_entry(self, linked, name):
+        return linked.addresses[self.plt] + 32 + 16 * self.calls.index(name)
+
+    def got_slot(self, linked, name):
+        return linked.addresses[self.got] + 8 * (1 + self.data.index(name))
+
+    def got_plt_slot(self, linked, name):
+        return linked.addresses[self.got_plt] + 8 * (self.RESERVED[self.obj.machine] + self.calls.index(name))
+
+    def fill(self, linked):
+        machine = self.obj.machine
+        got = linked.contents[self.got]
+        struct.pack_into("<Q", got, 0, linked.addresses[self.dynamic])
+
+        if self.data:
+            rela = linked.contents[self.rela_dyn]
+            for n, name in enumerate(self.data):
+                struct.pack_into("<QQq", rela, 24 * n, self.got_slot(linked, name), (self.symbols.index(name) + 1) << 32 | self.GLOB_DAT[machine], 0)
+
+        if self.calls:
+            plt = linked.addresses[self.plt]
+            got_plt = linked.addresses[self.got_plt]
+            code = []
+            if machine == EM_AARCH64:
+                # ld.so's resolver is in .got.plt[2]
+                code += [0xa9bf7bf0, a64_adrp(X16, plt + 4, got_plt + 16), a64_ldr(X17, X16, got_plt + 16), a64_add(X16, X16, got_plt + 16), 0xd61f0220, 0xd503201f, 0xd503201f, 0xd503201f]
+                for name in self.calls:
+                    slot = self.got_plt_slot(linked, name)
+                    p = self.plt_entry(linked, name)
+                    code += [a64_adrp(X16, p, slot), a64_ldr(X17, X16, slot), a64_add(X16, X16, slot), 0xd61f0220]
+            else:
+                # t1 is the address of the entry's jalr + 4, and t3 its .got.plt slot
+                lo = lo12(got_plt - plt)
+                code += [rv_auipc(T2, plt, got_plt), 0x41c30333, rv_i(0x03, 3, T3, T2, lo), rv_i(0x13, 0, T1, T1, -44), rv_i(0x13, 0, T0, T2, lo), rv_i(0x13, 5, T1, T1, 1), rv_i(0x03, 3, T0, T0, 8), rv_i(0x67, 0, 0, T3, 0)]
+                for name in self.calls:
+                    slot = self.got_plt_slot(linked, name)
+                    p = self.plt_entry(linked, name)
+                    code += [rv_auipc(T3, p, slot), rv_i(0x03, 3, T3, T3, lo12(slot - p)), rv_i(0x67, 0, T1, T3, 0), 0x13]
+            linked.contents[self.plt] = bytearray(struct.pack(f"<{len(code)}I", *code))
+
+            # Until they're resolved, the slots go to the start of the PLT
+            slots = linked.contents[self.got_plt]
+            rela = linked.contents[self.rela_plt]
+            for n, name in enumerate(self.calls):
+                struct.pack_into("<Q", slots, 8 * (self.RESERVED[machine] + n), plt)
+                struct.pack_into("<QQq", rela, 24 * n, self.got_plt_slot(linked, name), (self.symbols.index(name) + 1) << 32 | self.JUMP_SLOT[machine], 0)
+
+        entries = [(1, self.needed), (5, linked.addresses[self.dynstr_index]), (6, linked.addresses[self.dynsym]), (10, len(self.dynstr)), (11, 24)]
+        if self.calls:
+            entries += [(3, linked.addresses[self.got_plt]), (2, 24 * len(self.calls)), (20, 7), (23, linked.addresses[self.rela_plt])]
+        if self.data:
+            entries += [(7, linked.addresses[self.rela_dyn]), (8, 24 * len(self.data)), (9, 24)]
+        entries += [(21, 0), (0, 0)]
+        linked.contents[self.dynamic] = bytearray(b"".join(struct.pack("<qQ", tag, value) for tag, value in entries))
+
+
+class MachOImports:
+    """The stubs, lazy pointers and GOT of a Mach-O executable that imports calls and data from libSystem"""
+    # adrp x16, lazy@PAGE; ldr x16, [x16, lazy@PAGEOFF]; br x16, or jmp [rip + lazy]
+    STUB_SIZES = {EM_AARCH64: 12, EM_X86_64: 6}
+
+    def __init__(self, obj, calls, data):
+        self.obj = obj
+        self.calls = calls
+        self.data = data
+        self.symbols = calls + [name for name in data if name not in calls]
+
+        # The sections are added to the object's like DynamicImports does, with what their Mach-O headers need:
+        # the section type, where their symbols start in the indirect symbol table, and the stub size
+        def add(name, flags, size, alignment, kind, first, stride=0):
+            section = {"name": name, "type": SHT_PROGBITS, "flags": SHF_ALLOC | flags, "offset": 0, "size": size, "link": 0, "info": 0, "align": alignment, "entsize": 0, "data": bytearray(size), "macho": (name, kind, first, stride)}
+            obj.sections.append(section)
+            return len(obj.sections) - 1
+
+        # The indirect symbol table has the stubs' symbols, then the GOT's, then the lazy pointers'
+        stub = self.STUB_SIZES[obj.machine]
+        self.stubs = add("__stubs", SHF_EXECINSTR, stub * len(calls), 4 if obj.machine == EM_AARCH64 else 2, 0x80000408, 0, stub) if calls else None
+        self.got = add("__got", SHF_WRITE, 8 * len(data), 8, 0x6, len(calls)) if data else None
+        self.lazy = add("__la_symbol_ptr", SHF_WRITE, 8 * len(calls), 8, 0x7, len(calls) + len(data)) if calls else None
+        self.indirect = calls + data + calls
+
+    def plt_entry(self, linked, name):
+        return linked.addresses[self.stubs] + self.STUB_SIZES[self.obj.machine] * self.calls.index(name)
+
+    def got_slot(self, linked, name):
+        return linked.addresses[self.got] + 8 * self.data.index(name)
+
+    def lazy_slot(self, linked, name):
+        return linked.addresses[self.lazy] + 8 * self.calls.index(name)
+
+    # dyld would bind the pointers, and nothing runs these fixtures, so they stay zero
+    def fill(self, linked):
+        if not self.calls:
+            return
+        stubs = linked.contents[self.stubs]
+        for n, name in enumerate(self.calls):
+            slot = self.lazy_slot(linked, name)
+            p = self.plt_entry(linked, name)
+            if self.obj.machine == EM_AARCH64:
+                struct.pack_into("<III", stubs, 12 * n, a64_adrp(X16, p, slot), a64_ldr(X16, X16, slot), 0xd61f0200)
+            else:
+                struct.pack_into("<BBi", stubs, 6 * n, 0xff, 0x25, slot - p - 6)
+
+
+class Linked:
+    pass
+
+
+def link(obj, base, page, make_imports):
+    if obj.machine not in (EM_X86_64, EM_AARCH64, EM_RISCV):
+        sys.exit("Only x86-64, AArch64 and RISC-V objects are supported")
+
+    # Imports are whatever the relocations need and nothing defines
+    calls, data = [], []
+    for section in obj.sections:
+        if section["type"] != SHT_RELA:
+            continue
+        table = obj.contents(section)
+        for i in range(0, len(table), 24):
+            _, info, _ = struct.unpack_from("<QQq", table, i)
+            symbol = obj.symbols[info >> 32]
+            if symbol["shndx"] != 0 or not symbol["name"]:
+                continue
+            imported = data if info & 0xffffffff in GOT_RELOCATIONS[obj.machine] else calls
+            if symbol["name"] not in imported:
+                imported.append(symbol["name"])
+    if (calls or data) and make_imports is None:
+        sys.exit(f"{', '.join(calls + data)} can't be imported")
+    imports = make_imports(obj, calls, data) if calls or data else None
+
+    # What the dynamic linker reads, code, then read-only data, then writable data, then zeroes.
+    # What the imports need goes first in each of them
+    order = lambda i: (obj.sections[i].get("rank", 1), not obj.sections[i]["flags"] & SHF_EXECINSTR, bool(obj.sections[i]["flags"] & SHF_WRITE), obj.sections[i]["type"] == SHT_NOBITS, "data" not in obj.sections[i])
+    linked = Linked()
+    linked.imports = imports
+    linked.allocated = sorted([i for i, s in enumerate(obj.sections) if s["flags"] & SHF_ALLOC and s["size"] > 0], key=order)
+    linked.addresses = {}
+    linked.contents = {}
+    address = base + page
+    for i in linked.allocated:
+        linked.addresses[i] = address
+        linked.contents[i] = bytearray(obj.contents(obj.sections[i]))
+        address = align(address + obj.sections[i]["size"], page)
+    linked.end = address
+    if imports:
+        imports.fill(linked)
+
+    def symbol_address(symbol):
+        if symbol["shndx"] == 0:
+            sys.exit(f"{symbol['name']} isn't defined")
+        if symbol["shndx"] == 0xfff1:  # SHN_ABS
+            return symbol["value"]
+        return linked.addresses[symbol["shndx"]] + symbol["value"]
+
+    relocations = []
+    for section in obj.sections:
+        if section["type"] != SHT_RELA or section["info"] not in linked.addresses:
+            continue
+        table = obj.contents(section)
+        for i in range(0, len(table), 24):
+            offset, info, addend = struct.unpack_from("<QQq", table, i)
+            relocations.append((section["info"], offset, info & 0xffffffff, obj.symbols[info >> 32], addend))
+
+    # Imported symbols are at their PLT entry or GOT slot
+    def target_address(kind, symbol):
+        if symbol["shndx"] == 0 and symbol["name"] and kind in GOT_RELOCATIONS[obj.machine]:
+            return imports.got_slot(linked, symbol["name"])
+        if symbol["shndx"] == 0 and symbol["name"]:
+            return imports.plt_entry(linked, symbol["name"])
+        return symbol_address(symbol)
+
+    # The low half of a RISC-V pc-relative pair is relative to its auipc, which has the target
+    pcrel_hi = {}
+    for target, offset, kind, symbol, addend in relocations:
+        if obj.machine == EM_RISCV and kind in (20, 23):
+            p = linked.addresses[target] + offset
+            pcrel_hi[p] = target_address(kind, symbol) + addend - p
+
+    for target, offset, kind, symbol, addend in relocations:
+        p = linked.addresses[target] + offset
+        if obj.machine == EM_AARCH64:
+            relocate_aarch64(linked.contents[target], offset, kind, target_address(kind, symbol), addend, p)
+        elif obj.machine == EM_X86_64:
+            relocate_x86_64(linked.contents[target], offset, kind, target_address(kind, symbol), addend, p)
+        else:
+            relocate_riscv(linked.contents[target], offset, kind, target_address(kind, symbol), addend, p, pcrel_hi)
+
+    # Locals go before globals, and section symbols and assembler-local .L labels are left out
+    kept = [s for s in obj.symbols[1:] if s["info"] & 0xf != STT_SECTION and s["name"] and s["shndx"] != 0 and not s["name"].startswith(".L")]
+    kept = [s for s in kept if s["shndx"] in linked.addresses or s["shndx"] == 0xfff1]
+    kept.sort(key=lambda s: s["info"] >> 4 != 0)
+    linked.symbols = [(s, symbol_address(s)) for s in kept]
+    linked.entry = None
+    for symbol, value in linked.symbols:
+        if symbol["name"] == "_start":
+            linked.entry = value
+    if linked.entry is None:
+        sys.exit("_start isn't defined")
+
+    return linked
+
+
+def add_name(table, name):
+    offset = len(table)
+    table += name.encode() + b"\0"
+    return offset
+
+
+def write_elf(obj):
+    if obj.machine == EM_X86_64:
+        sys.exit("x86-64 objects can only be made into Mach-O executables, ld does ELF ones")
+    linked = link(obj, BASE, PAGE, DynamicImports)
+    allocated = linked.allocated
+    addresses = linked.addresses
+
+    symbols = [struct.pack("<IBBHQQ", 0, 0, 0, 0, 0, 0)]
+    strings = bytearray(b"\0")
+    index = {i: n + 1 for n, i in enumerate(allocated)}
+    locals_count = 1
+    for symbol, value in linked.symbols:
+        if symbol["info"] >> 4 == 0:
+            locals_count += 1
+        shndx = index.get(symbol["shndx"], 0xfff1)
+        symbols.append(struct.pack("<IBBHQQ", add_name(strings, symbol["name"]), symbol["info"], symbol["other"], shndx, value, symbol["size"]))
+
+    # The interpreter comes before what's loaded, and the dynamic section after
+    segments = []
+    for i in allocated:
+        if obj.sections[i]["name"] == ".interp" and "data" in obj.sections[i]:
+            segments.append((3, 4, i, 1))
+    for i in allocated:
+        section = obj.sections[i]
+        flags = 4 | (2 if section["flags"] & SHF_WRITE else 0) | (1 if section["flags"] & SHF_EXECINSTR else 0)
+        segments.append((1, flags, i, PAGE))
+    for i in allocated:
+        if obj.sections[i]["type"] == SHT_DYNAMIC:
+            segments.append((2, 6, i, 8))
+
+    phnum = len(segments)
+    out = bytearray(linked.end - BASE)
+    for i in allocated:
+        if obj.sections[i]["type"] != SHT_NOBITS:
+            out[addresses[i] - BASE:addresses[i] - BASE + len(linked.contents[i])] = linked.contents[i]
+
+    # Sections keep their names
+    names = bytearray(b"\0")
+    symtab_offset = len(out)
+    out += b"".join(symbols)
+    strtab_offset = len(out)
+    out += strings
+    headers = [(0, 0, 0, 0, 0, 0, 0, 0, 0, 0)]
+    # Only the sections made for the imports link to others, by name
+    by_name = {obj.sections[i]["name"]: index[i] for i in allocated}
+    for i in allocated:
+        section = obj.sections[i]
+        links = (by_name.get(section["link"], 0), by_name.get(section["info"], section["info"] or 0)) if "data" in section else (0, 0)
+        headers.append((add_name(names, section["name"]), section["type"], section["flags"], addresses[i], addresses[i] - BASE, section["size"], *links, section["align"], section["entsize"]))
+    headers.append((add_name(names, ".symtab"), SHT_SYMTAB, 0, 0, symtab_offset, len(symbols) * 24, len(allocated) + 2, locals_count, 8, 24))
+    headers.append((add_name(names, ".strtab"), SHT_STRTAB, 0, 0, strtab_offset, len(strings), 0, 0, 1, 0))
+    shstrtab_name = add_name(names, ".shstrtab")
+    shstrtab_offset = len(out)
+    out += names
+    headers.append((shstrtab_name, SHT_STRTAB, 0, 0, shstrtab_offset, len(names), 0, 0, 1, 0))
+    out += bytes(align(len(out), 8) - len(out))
+    shoff = len(out)
+    for header in headers:
+        out += struct.pack("<IIQQQQIIQQ", *header)
+
+    # The headers have the first page to themselves
+    struct.pack_into("<4sBBBBB7xHHIQQQIHHHHHH", out, 0, b"\x7fELF", 2, 1, 1, 0, 0, 2, obj.machine, 1, linked.entry, 64, shoff, obj.flags, 64, 56, phnum, 64, len(headers), len(headers) - 1)
+    for n, (kind, flags, i, alignment) in enumerate(segments):
+        section = obj.sections[i]
+        filesz = 0 if section["type"] == SHT_NOBITS else section["size"]
+        struct.pack_into("<IIQQQQQQ", out, 64 + n * 56, kind, flags, addresses[i] - BASE, addresses[i], addresses[i], filesz, section["size"], alignment)
+
+    return out
+
+
+MACHO_BASE = 0x100000000
+MACHO_PAGE = 0x4000
+
+
+def macho_segment(name, vmaddr, vmsize, fileoff, filesize, prot, sections):
+    command = struct.pack("<II16sQQQQiiII", 0x19, 72 + 80 * len(sections), name.encode(), vmaddr, vmsize, fileoff, filesize, prot, prot, len(sections), 0)
+    for section in sections:
+        command += struct.pack("<16s16sQQIIIIIIII", *section)
+    return command
+
+
+MACHO_CPUS = {EM_AARCH64: (0x0100000c, 0), EM_X86_64: (0x01000007, 3)}
+LIBSYSTEM = "/usr/lib/libSystem.B.dylib"
+
+
+def macho_path_command(command, path, extra):
+    size = align(12 + len(extra) + len(path) + 1, 8)
+    body = struct.pack("<III", command, size, 12 + len(extra)) + extra + path.encode()
+    return body + bytes(size - len(body))
+
+
+def write_macho(obj):
+    if obj.machine not in MACHO_CPUS:
+        sys.exit("Only AArch64 and x86-64 objects can be made into Mach-O executables")
+    linked = link(obj, MACHO_BASE, MACHO_PAGE, MachOImports)
+    imports = linked.imports
+    addresses = linked.addresses
+
+    # .text, .rodata and .data become __text, __cstring or __const, and __data, in the segments they belong in
+    text = []
+    data = []
+    ordinals = {}
+    for i in linked.allocated:
+        section = obj.sections[i]
+        reserved = (0, 0)
+        if "macho" in section:
+            name, flags, *reserved = section["macho"]
+        elif section["flags"] & SHF_EXECINSTR:
+            name, flags = "__text", 0x80000400
+        elif section["flags"] & SHF_WRITE:
+            name, flags = ("__bss", 0x1) if section["type"] == SHT_NOBITS else ("__data", 0)
+        else:
+            name, flags = ("__cstring", 0x2) if section["flags"] & 0x20 else ("__const", 0)
+        segment = data if section["flags"] & SHF_WRITE else text
+        offset = 0 if flags == 0x1 else addresses[i] - MACHO_BASE
+        segment.append([name.encode(), b"__DATA" if segment is data else b"__TEXT", addresses[i], section["size"], offset, section["align"].bit_length() - 1, 0, 0, flags, *reserved, 0])
+        ordinals[i] = len(ordinals) + 1
+
+    out = bytearray(linked.end - MACHO_BASE)
+    for i in linked.allocated:
+        if obj.sections[i]["type"] != SHT_NOBITS:
+            out[addresses[i] - MACHO_BASE:addresses[i] - MACHO_BASE + len(linked.contents[i])] = linked.contents[i]
+
+    # Mapping symbols ($x, $d) are an ELF thing
+    symbols = bytearray()
+    strings = bytearray(b" \0")
+    local_count = 0
+    external_count = 0
+    for symbol, value in linked.symbols:
+        if symbol["name"].startswith("$"):
+            continue
+        external = symbol["info"] >> 4 != 0
+        if external:
+            external_count += 1
+        else:
+            local_count += 1
+        symbols += struct.pack("<IBBHQ", add_name(strings, symbol["name"]), 0xe | external, ordinals.get(symbol["shndx"], 0), 0, value)
+    # Imports come last, undefined, from the first (and only) dylib
+    undefined = imports.symbols if imports else []
+    for name in undefined:
+        symbols += struct.pack("<IBBHQ", add_name(strings, name), 0x1, 0, 1 << 8, 0)
+    indirect = b"".join(struct.pack("<I", local_count + external_count + undefined.index(name)) for name in imports.indirect) if imports else b""
+    strings += bytes(align(len(strings), 8) - len(strings))
+    linkedit = len(out)
+    out += symbols + indirect + strings
+
+    first_data = min([s[2] for s in data], default=linked.end)
+    commands = [
+        macho_segment("__PAGEZERO", 0, MACHO_BASE, 0, 0, 0, []),
+        macho_segment("__TEXT", MACHO_BASE, first_data - MACHO_BASE, 0, first_data - MACHO_BASE, 5, text),
+    ]
+    if data:
+        commands.append(macho_segment("__DATA", first_data, linked.end - first_data, first_data - MACHO_BASE, linked.end - first_data, 3, data))
+    commands += [
+        macho_segment("__LINKEDIT", linked.end, align(len(out) - linkedit, MACHO_PAGE), linkedit, len(out) - linkedit, 1, []),
+        struct.pack("<IIIIII", 0x2, 24, linkedit, len(symbols) // 16, linkedit + len(symbols) + len(indirect), len(strings)),
+        struct.pack("<II18I", 0xb, 80, 0, local_count, local_count, external_count, local_count + external_count, len(undefined), *[0] * 6, linkedit + len(symbols) if indirect else 0, len(indirect) // 4, *[0] * 4),
+        struct.pack("<IIQQ", 0x80000028, 24, linked.entry - MACHO_BASE, 0),
+    ]
+    if imports:
+        # LC_LOAD_DYLINKER and LC_LOAD_DYLIB
+        commands.append(macho_path_command(0xe, "/usr/lib/dyld", b""))
+        commands.append(macho_path_command(0xc, LIBSYSTEM, struct.pack("<III", 2, 0x10000, 0x10000)))
+    # MH_NOUNDEFS without imports, and MH_DYLDLINK | MH_TWOLEVEL with them
+    cputype, subtype = MACHO_CPUS[obj.machine]
+    header = struct.pack("<IiiIIIII", 0xfeedfacf, cputype, subtype, 2, len(commands), sum(len(c) for c in commands), 0x84 if imports else 1, 0)
+    loads = header + b"".join(commands)
+    if len(loads) > MACHO_PAGE:
+        sys.exit("Too many load commands")
+    out[:len(loads)] = loads
+
+    return out
+
+
+if __name__ == "__main__":
+    arguments = sys.argv[1:]
+    macho = "--macho" in arguments
+    if macho:
+        arguments.remove("--macho")
+    if len(arguments) != 2:
+        sys.exit(f"Usage: {sys.argv[0]} [--macho] input.o output")
+    with open(arguments[0], "rb") as f:
+        obj = Object(f.read())
+    with open(arguments[1], "wb") as f:
+        f.write(write_macho(obj) if macho else write_elf(obj))
@@ -0,0 +1,29 @@
+// arm64 Mach-O fixture that imports from libSystem: _puts and _fflush through __stubs, and ___stdoutp through __got.
+// It's written for an ELF assembler with Mach-O names, and link.py lays it out like ld64 would.
+// Rebuild with:
+//   llvm-mc -triple=aarch64-linux-gnu -filetype=obj imports_arm64.s -o imports_arm64.o
+//   python3 ../link.py --macho imports_arm64.o imports_arm64
+    .text
+    .globl _start
+_start:
+    bl _main
+    b _exit
+
+    .globl _main
+_main:
+    stp x29, x30, [sp, #-16]!
+    mov x29, sp
+    adrp x0, _message
+    add x0, x0, :lo12:_message
+    bl _puts
+    adrp x0, :got:___stdoutp
+    ldr x0, [x0, :got_lo12:___stdoutp]
+    ldr x0, [x0]
+    bl _fflush
+    mov w0, #0
+    ldp x29, x30, [sp], #16
+    ret
+
+    .section .rodata.str1.1, "aMS", @progbits, 1
+_message:
+    .asciz "Hello from arm64"
@@ -0,0 +1,29 @@
+# x86-64 Mach-O fixture that imports from libSystem: _puts and _fflush through __stubs, and ___stdoutp through __got.
+# It's written for an ELF assembler with Mach-O names, and link.py lays it out like ld64 would.
+# Rebuild with:
+#   llvm-mc -triple=x86_64-linux-gnu -filetype=obj imports_x86_64.s -o imports_x86_64.o
+#   python3 ../link.py --macho imports_x86_64.o imports_x86_64
+    .intel_syntax noprefix
+    .text
+    .globl _start
+_start:
+    call _main
+    mov edi, eax
+    jmp _exit
+
+    .globl _main
+_main:
+    push rbp
+    mov rbp, rsp
+    lea rdi, [rip + _message]
+    call _puts
+    mov rax, qword ptr [rip + ___stdoutp@GOTPCREL]
+    mov rdi, qword ptr [rax]
+    call _fflush
+    xor eax, eax
+    pop rbp
+    ret
+
+    .section .rodata.str1.1, "aMS", @progbits, 1
+_message:
+    .asciz "Hello from x86-64"
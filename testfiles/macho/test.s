@@ -0,0 +1,43 @@
+// arm64 Mach-O fixture, since there's no ld64 off a Mac. It's written for an ELF assembler with Mach-O names,
+// and link.py lays it out like a static Mach-O executable.
+// Rebuild with:
+//   llvm-mc -triple=aarch64-linux-gnu -filetype=obj test.s -o test.o
+//   python3 ../link.py --macho test.o test
+    .text
+    .globl _start
+_start:
+    bl _main
+    mov x16, #1
+    svc #0x80
+
+    .globl _add
+_add:
+    add w0, w0, w1
+    ret
+
+    .globl _main
+_main:
+    stp x29, x30, [sp, #-16]!
+    adrp x8, _x
+    ldr w0, [x8, :lo12:_x]
+    adrp x9, _y
+    ldr w1, [x9, :lo12:_y]
+    bl _add
+    adrp x8, _message
+    add x8, x8, :lo12:_message
+    ldp x29, x30, [sp], #16
+    ret
+
+    .section .rodata.str1.1, "aMS", @progbits, 1
+    .globl _message
+_message:
+    .asciz "hello"
+
+    .data
+    .p2align 2
+    .globl _x
+_x:
+    .word 5
+    .globl _y
+_y:
+    .word 3
@@ -0,0 +1,36 @@
+# PE32+ fixture, since there's no mingw in most Linux CI.
+# Rebuild with:
+#   llvm-dlltool -m i386:x86-64 -d msvcrt.def -l libmsvcrt.a
+#   llvm-mc -triple x86_64-pc-windows-gnu -filetype=obj test.s -o test.obj
+#   ld -m i386pep --entry main test.obj libmsvcrt.a -o test.exe
+.intel_syntax noprefix
+.text
+.globl add
+.globl main
+# The linker puts its own symbols at the start of each section, so keep them off add and the data
+padding:
+  ret
+add:
+  lea eax, [rcx+rdx]
+  ret
+main:
+  sub rsp, 40
+  mov ecx, 5
+  mov edx, 3
+  call add
+  mov [rip+x], eax
+  lea rcx, [rip+msg]
+  mov edx, [rip+x]
+  call printf
+  mov eax, [rip+status]
+  add rsp, 40
+  ret
+.section .rdata,"dr"
+.byte 0
+msg: .asciz "5 + 3 = %d\n"
+.data
+.quad 0
+status: .long 0
+.bss
+.quad 0
+x: .zero 4
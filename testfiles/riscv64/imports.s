@@ -0,0 +1,32 @@
+# RISC-V fixture that imports from libc.so.6: puts and fflush through the PLT and stdout through the GOT.
+# Rebuild with:
+#   riscv64-linux-gnu-as -mno-relax imports.s -o imports.o
+#   riscv64-linux-gnu-ld -dynamic-linker /lib/ld-linux-riscv64-lp64d.so.1 imports.o -lc -o imports
+# or, without the cross binutils:
+#   llvm-mc -triple=riscv64-linux-gnu -mattr=+c,-relax -filetype=obj imports.s -o imports.o
+#   python3 ../link.py imports.o imports
+    .text
+    .globl _start
+_start:
+    call main
+    tail exit
+
+    .globl main
+main:
+    addi sp, sp, -16
+    sd ra, 8(sp)
+    lla a0, message
+    call puts
+.Lstdout:
+    auipc a0, %got_pcrel_hi(stdout)
+    ld a0, %pcrel_lo(.Lstdout)(a0)
+    ld a0, 0(a0)
+    call fflush
+    li a0, 0
+    ld ra, 8(sp)
+    addi sp, sp, 16
+    ret
+
+    .section .rodata
+message:
+    .string "Hello from RISC-V"
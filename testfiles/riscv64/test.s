@@ -0,0 +1,64 @@
+# RISC-V fixture, since cross compilers aren't in most Linux CI. It has no libc, so _start makes the exit syscall.
+# Rebuild with:
+#   riscv64-linux-gnu-as -mno-relax test.s -o test.o
+#   riscv64-linux-gnu-ld test.o -o test
+# or, without the cross binutils:
+#   llvm-mc -triple=riscv64-linux-gnu -mattr=+c,-relax -filetype=obj test.s -o test.o
+#   python3 ../link.py test.o test
+    .text
+    .globl _start
+_start:
+    call main
+    li a7, 93
+    ecall
+
+    .globl add
+add:
+    addw a0, a0, a1
+    ret
+
+    # Sums the table, with a loop that branches back into the function
+    .globl sum
+sum:
+    lla a1, table
+    li a0, 0
+    li a2, 4
+1:
+    lw a3, 0(a1)
+    addw a0, a0, a3
+    addi a1, a1, 4
+    addi a2, a2, -1
+    bnez a2, 1b
+    ret
+
+    # One auipc for two loads of x
+    .globl main
+main:
+    addi sp, sp, -16
+    sd ra, 8(sp)
+.Lx:
+    auipc t0, %pcrel_hi(x)
+    lw a0, %pcrel_lo(.Lx)(t0)
+    lw a1, %pcrel_lo(.Lx)(t0)
+    call add
+    beqz a0, 2f
+    call sum
+2:
+    ld ra, 8(sp)
+    addi sp, sp, 16
+    ret
+
+    # Its own address, which no relocation can give back
+    .globl pc
+pc:
+    auipc a0, 0
+    ret
+
+    .data
+    .globl x
+    .p2align 2
+x:
+    .word 5
+    .globl table
+table:
+    .word 1, 2, 3, 4
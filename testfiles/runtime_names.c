@@ -0,0 +1,15 @@
+#include <stdio.h>
+
+// Named like functions libc.a exports, but these ones are the program's own
+int error(int code) {
+    return code * 2;
+}
+
+int send(int a, int b) {
+    return a - b;
+}
+
+int main() {
+    printf("%d\n", error(3) + send(5, 1));
+    return 0;
+}
@@ -0,0 +1,31 @@
+#include "stdio.h"
+
+namespace geo {
+    class Shape {
+    public:
+        virtual ~Shape() {}
+        virtual int area() const = 0;
+    };
+
+    class Square : public Shape {
+        int side;
+    public:
+        Square(int side) : side(side) {}
+        int area() const override { return side * side; }
+    };
+
+    int total(const Shape **shapes, int count) {
+        int sum = 0;
+        for (int i = 0; i < count; i++) {
+            sum += shapes[i]->area();
+        }
+        return sum;
+    }
+}
+
+int main() {
+    geo::Square a(3), b(4);
+    const geo::Shape *shapes[] = {&a, &b};
+    printf("%d\n", geo::total(shapes, 2));
+    return geo::total(shapes, 2) != 25;
+}
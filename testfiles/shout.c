@@ -0,0 +1,18 @@
+#include <stdio.h>
+
+// Same code, except for what they call, so only their calls tell them apart
+int shout(const char *message) {
+    return puts(message);
+}
+
+int whisper(const char *message) {
+    return remove(message);
+}
+
+int repeat(const char *message, int times) {
+    int total = 0;
+    for (int i = 0; i < times; i++) {
+        total += shout(message);
+    }
+    return total;
+}
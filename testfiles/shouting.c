@@ -0,0 +1,9 @@
+int shout(const char *message);
+int whisper(const char *message);
+int repeat(const char *message, int times);
+
+int main() {
+    repeat("hello", 3);
+    whisper("/nonexistent");
+    return shout("bye") < 0;
+}
@@ -0,0 +1,7 @@
+#include <stdio.h>
+
+// Patched over test.c's add
+int add(int a, int b) {
+    printf("patched\n");
+    return a - b;
+}
@@ -0,0 +1,11 @@
+// Thread-locals of the executable itself, and of a library
+__thread int counter = 5;
+extern __thread int shared;
+
+int bump(void) {
+    return ++counter;
+}
+
+int bump_shared(void) {
+    return ++shared;
+}
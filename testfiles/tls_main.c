@@ -0,0 +1,11 @@
+#include <stdio.h>
+
+int bump(void);
+int bump_shared(void);
+
+int main() {
+    bump();
+    bump_shared();
+    printf("%d\n", bump() + bump_shared());
+    return 0;
+}
@@ -0,0 +1 @@
+__thread int shared = 1;